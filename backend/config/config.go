@@ -3,17 +3,80 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/zven/bookpavilion/storage"
 	"gorm.io/gorm"
 )
 
 // Config holds all configuration for the application
 type Config struct {
 	DB        *gorm.DB
+	DBDriver  string
 	UploadDir string
+	IndexDir  string
+
+	// Storage of book files and covers, and the driver it was created with
+	Storage       storage.Storage
+	StorageDriver string
+
+	// Upload size limits in bytes; 0 means no limit
+	MaxFileSize        int64
+	FormatMaxFileSizes map[string]int64
+
+	// Size limit in bytes of an archive imported in bulk; 0 means no limit
+	MaxImportSize int64
+
+	// What to do with an upload identical to an existing book
+	DuplicateUploads string
+
+	// Resumable uploads in progress, and how long they are kept unfinished
+	TusDir        string
+	TusExpiration time.Duration
+
+	// How long deleted books stay in the trash; 0 keeps them until purged
+	TrashRetention time.Duration
+
+	// How often stored files are reconciled with the books table; 0 turns
+	// the scheduled run off. Scheduled runs compare file sizes, and only
+	// repair what they find with ReconcileRepair.
+	ReconcileInterval time.Duration
+	ReconcileRepair   bool
 }
 
+// DefaultMaxFileSize is the upload size limit when MAX_FILE_SIZE is not set
+const DefaultMaxFileSize = 100 << 20
+
+// DefaultMaxImportSize is the size limit of a bulk import archive when
+// MAX_IMPORT_SIZE is not set
+const DefaultMaxImportSize = 1 << 30
+
+// DefaultTusExpiration is how long a resumable upload is kept without
+// progress when TUS_EXPIRATION is not set
+const DefaultTusExpiration = 24 * time.Hour
+
+// DefaultTrashRetention is how long deleted books stay in the trash when
+// TRASH_RETENTION is not set
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// DefaultReconcileInterval is how often stored files are reconciled with
+// the books table when RECONCILE_INTERVAL is not set
+const DefaultReconcileInterval = 24 * time.Hour
+
+// Policies for uploads identical to an existing book, selected by
+// DUPLICATE_UPLOADS
+const (
+	DuplicateReject = "reject" // refuse the upload, pointing at the existing book
+	DuplicateShare  = "share"  // create a new book sharing the stored file
+)
+
+// formatSizePrefix starts the names of per-format size limit variables,
+// such as MAX_FILE_SIZE_PDF
+const formatSizePrefix = "MAX_FILE_SIZE_"
+
 var (
 	appConfig Config
 	once      sync.Once
@@ -30,6 +93,65 @@ func LoadConfig() error {
 			return
 		}
 		appConfig.UploadDir = uploadDir
+
+		// Full-text search index directory
+		appConfig.IndexDir = getEnv("INDEX_DIR", "./index")
+
+		// Upload size limits
+		appConfig.MaxFileSize, err = parseSize("MAX_FILE_SIZE", getEnv("MAX_FILE_SIZE", strconv.Itoa(DefaultMaxFileSize)))
+		if err != nil {
+			return
+		}
+		appConfig.FormatMaxFileSizes = make(map[string]int64)
+		for _, env := range os.Environ() {
+			key, value, _ := strings.Cut(env, "=")
+			if !strings.HasPrefix(key, formatSizePrefix) {
+				continue
+			}
+			format := strings.ToLower(strings.TrimPrefix(key, formatSizePrefix))
+			if appConfig.FormatMaxFileSizes[format], err = parseSize(key, value); err != nil {
+				return
+			}
+		}
+
+		// Bulk import archive size limit
+		appConfig.MaxImportSize, err = parseSize("MAX_IMPORT_SIZE", getEnv("MAX_IMPORT_SIZE", strconv.Itoa(DefaultMaxImportSize)))
+		if err != nil {
+			return
+		}
+
+		// Duplicate uploads
+		appConfig.DuplicateUploads = getEnv("DUPLICATE_UPLOADS", DuplicateReject)
+		if appConfig.DuplicateUploads != DuplicateReject && appConfig.DuplicateUploads != DuplicateShare {
+			err = fmt.Errorf("invalid DUPLICATE_UPLOADS: %q", appConfig.DuplicateUploads)
+			return
+		}
+
+		// Resumable uploads
+		appConfig.TusDir = getEnv("TUS_DIR", "./tus")
+		expiration := getEnv("TUS_EXPIRATION", DefaultTusExpiration.String())
+		if appConfig.TusExpiration, err = time.ParseDuration(expiration); err != nil || appConfig.TusExpiration <= 0 {
+			err = fmt.Errorf("invalid TUS_EXPIRATION: %q", expiration)
+			return
+		}
+
+		// Trash
+		retention := getEnv("TRASH_RETENTION", DefaultTrashRetention.String())
+		if appConfig.TrashRetention, err = time.ParseDuration(retention); err != nil || appConfig.TrashRetention < 0 {
+			err = fmt.Errorf("invalid TRASH_RETENTION: %q", retention)
+			return
+		}
+
+		// Storage reconciliation
+		interval := getEnv("RECONCILE_INTERVAL", DefaultReconcileInterval.String())
+		if appConfig.ReconcileInterval, err = time.ParseDuration(interval); err != nil || appConfig.ReconcileInterval < 0 {
+			err = fmt.Errorf("invalid RECONCILE_INTERVAL: %q", interval)
+			return
+		}
+		repair := getEnv("RECONCILE_REPAIR", "false")
+		if appConfig.ReconcileRepair, err = strconv.ParseBool(repair); err != nil {
+			err = fmt.Errorf("invalid RECONCILE_REPAIR: %q", repair)
+		}
 	})
 	return err
 }
@@ -39,21 +161,162 @@ func SetDB(db *gorm.DB) {
 	appConfig.DB = db
 }
 
+// SetStorage sets the storage of book files and covers
+func SetStorage(store storage.Storage) {
+	appConfig.Storage = store
+}
+
 // SetUploadDir sets the upload directory
 func SetUploadDir(dir string) {
 	appConfig.UploadDir = dir
 }
 
+// SetIndexDir sets the search index directory
+func SetIndexDir(dir string) {
+	appConfig.IndexDir = dir
+}
+
+// SetMaxFileSize sets the upload size limit of a format, or the global
+// limit if format is empty
+func SetMaxFileSize(format string, size int64) {
+	if format == "" {
+		appConfig.MaxFileSize = size
+		return
+	}
+	if appConfig.FormatMaxFileSizes == nil {
+		appConfig.FormatMaxFileSizes = make(map[string]int64)
+	}
+	appConfig.FormatMaxFileSizes[format] = size
+}
+
+// SetMaxImportSize sets the size limit of a bulk import archive
+func SetMaxImportSize(size int64) {
+	appConfig.MaxImportSize = size
+}
+
+// SetDuplicateUploads sets the policy for uploads identical to an existing book
+func SetDuplicateUploads(policy string) {
+	appConfig.DuplicateUploads = policy
+}
+
+// SetTusDir sets the directory of resumable uploads in progress
+func SetTusDir(dir string) {
+	appConfig.TusDir = dir
+}
+
+// SetTrashRetention sets how long deleted books stay in the trash
+func SetTrashRetention(retention time.Duration) {
+	appConfig.TrashRetention = retention
+}
+
+// SetReconcileInterval sets how often stored files are reconciled
+func SetReconcileInterval(interval time.Duration) {
+	appConfig.ReconcileInterval = interval
+}
+
+// SetReconcileRepair sets whether scheduled reconciliations repair what
+// they find
+func SetReconcileRepair(repair bool) {
+	appConfig.ReconcileRepair = repair
+}
+
 // GetDB returns the database instance
 func GetDB() *gorm.DB {
 	return appConfig.DB
 }
 
+// GetDBDriver returns the name of the database driver in use
+func GetDBDriver() string {
+	return appConfig.DBDriver
+}
+
+// GetStorage returns the storage of book files and covers
+func GetStorage() storage.Storage {
+	return appConfig.Storage
+}
+
+// GetStorageDriver returns the name of the storage driver in use
+func GetStorageDriver() string {
+	return appConfig.StorageDriver
+}
+
 // GetUploadDir returns the upload directory path
 func GetUploadDir() string {
 	return appConfig.UploadDir
 }
 
+// GetIndexDir returns the search index directory path
+func GetIndexDir() string {
+	return appConfig.IndexDir
+}
+
+// GetMaxFileSize returns the upload size limit of a format: the lower of
+// its own limit and the global one. It returns the global limit if format
+// is empty, and 0 if uploads are unlimited.
+func GetMaxFileSize(format string) int64 {
+	limit := appConfig.MaxFileSize
+	if size := appConfig.FormatMaxFileSizes[format]; size > 0 && (limit <= 0 || size < limit) {
+		limit = size
+	}
+	return limit
+}
+
+// GetMaxImportSize returns the size limit of a bulk import archive, or 0
+// if it is unlimited
+func GetMaxImportSize() int64 {
+	return appConfig.MaxImportSize
+}
+
+// GetDuplicateUploads returns the policy for uploads identical to an
+// existing book, DuplicateReject unless configured otherwise
+func GetDuplicateUploads() string {
+	if appConfig.DuplicateUploads == "" {
+		return DuplicateReject
+	}
+	return appConfig.DuplicateUploads
+}
+
+// GetTusDir returns the directory of resumable uploads in progress
+func GetTusDir() string {
+	return appConfig.TusDir
+}
+
+// GetTusExpiration returns how long a resumable upload is kept without
+// progress
+func GetTusExpiration() time.Duration {
+	if appConfig.TusExpiration <= 0 {
+		return DefaultTusExpiration
+	}
+	return appConfig.TusExpiration
+}
+
+// GetTrashRetention returns how long deleted books stay in the trash before
+// they are purged, or 0 if they are kept until purged by hand
+func GetTrashRetention() time.Duration {
+	return appConfig.TrashRetention
+}
+
+// GetReconcileInterval returns how often stored files are reconciled with
+// the books table, or 0 if they are only reconciled on request
+func GetReconcileInterval() time.Duration {
+	return appConfig.ReconcileInterval
+}
+
+// GetReconcileRepair reports whether scheduled reconciliations repair what
+// they find, rather than only logging it
+func GetReconcileRepair() bool {
+	return appConfig.ReconcileRepair
+}
+
+// parseSize reads a size in bytes from an environment variable
+func parseSize(key, value string) (int64, error) {
+	size, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", key, value)
+	}
+	return size, nil
+}
+
 // getEnv gets an environment variable or returns a default value
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
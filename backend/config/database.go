@@ -3,34 +3,120 @@ package config
 import (
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strconv"
 
+	"github.com/glebarez/sqlite"
+	"github.com/zven/bookpavilion/migrations"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"github.com/zven/bookpavilion/models"
 )
 
-// InitDB initializes the database connection
+// Database drivers selected by DB_DRIVER
+const (
+	DBMySQL    = "mysql"
+	DBPostgres = "postgres"
+	DBSQLite   = "sqlite"
+)
+
+// InitDB initializes the database connection and brings the schema up to
+// date. With MIGRATE_ON_START=false, pending migrations are not applied and
+// the server refuses to start until they are.
 func InitDB() error {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=%s&parseTime=True&loc=Local",
-		getEnv("DB_USER", "root"),
-		getEnv("DB_PASSWORD", "root"),
-		getEnv("DB_HOST", "localhost"),
-		getEnv("DB_PORT", "3306"),
-		getEnv("DB_NAME", "bookpavilion"),
-		getEnv("DB_CHARSET", "utf8mb4"),
-	)
-
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	db, err := OpenDB()
 	if err != nil {
-		return fmt.Errorf("failed to connect to database: %v", err)
+		return err
 	}
 
-	// Auto Migrate the schema
-	if err := db.AutoMigrate(&models.Book{}); err != nil {
-		return fmt.Errorf("failed to migrate database: %v", err)
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+	migrate, err := strconv.ParseBool(getEnv("MIGRATE_ON_START", "true"))
+	if err != nil {
+		return fmt.Errorf("invalid MIGRATE_ON_START: %q", getEnv("MIGRATE_ON_START", ""))
+	}
+	if migrate {
+		applied, err := migrator.Up()
+		if err != nil {
+			return fmt.Errorf("failed to migrate database: %v", err)
+		}
+		for _, migration := range applied {
+			log.Printf("Applied migration %s", migration)
+		}
+	} else {
+		pending, err := migrator.Pending()
+		if err != nil {
+			return fmt.Errorf("failed to check database schema: %v", err)
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("database schema is not up to date (pending migrations: %d); run `bookpavilion migrate up`", len(pending))
+		}
 	}
 
 	SetDB(db)
-	log.Println("Database connection established")
+	log.Printf("Database connection established (%s)", appConfig.DBDriver)
 	return nil
 }
+
+// OpenDB connects to the database selected by DB_DRIVER, leaving its
+// schema as it is
+func OpenDB() (*gorm.DB, error) {
+	driver := getEnv("DB_DRIVER", DBMySQL)
+	dialector, err := openDialector(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+	appConfig.DBDriver = driver
+	return db, nil
+}
+
+// openDialector builds the connection settings of a database driver from
+// the DB_* environment variables
+func openDialector(driver string) (gorm.Dialector, error) {
+	switch driver {
+	case DBMySQL:
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=%s&parseTime=True&loc=Local",
+			getEnv("DB_USER", "root"),
+			getEnv("DB_PASSWORD", "root"),
+			getEnv("DB_HOST", "localhost"),
+			getEnv("DB_PORT", "3306"),
+			getEnv("DB_NAME", "bookpavilion"),
+			getEnv("DB_CHARSET", "utf8mb4"),
+		)
+		return mysql.Open(dsn), nil
+	case DBPostgres:
+		dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+			getEnv("DB_HOST", "localhost"),
+			getEnv("DB_PORT", "5432"),
+			getEnv("DB_USER", "postgres"),
+			getEnv("DB_PASSWORD", "postgres"),
+			getEnv("DB_NAME", "bookpavilion"),
+			getEnv("DB_SSLMODE", "disable"),
+		)
+		return postgres.Open(dsn), nil
+	case DBSQLite:
+		path := getEnv("DB_PATH", "./bookpavilion.db")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %v", err)
+		}
+		return sqlite.Open(sqliteDSN(path)), nil
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER: %q", driver)
+	}
+}
+
+// sqliteDSN returns the connection string of an SQLite database file.
+// Write-ahead logging lets readers go on while a book is being saved, and
+// transactions take the write lock when they begin, so that concurrent
+// writers wait for each other instead of failing with "database is locked".
+func sqliteDSN(path string) string {
+	return path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_txlock=immediate"
+}
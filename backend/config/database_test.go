@@ -0,0 +1,47 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/zven/bookpavilion/models"
+)
+
+func TestInitDBSQLite(t *testing.T) {
+	defer Reset()
+	t.Setenv("DB_DRIVER", DBSQLite)
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "data", "bookpavilion.db"))
+
+	if err := InitDB(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db := GetDB()
+	if GetDBDriver() != DBSQLite {
+		t.Errorf("expected driver %q but got %q", DBSQLite, GetDBDriver())
+	}
+
+	// The schema is created and usable
+	book := models.Book{Title: "Test Book", Format: models.FormatPDF, FilePath: "test.pdf"}
+	if err := db.Create(&book).Error; err != nil {
+		t.Fatalf("failed to create book: %v", err)
+	}
+	var found models.Book
+	if err := db.First(&found, book.ID).Error; err != nil || found.Title != book.Title {
+		t.Errorf("expected book %q but got %+v (%v)", book.Title, found, err)
+	}
+	if !db.Migrator().HasIndex(&models.Book{}, "SHA256") {
+		t.Errorf("expected an index on books.sha256")
+	}
+
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+}
+
+func TestInitDBUnknownDriver(t *testing.T) {
+	defer Reset()
+	t.Setenv("DB_DRIVER", "oracle")
+
+	if err := InitDB(); err == nil {
+		t.Errorf("expected error but got none")
+	}
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/zven/bookpavilion/storage"
+)
+
+// Storage drivers selected by STORAGE_DRIVER
+const (
+	StorageLocal  = "local"
+	StorageS3     = "s3"
+	StorageMemory = "memory"
+)
+
+// InitStorage initializes the storage of book files and covers
+func InitStorage() error {
+	driver := getEnv("STORAGE_DRIVER", StorageLocal)
+
+	var store storage.Storage
+	var err error
+	switch driver {
+	case StorageLocal:
+		store, err = storage.NewLocal(GetUploadDir())
+	case StorageS3:
+		pathStyle, perr := strconv.ParseBool(getEnv("S3_PATH_STYLE", "false"))
+		if perr != nil {
+			return fmt.Errorf("invalid S3_PATH_STYLE: %q", getEnv("S3_PATH_STYLE", ""))
+		}
+		store, err = storage.NewS3(storage.S3Config{
+			Endpoint:  getEnv("S3_ENDPOINT", "https://s3.amazonaws.com"),
+			Region:    getEnv("S3_REGION", "us-east-1"),
+			Bucket:    getEnv("S3_BUCKET", ""),
+			AccessKey: getEnv("S3_ACCESS_KEY", ""),
+			SecretKey: getEnv("S3_SECRET_KEY", ""),
+			Prefix:    getEnv("S3_PREFIX", ""),
+			PathStyle: pathStyle,
+		})
+	case StorageMemory:
+		store = storage.NewMemory()
+	default:
+		return fmt.Errorf("unknown STORAGE_DRIVER: %q", driver)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage: %v", err)
+	}
+
+	appConfig.StorageDriver = driver
+	SetStorage(store)
+	log.Printf("Storage initialized (%s)", driver)
+	return nil
+}
@@ -1,11 +1,22 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/zven/bookpavilion/config"
 	"github.com/zven/bookpavilion/models"
+	"github.com/zven/bookpavilion/search"
 	"github.com/zven/bookpavilion/services"
 )
 
@@ -21,15 +32,22 @@ func NewBookController(bookService services.BookService) *BookController {
 	}
 }
 
-// CreateBook handles book creation request
+// CreateBook handles book creation request. The form is read as it
+// arrives, so a file over the size limit of its format is rejected as soon
+// as it passes the limit rather than after the whole body is received.
 func (c *BookController) CreateBook(ctx *gin.Context) {
-	// Get form data
-	title := ctx.PostForm("title")
-	author := ctx.PostForm("author")
+	if !limitUploadBody(ctx) {
+		return
+	}
 
-	// Get the uploaded file
-	file, err := ctx.FormFile("file")
+	// Get form data and the uploaded file
+	form, err := readUploadForm(ctx.Request, "title", "author")
 	if err != nil {
+		uploadFormError(ctx, form, err)
+		return
+	}
+	defer form.remove()
+	if form.path == "" {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"error": "No file uploaded",
 		})
@@ -37,9 +55,14 @@ func (c *BookController) CreateBook(ctx *gin.Context) {
 	}
 
 	// Create book using service
-	book, err := c.bookService.CreateBook(title, author, file)
+	book, err := c.bookService.CreateBookFromFile(form.values["title"], form.values["author"], form.file())
 	if err != nil {
+		if formatMismatch(ctx, err) || duplicateBook(ctx, err) {
+			return
+		}
 		switch err {
+		case models.ErrFileTooLarge:
+			fileTooLarge(ctx, uploadFormat(form.filename))
 		case models.ErrInvalidFormat:
 			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		case models.ErrTitleRequired:
@@ -53,6 +76,270 @@ func (c *BookController) CreateBook(ctx *gin.Context) {
 	ctx.JSON(http.StatusCreated, book)
 }
 
+// uploadForm is a multipart form carrying a book file, with the file
+// copied to a temporary file
+type uploadForm struct {
+	values   map[string]string // the fields read, by name
+	filename string
+	path     string // empty if the form has no file
+	size     int64
+}
+
+// readUploadForm reads a multipart form with an optional "file" field and
+// the named text fields, in any order; other fields are skipped. The file
+// is cut off once it passes the size limit of the format named by its
+// extension, and models.ErrFileTooLarge returned with the form, so the
+// caller knows the format. The caller must remove the form.
+func readUploadForm(r *http.Request, fields ...string) (*uploadForm, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		wanted[field] = true
+	}
+
+	form := &uploadForm{values: make(map[string]string)}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			form.remove()
+			return nil, err
+		}
+
+		name := part.FormName()
+		switch {
+		case name == "file" && part.FileName() != "" && form.path == "":
+			err = form.receive(part)
+		case wanted[name] && part.FileName() == "":
+			if _, ok := form.values[name]; !ok {
+				form.values[name], err = readFormValue(part)
+			}
+		}
+		// Stop reading at once on error; closing the part would read
+		// the rest of it
+		if err == models.ErrFileTooLarge {
+			return form, err
+		}
+		if err != nil {
+			form.remove()
+			return nil, err
+		}
+		part.Close()
+	}
+	return form, nil
+}
+
+// uploadFormError responds to a form that readUploadForm failed to read
+func uploadFormError(ctx *gin.Context, form *uploadForm, err error) {
+	var writeErr *os.PathError
+	switch {
+	case bodyTooLarge(err):
+		fileTooLarge(ctx, "")
+	case errors.As(err, &writeErr):
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to receive upload"})
+	case err == models.ErrFileTooLarge:
+		fileTooLarge(ctx, uploadFormat(form.filename))
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+	}
+}
+
+// receive copies the file of the form to a temporary file, stopping once
+// it passes the size limit of its format
+func (f *uploadForm) receive(part *multipart.Part) error {
+	f.filename = part.FileName()
+	limit := config.GetMaxFileSize(string(uploadFormat(f.filename)))
+
+	tmp, err := os.CreateTemp("", "bookpavilion-upload-*")
+	if err != nil {
+		return err
+	}
+	f.path = tmp.Name()
+
+	src := io.Reader(part)
+	if limit > 0 {
+		src = io.LimitReader(part, limit+1)
+	}
+	f.size, err = io.Copy(tmp, src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && limit > 0 && f.size > limit {
+		err = models.ErrFileTooLarge
+	}
+	if err != nil {
+		os.Remove(f.path)
+		f.path = ""
+	}
+	return err
+}
+
+// file returns the received file for the book service, or nil if the form
+// has none
+func (f *uploadForm) file() *services.UploadedFile {
+	if f.path == "" {
+		return nil
+	}
+	return &services.UploadedFile{
+		Filename: f.filename,
+		Size:     f.size,
+		Open: func() (multipart.File, error) {
+			return os.Open(f.path)
+		},
+	}
+}
+
+// remove deletes the temporary file of the form
+func (f *uploadForm) remove() {
+	if f.path != "" {
+		os.Remove(f.path)
+	}
+}
+
+// readFormValue reads the value of a form field, which can't be longer
+// than the upload overhead
+func readFormValue(part *multipart.Part) (string, error) {
+	value, err := io.ReadAll(io.LimitReader(part, uploadOverhead))
+	return string(value), err
+}
+
+// uploadOverhead is the room allowed in an upload request body, beyond the
+// file size limit, for the form fields and multipart headers
+const uploadOverhead = 1 << 20
+
+// limitUploadBody caps the request body at the global upload size limit,
+// so that larger uploads fail while being read rather than filling the
+// disk. A request declaring a larger body is rejected with 413 before any
+// of it is read, and false is returned.
+func limitUploadBody(ctx *gin.Context) bool {
+	if !limitBody(ctx, config.GetMaxFileSize("")) {
+		fileTooLarge(ctx, "")
+		return false
+	}
+	return true
+}
+
+// limitBody caps the request body at limit bytes of file plus the upload
+// overhead. It returns false, leaving the response to the caller, if the
+// request declares a larger body.
+func limitBody(ctx *gin.Context, limit int64) bool {
+	if limit <= 0 {
+		return true
+	}
+	if ctx.Request.ContentLength > limit+uploadOverhead {
+		return false
+	}
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, limit+uploadOverhead)
+	return true
+}
+
+// bodyTooLarge reports whether err comes from a request body cut off by
+// limitUploadBody
+func bodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// fileTooLarge responds with 413 Request Entity Too Large and the size
+// limit of the format, or the global limit if the format isn't known
+func fileTooLarge(ctx *gin.Context, format models.BookFormat) {
+	body := gin.H{
+		"error":    models.ErrFileTooLarge.Error(),
+		"max_size": config.GetMaxFileSize(string(format)),
+	}
+	if format != "" {
+		body["format"] = format
+	}
+	ctx.JSON(http.StatusRequestEntityTooLarge, body)
+}
+
+// archiveTooLarge responds with 413 Request Entity Too Large and the size
+// limit of import archives
+func archiveTooLarge(ctx *gin.Context) {
+	ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{
+		"error":    models.ErrArchiveTooLarge.Error(),
+		"max_size": config.GetMaxImportSize(),
+	})
+}
+
+// uploadFormat returns the format named by the extension of an uploaded
+// file name
+func uploadFormat(filename string) models.BookFormat {
+	return models.BookFormat(strings.TrimPrefix(filepath.Ext(filename), "."))
+}
+
+// formatMismatch responds with 415 Unsupported Media Type if err reports
+// an upload whose content doesn't match its extension
+func formatMismatch(ctx *gin.Context, err error) bool {
+	var mismatch *models.FormatMismatchError
+	if !errors.As(err, &mismatch) {
+		return false
+	}
+	ctx.JSON(http.StatusUnsupportedMediaType, gin.H{
+		"error":           err.Error(),
+		"declared_format": mismatch.Declared,
+		"detected_type":   mismatch.Detected,
+	})
+	return true
+}
+
+// duplicateBook responds with 409 Conflict if err reports an upload whose
+// content is identical to an existing book, pointing at that book
+func duplicateBook(ctx *gin.Context, err error) bool {
+	var duplicate *models.DuplicateBookError
+	if !errors.As(err, &duplicate) {
+		return false
+	}
+	ctx.Header("Location", fmt.Sprintf("/api/books/%d", duplicate.BookID))
+	ctx.JSON(http.StatusConflict, gin.H{
+		"error":   err.Error(),
+		"book_id": duplicate.BookID,
+	})
+	return true
+}
+
+// ImportBooks handles bulk import of the books in an uploaded ZIP or tar.gz
+// archive. Every file is reported on separately, and a file that can't
+// become a book doesn't stop the others.
+func (c *BookController) ImportBooks(ctx *gin.Context) {
+	if !limitBody(ctx, config.GetMaxImportSize()) {
+		archiveTooLarge(ctx)
+		return
+	}
+
+	file, err := ctx.FormFile("file")
+	if err != nil {
+		if bodyTooLarge(err) {
+			archiveTooLarge(ctx)
+			return
+		}
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "No file uploaded",
+		})
+		return
+	}
+
+	report, err := c.bookService.ImportBooks(file)
+	if err != nil {
+		switch err {
+		case models.ErrArchiveTooLarge:
+			archiveTooLarge(ctx)
+		case models.ErrInvalidArchive:
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import books"})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
 // GetBook handles single book retrieval request
 func (c *BookController) GetBook(ctx *gin.Context) {
 	// Parse book ID from URL
@@ -74,42 +361,472 @@ func (c *BookController) GetBook(ctx *gin.Context) {
 
 // ListBooks handles book list request
 func (c *BookController) ListBooks(ctx *gin.Context) {
-	// Parse pagination parameters
-	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(ctx.DefaultQuery("page_size", "10"))
+	query, err := parseBookQuery(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Get books using service
-	books, total, err := c.bookService.ListBooks(page, pageSize)
+	books, total, err := c.bookService.ListBooks(query)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch books"})
+		switch err {
+		case models.ErrInvalidFormat, models.ErrInvalidSortField, models.ErrInvalidSortOrder,
+			models.ErrInvalidSizeRange, models.ErrInvalidDateRange:
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch books"})
+		}
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"books": books,
 		"total": total,
-		"page":  page,
-		"size":  pageSize,
+		"page":  query.Page,
+		"size":  query.PageSize,
 	})
 }
 
+// SearchBooks handles full-text search across the contents of all books
+func (c *BookController) SearchBooks(ctx *gin.Context) {
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(ctx.DefaultQuery("page_size", "10"))
+
+	result, err := c.bookService.SearchBooks(ctx.Query("q"), page, pageSize)
+	if err != nil {
+		switch err {
+		case models.ErrEmptyQuery:
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search books"})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, result)
+}
+
+// parseBookQuery reads the list parameters: page, page_size, q, format
+// (comma separated), author, min_size, max_size, created_from, created_to,
+// sort and order. Dates are RFC 3339 timestamps or YYYY-MM-DD days, and
+// created_to includes the whole of a given day.
+func parseBookQuery(ctx *gin.Context) (*models.BookQuery, error) {
+	// Parse pagination parameters
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(ctx.DefaultQuery("page_size", "10"))
+
+	query := &models.BookQuery{
+		Page:     page,
+		PageSize: pageSize,
+		Keyword:  ctx.Query("q"),
+		Author:   ctx.Query("author"),
+		SortBy:   ctx.Query("sort"),
+		Order:    ctx.Query("order"),
+	}
+
+	if formats := ctx.Query("format"); formats != "" {
+		for _, format := range strings.Split(formats, ",") {
+			query.Formats = append(query.Formats, models.BookFormat(strings.ToLower(strings.TrimSpace(format))))
+		}
+	}
+
+	var err error
+	if query.MinSize, err = parseSize(ctx.Query("min_size")); err != nil {
+		return nil, models.ErrInvalidSizeRange
+	}
+	if query.MaxSize, err = parseSize(ctx.Query("max_size")); err != nil {
+		return nil, models.ErrInvalidSizeRange
+	}
+
+	if from := ctx.Query("created_from"); from != "" {
+		t, _, err := parseDate(from)
+		if err != nil {
+			return nil, models.ErrInvalidDateRange
+		}
+		query.CreatedFrom = &t
+	}
+	if to := ctx.Query("created_to"); to != "" {
+		t, day, err := parseDate(to)
+		if err != nil {
+			return nil, models.ErrInvalidDateRange
+		}
+		if day {
+			t = t.AddDate(0, 0, 1)
+		} else {
+			t = t.Add(time.Nanosecond)
+		}
+		query.CreatedBefore = &t
+	}
+
+	return query, nil
+}
+
+// parseSize parses an optional size in bytes
+func parseSize(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// parseDate parses an RFC 3339 timestamp or a YYYY-MM-DD day, reporting
+// whether it was a day
+func parseDate(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	return t, true, err
+}
+
+// GetBookContent handles book content request. Paged formats such as PDF
+// return their text page by page.
 func (c *BookController) GetBookContent(ctx *gin.Context) {
-    // Parse book ID from URL
-    id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
-    if err != nil {
-        ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
-        return
-    }
-
-    // Get book content using service
-    content, err := c.bookService.GetBookContent(uint(id))
-    if err != nil {
-        ctx.JSON(http.StatusNotFound, gin.H{"error": "Book content not found"})
-        return
-    }
-
-    ctx.JSON(http.StatusOK, content)
+	// Parse book ID from URL
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+
+	// Page through a chapter
+	if ctx.Query("chapter") != "" {
+		c.getChapterPage(ctx, uint(id))
+		return
+	}
+
+	// Read a range of the content
+	if ctx.Query("offset") != "" || ctx.Query("limit") != "" {
+		c.getContentRange(ctx, uint(id))
+		return
+	}
+
+	// Get book content using service
+	content, err := c.bookService.GetBookContent(uint(id))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Book content not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, content)
+}
+
+// getContentRange handles GET /api/books/:id/content?offset=&limit=
+func (c *BookController) getContentRange(ctx *gin.Context, id uint) {
+	offset, err := strconv.ParseInt(ctx.DefaultQuery("offset", "0"), 10, 64)
+	if err != nil || offset < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "0"))
+	if err != nil || limit < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	chunk, err := c.bookService.GetBookContentRange(id, offset, limit)
+	if err != nil {
+		c.contentChunkError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, chunk)
+}
+
+// getChapterPage handles GET /api/books/:id/content?chapter=&page=&page_size=
+func (c *BookController) getChapterPage(ctx *gin.Context, id uint) {
+	chapter, err := strconv.Atoi(ctx.Query("chapter"))
+	if err != nil || chapter < 1 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chapter number"})
+		return
+	}
+	page, err := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page number"})
+		return
+	}
+	pageSize, err := strconv.Atoi(ctx.DefaultQuery("page_size", "0"))
+	if err != nil || pageSize < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page size"})
+		return
+	}
+
+	chunk, err := c.bookService.GetChapterPage(id, chapter, page, pageSize)
+	if err != nil {
+		c.contentChunkError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, chunk)
+}
+
+// contentChunkError writes the response for a failed content chunk request
+func (c *BookController) contentChunkError(ctx *gin.Context, err error) {
+	switch err {
+	case models.ErrInvalidRange:
+		ctx.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"error": err.Error()})
+	case models.ErrChapterNotFound:
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Book content not found"})
+	}
+}
+
+// SearchInBook handles search within one book. The case_sensitive,
+// whole_word and regex flags select how q is matched.
+func (c *BookController) SearchInBook(ctx *gin.Context) {
+	// Parse book ID from URL
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+
+	var opts search.Options
+	flags := map[string]*bool{
+		"case_sensitive": &opts.CaseSensitive,
+		"whole_word":     &opts.WholeWord,
+		"regex":          &opts.Regexp,
+	}
+	for name, flag := range flags {
+		if value := ctx.Query(name); value != "" {
+			if *flag, err = strconv.ParseBool(value); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name + " parameter"})
+				return
+			}
+		}
+	}
+
+	result, err := c.bookService.SearchInBook(uint(id), ctx.Query("q"), opts)
+	if err != nil {
+		switch err {
+		case models.ErrEmptyQuery, models.ErrInvalidPattern:
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case models.ErrBookNotFound:
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Book content not found"})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, result)
+}
+
+// GetBookTOC handles table of contents request
+func (c *BookController) GetBookTOC(ctx *gin.Context) {
+	// Parse book ID from URL
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+
+	// Get table of contents using service
+	chapters, err := c.bookService.GetBookTOC(uint(id))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Book content not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"book_id":  id,
+		"chapters": chapters,
+	})
+}
+
+// GetBookChapter handles single chapter request
+func (c *BookController) GetBookChapter(ctx *gin.Context) {
+	// Parse book ID and chapter number from URL
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+	number, err := strconv.Atoi(ctx.Param("n"))
+	if err != nil || number < 1 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chapter number"})
+		return
+	}
+
+	// Get chapter using service
+	chapter, err := c.bookService.GetBookChapter(uint(id), number)
+	if err != nil {
+		switch err {
+		case models.ErrChapterNotFound:
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Book content not found"})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, chapter)
 }
+
+// GetBookFile serves the original book file. Range, If-None-Match,
+// If-Modified-Since and If-Range requests are handled by http.ServeContent.
+func (c *BookController) GetBookFile(ctx *gin.Context) {
+	// Parse book ID from URL
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+
+	file, err := c.bookService.OpenBookFile(uint(id))
+	if err != nil {
+		switch err {
+		case models.ErrFileNotFound:
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		}
+		return
+	}
+	defer file.Content.Close()
+
+	book := file.Book
+	contentType := book.Format.ContentType()
+	if book.Format == models.FormatTXT && book.Encoding != "" {
+		contentType = mime.FormatMediaType(contentType, map[string]string{"charset": book.Encoding})
+	}
+
+	disposition := "inline"
+	if ctx.Query("download") != "" {
+		disposition = "attachment"
+	}
+
+	header := ctx.Writer.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{
+		"filename": downloadFilename(book),
+	}))
+	header.Set("ETag", fmt.Sprintf(`"%x-%x-%x"`, book.ID, file.Size, file.ModTime.UnixNano()))
+	header.Set("Cache-Control", "private, no-cache")
+
+	http.ServeContent(ctx.Writer, ctx.Request, "", file.ModTime, file.Content)
+}
+
+// GetBookCover serves a cover thumbnail of a book. The size query parameter
+// is one of small, medium or large.
+func (c *BookController) GetBookCover(ctx *gin.Context) {
+	// Parse book ID from URL
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+
+	cover, err := c.bookService.GetBookCover(uint(id), ctx.Query("size"))
+	if err != nil {
+		switch err {
+		case models.ErrInvalidCoverSize:
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case models.ErrCoverNotFound:
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		}
+		return
+	}
+
+	defer cover.Content.Close()
+
+	ctx.Header("Content-Type", "image/jpeg")
+	ctx.Header("Cache-Control", "public, max-age=86400")
+	http.ServeContent(ctx.Writer, ctx.Request, "", cover.ModTime, cover.Content)
+}
+
+// downloadFilename builds the file name offered to clients from the book
+// title and format
+func downloadFilename(book *models.Book) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20 || r == 0x7F:
+			return -1
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(book.Title))
+	if name == "" {
+		name = fmt.Sprintf("book-%d", book.ID)
+	}
+	return name + "." + string(book.Format)
+}
+
+// UpdateBook handles PUT and PATCH requests that change a book. Fields
+// left out of the request keep their values. A JSON body changes metadata
+// only; a multipart form may also carry a new version of the file.
+func (c *BookController) UpdateBook(ctx *gin.Context) {
+	// Parse book ID from URL
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+
+	var update models.BookUpdate
+	var file *services.UploadedFile
+	if ctx.ContentType() == gin.MIMEMultipartPOSTForm {
+		if !limitUploadBody(ctx) {
+			return
+		}
+		fields := map[string]**string{
+			"title":       &update.Title,
+			"author":      &update.Author,
+			"language":    &update.Language,
+			"publisher":   &update.Publisher,
+			"isbn":        &update.ISBN,
+			"description": &update.Description,
+		}
+		names := make([]string, 0, len(fields))
+		for field := range fields {
+			names = append(names, field)
+		}
+		form, err := readUploadForm(ctx.Request, names...)
+		if err != nil {
+			uploadFormError(ctx, form, err)
+			return
+		}
+		defer form.remove()
+		for field, value := range fields {
+			if v, ok := form.values[field]; ok {
+				*value = &v
+			}
+		}
+		file = form.file()
+	} else if err := ctx.ShouldBindJSON(&update); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	// Update book using service
+	book, err := c.bookService.UpdateBook(uint(id), &update, file)
+	if err != nil {
+		if formatMismatch(ctx, err) || duplicateBook(ctx, err) {
+			return
+		}
+		switch err {
+		case models.ErrBookNotFound:
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case models.ErrFileTooLarge:
+			fileTooLarge(ctx, uploadFormat(file.Filename))
+		case models.ErrTitleRequired, models.ErrTitleTooLong, models.ErrAuthorTooLong, models.ErrLanguageTooLong,
+			models.ErrPublisherTooLong, models.ErrISBNTooLong, models.ErrInvalidFormat:
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update book"})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, book)
+}
+
+// DeleteBook handles book deletion request. The book is moved to the trash.
 func (c *BookController) DeleteBook(ctx *gin.Context) {
 	// Parse book ID from URL
 	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
@@ -120,9 +837,109 @@ func (c *BookController) DeleteBook(ctx *gin.Context) {
 
 	// Delete book using service
 	if err := c.bookService.DeleteBook(uint(id)); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete book"})
+		switch err {
+		case models.ErrBookNotFound:
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete book"})
+		}
 		return
 	}
 
 	ctx.Status(http.StatusNoContent)
 }
+
+// ListTrash handles the list of deleted books
+func (c *BookController) ListTrash(ctx *gin.Context) {
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(ctx.DefaultQuery("page_size", "10"))
+	page, pageSize = models.ClampPage(page, pageSize)
+
+	books, total, err := c.bookService.ListTrash(page, pageSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deleted books"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"books": books,
+		"total": total,
+		"page":  page,
+		"size":  pageSize,
+	})
+}
+
+// RestoreBook handles moving a deleted book out of the trash
+func (c *BookController) RestoreBook(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+
+	book, err := c.bookService.RestoreBook(uint(id))
+	if err != nil {
+		if duplicateBook(ctx, err) {
+			return
+		}
+		switch err {
+		case models.ErrBookNotInTrash:
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore book"})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, book)
+}
+
+// PurgeBook handles deleting a book in the trash for good, files included
+func (c *BookController) PurgeBook(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+		return
+	}
+
+	if err := c.bookService.PurgeBook(uint(id)); err != nil {
+		switch err {
+		case models.ErrBookNotInTrash:
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge book"})
+		}
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// ReconcileStorage handles checking stored files against the books. The
+// run only reports what it finds unless repair is set, and compares file
+// sizes only unless hash is set.
+func (c *BookController) ReconcileStorage(ctx *gin.Context) {
+	var opts models.ReconcileOptions
+	for name, value := range map[string]*bool{"repair": &opts.Repair, "hash": &opts.Hash} {
+		if param := ctx.Query(name); param != "" {
+			var err error
+			if *value, err = strconv.ParseBool(param); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name + " parameter"})
+				return
+			}
+		}
+	}
+
+	report, err := c.bookService.ReconcileStorage(opts)
+	if err != nil {
+		switch err {
+		case models.ErrReconcileRunning:
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile storage"})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,243 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zven/bookpavilion/config"
+	"github.com/zven/bookpavilion/models"
+	"github.com/zven/bookpavilion/services"
+)
+
+// tus protocol version and the extensions implemented by UploadController
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,termination"
+)
+
+// tusContentType is the media type of PATCH request bodies
+const tusContentType = "application/offset+octet-stream"
+
+// UploadController handles resumable uploads following the tus 1.0
+// protocol (https://tus.io/protocols/resumable-upload)
+type UploadController struct {
+	uploadService services.UploadService
+}
+
+// NewUploadController creates a new instance of UploadController
+func NewUploadController(uploadService services.UploadService) *UploadController {
+	return &UploadController{
+		uploadService: uploadService,
+	}
+}
+
+// tusResumable sets the protocol version on the response and checks that
+// the request uses it, responding with 412 Precondition Failed otherwise
+func tusResumable(ctx *gin.Context) bool {
+	ctx.Header("Tus-Resumable", tusVersion)
+	if ctx.GetHeader("Tus-Resumable") != tusVersion {
+		ctx.Header("Tus-Version", tusVersion)
+		ctx.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "unsupported tus version"})
+		return false
+	}
+	return true
+}
+
+// Options describes the protocol support of the server
+func (c *UploadController) Options(ctx *gin.Context) {
+	ctx.Header("Tus-Resumable", tusVersion)
+	ctx.Header("Tus-Version", tusVersion)
+	ctx.Header("Tus-Extension", tusExtensions)
+	if limit := config.GetMaxFileSize(""); limit > 0 {
+		ctx.Header("Tus-Max-Size", strconv.FormatInt(limit, 10))
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// CreateUpload starts a resumable upload. Upload-Length gives the file
+// size and Upload-Metadata must carry the file name; title and author may
+// be given too.
+func (c *UploadController) CreateUpload(ctx *gin.Context) {
+	if !tusResumable(ctx) {
+		return
+	}
+
+	length, err := strconv.ParseInt(ctx.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Length"})
+		return
+	}
+	metadata, err := parseUploadMetadata(ctx.GetHeader("Upload-Metadata"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Metadata"})
+		return
+	}
+
+	upload, err := c.uploadService.CreateUpload(length, metadata)
+	if err != nil {
+		switch err {
+		case models.ErrFileTooLarge:
+			fileTooLarge(ctx, uploadFormat(metadata["filename"]))
+		case models.ErrFilenameRequired, models.ErrInvalidFormat:
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload"})
+		}
+		return
+	}
+
+	ctx.Header("Location", "/api/uploads/"+upload.ID)
+	ctx.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	ctx.Status(http.StatusCreated)
+}
+
+// HeadUpload reports the offset reached by an upload
+func (c *UploadController) HeadUpload(ctx *gin.Context) {
+	if !tusResumable(ctx) {
+		return
+	}
+
+	upload, err := c.uploadService.GetUpload(ctx.Param("id"))
+	if err != nil {
+		uploadError(ctx, err)
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-store")
+	setUploadHeaders(ctx, upload)
+	ctx.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if len(upload.Metadata) > 0 {
+		ctx.Header("Upload-Metadata", formatUploadMetadata(upload.Metadata))
+	}
+	ctx.Status(http.StatusOK)
+}
+
+// PatchUpload appends a chunk to an upload at the offset given by
+// Upload-Offset. The chunk that completes the file creates the book, whose
+// ID is returned in the Book-Id header. If that fails with a server error
+// the file is kept, and a request at the end of the upload tries again.
+func (c *UploadController) PatchUpload(ctx *gin.Context) {
+	if !tusResumable(ctx) {
+		return
+	}
+
+	if ctx.ContentType() != tusContentType {
+		ctx.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be " + tusContentType})
+		return
+	}
+	offset, err := strconv.ParseInt(ctx.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Offset"})
+		return
+	}
+
+	upload, err := c.uploadService.WriteUpload(ctx.Param("id"), offset, ctx.Request.Body)
+	if err != nil {
+		uploadError(ctx, err)
+		return
+	}
+
+	setUploadHeaders(ctx, upload)
+	ctx.Status(http.StatusNoContent)
+}
+
+// DeleteUpload abandons an upload and deletes what was received
+func (c *UploadController) DeleteUpload(ctx *gin.Context) {
+	if !tusResumable(ctx) {
+		return
+	}
+
+	if err := c.uploadService.DeleteUpload(ctx.Param("id")); err != nil {
+		uploadError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// setUploadHeaders sets the offset and expiry of an upload on the response,
+// and the book created from it once complete
+func setUploadHeaders(ctx *gin.Context, upload *models.Upload) {
+	ctx.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.BookID != 0 {
+		ctx.Header("Book-Id", strconv.FormatUint(uint64(upload.BookID), 10))
+	} else {
+		ctx.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// uploadError responds to a failed upload request. Errors from creating
+// the book of a completed upload get the same responses as a book upload.
+func uploadError(ctx *gin.Context, err error) {
+	if formatMismatch(ctx, err) || duplicateBook(ctx, err) {
+		return
+	}
+	switch err {
+	case models.ErrUploadNotFound:
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case models.ErrUploadExpired:
+		ctx.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case models.ErrUploadOffsetMismatch:
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case models.ErrUploadLocked:
+		ctx.JSON(http.StatusLocked, gin.H{"error": err.Error()})
+	case models.ErrFileTooLarge:
+		fileTooLarge(ctx, "")
+	case models.ErrInvalidFormat, models.ErrTitleRequired:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process upload"})
+	}
+}
+
+// parseUploadMetadata decodes an Upload-Metadata header: comma-separated
+// pairs of a key and a base64-encoded value. The value may be left out.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("invalid metadata pair %q", pair)
+		}
+		key := fields[0]
+		if _, ok := metadata[key]; ok {
+			return nil, fmt.Errorf("duplicate metadata key %q", key)
+		}
+		value := ""
+		if len(fields) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid metadata value for %q: %v", key, err)
+			}
+			value = string(decoded)
+		}
+		metadata[key] = value
+	}
+	return metadata, nil
+}
+
+// formatUploadMetadata encodes metadata as an Upload-Metadata header
+func formatUploadMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if metadata[key] == "" {
+			pairs = append(pairs, key)
+		} else {
+			pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(metadata[key])))
+		}
+	}
+	return strings.Join(pairs, ",")
+}
@@ -3,14 +3,25 @@ package main
 import (
 	"log"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/zven/bookpavilion/config"
 	"github.com/zven/bookpavilion/controllers"
+	"github.com/zven/bookpavilion/models"
+	"github.com/zven/bookpavilion/search"
 	"github.com/zven/bookpavilion/services"
 )
 
 func main() {
+	// Manage the database schema instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Load configuration
 	if err := config.LoadConfig(); err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
@@ -21,16 +32,77 @@ func main() {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	// Initialize storage of book files
+	if err := config.InitStorage(); err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+
 	// Set Gin mode based on environment
 	if os.Getenv("GIN_MODE") == "release" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	// Open the full-text search index
+	index, err := search.Open(config.GetIndexDir())
+	if err != nil {
+		log.Fatalf("Failed to open search index: %v", err)
+	}
+
 	// Initialize services
-	bookService := services.NewBookService(config.GetDB())
+	bookService := services.NewBookService(config.GetDB(), config.GetStorage(), index)
+
+	// Index books added while the index was unavailable
+	go func() {
+		if err := bookService.SyncSearchIndex(); err != nil {
+			log.Printf("Failed to sync search index: %v", err)
+		}
+	}()
+
+	// Purge books kept in the trash past the retention period
+	go func() {
+		for range time.Tick(time.Hour) {
+			if _, err := bookService.PurgeTrash(); err != nil {
+				log.Printf("Failed to purge trash: %v", err)
+			}
+		}
+	}()
+
+	// Reconcile stored files with the books by size; hashing every file is
+	// left to runs requested by hand
+	if interval := config.GetReconcileInterval(); interval > 0 {
+		opts := models.ReconcileOptions{Repair: config.GetReconcileRepair()}
+		go func() {
+			for range time.Tick(interval) {
+				report, err := bookService.ReconcileStorage(opts)
+				if err != nil {
+					log.Printf("Failed to reconcile storage: %v", err)
+					continue
+				}
+				if len(report.Issues) > 0 {
+					log.Printf("Storage reconciliation: %d orphan files, %d missing files, %d mismatched files",
+						report.Orphans, report.Missing, report.Mismatches)
+				}
+			}
+		}()
+	}
+
+	uploadService, err := services.NewUploadService(config.GetTusDir(), config.GetTusExpiration(), bookService)
+	if err != nil {
+		log.Fatalf("Failed to initialize resumable uploads: %v", err)
+	}
+
+	// Discard resumable uploads abandoned by their clients
+	go func() {
+		for range time.Tick(time.Hour) {
+			if _, err := uploadService.PurgeExpiredUploads(); err != nil {
+				log.Printf("Failed to purge expired uploads: %v", err)
+			}
+		}
+	}()
 
 	// Initialize controllers
 	bookController := controllers.NewBookController(bookService)
+	uploadController := controllers.NewUploadController(uploadService)
 
 	// Set up Gin router
 	r := gin.Default()
@@ -38,9 +110,14 @@ func main() {
 	// Enable CORS
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, Authorization")
-		if c.Request.Method == "OPTIONS" {
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, Authorization, Range, If-None-Match, If-Modified-Since, If-Range, "+
+			"Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Content-Disposition, Accept-Ranges, ETag, Last-Modified, "+
+			"Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Length, Upload-Metadata, Upload-Offset, Upload-Expires, Book-Id")
+		// Answer CORS preflight requests here; other OPTIONS requests reach
+		// their routes, such as tus discovery on /api/uploads
+		if c.Request.Method == "OPTIONS" && c.GetHeader("Access-Control-Request-Method") != "" {
 			c.AbortWithStatus(204)
 			return
 		}
@@ -50,9 +127,6 @@ func main() {
 	// Set maximum multipart form size (default is 32 MB)
 	r.MaxMultipartMemory = 8 << 20 // 8 MB
 
-	// Serve static files (uploaded books)
-	r.Static("/uploads", config.GetUploadDir())
-
 	// API routes
 	api := r.Group("/api")
 	{
@@ -60,12 +134,47 @@ func main() {
 		books := api.Group("/books")
 		{
 			books.POST("", bookController.CreateBook)
+			books.POST("/import", bookController.ImportBooks)
 			books.GET("", bookController.ListBooks)
 			books.GET("/:id", bookController.GetBook)
+			books.PUT("/:id", bookController.UpdateBook)
+			books.PATCH("/:id", bookController.UpdateBook)
 			books.DELETE("/:id", bookController.DeleteBook)
-			books.GET("/:id/content", bookController.GetBookContent) // Add this line
+			books.GET("/:id/file", bookController.GetBookFile)
+			books.GET("/:id/cover", bookController.GetBookCover)
+			books.GET("/:id/content", bookController.GetBookContent)
+			books.GET("/:id/toc", bookController.GetBookTOC)
+			books.GET("/:id/chapters/:n", bookController.GetBookChapter)
+			books.GET("/:id/search", bookController.SearchInBook)
+			books.POST("/:id/restore", bookController.RestoreBook)
+		}
+
+		// Deleted books
+		trash := api.Group("/trash")
+		{
+			trash.GET("", bookController.ListTrash)
+			trash.DELETE("/:id", bookController.PurgeBook)
 		}
 
+		// Resumable uploads (tus 1.0)
+		uploads := api.Group("/uploads")
+		{
+			uploads.OPTIONS("", uploadController.Options)
+			uploads.POST("", uploadController.CreateUpload)
+			uploads.HEAD("/:id", uploadController.HeadUpload)
+			uploads.PATCH("/:id", uploadController.PatchUpload)
+			uploads.DELETE("/:id", uploadController.DeleteUpload)
+		}
+
+		// Administration
+		admin := api.Group("/admin")
+		{
+			admin.POST("/reconcile", bookController.ReconcileStorage)
+		}
+
+		// Full-text search
+		api.GET("/search", bookController.SearchBooks)
+
 		// Health check
 		api.GET("/health", func(c *gin.Context) {
 			c.JSON(200, gin.H{
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zven/bookpavilion/config"
+	"github.com/zven/bookpavilion/migrations"
+)
+
+// migrateUsage describes the migrate command
+const migrateUsage = `usage: bookpavilion migrate <command>
+
+commands:
+  status    list the migrations and whether they are applied
+  up        apply the pending migrations
+  down [n]  roll back the last n applied migrations (default 1)`
+
+// runMigrate runs the migrate command on the database configured by the
+// DB_* environment variables
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	db, err := config.OpenDB()
+	if err != nil {
+		return err
+	}
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format(time.RFC3339)
+			}
+			name := status.Name
+			if status.Unknown {
+				name += " (unknown to this build)"
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, name, applied)
+		}
+		return w.Flush()
+
+	case "up":
+		applied, err := migrator.Up()
+		for _, migration := range applied {
+			fmt.Printf("Applied %s\n", migration)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("Database is up to date")
+		}
+		return nil
+
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+				return fmt.Errorf("invalid number of migrations: %q", args[1])
+			}
+		}
+		rolledBack, err := migrator.Down(n)
+		for _, migration := range rolledBack {
+			fmt.Printf("Rolled back %s\n", migration)
+		}
+		if err != nil {
+			return err
+		}
+		if len(rolledBack) == 0 {
+			fmt.Println("No migration to roll back")
+		}
+		return nil
+
+	default:
+		return errors.New(migrateUsage)
+	}
+}
@@ -0,0 +1,85 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// initialBook is the books table of the first migration. Unlike
+// models.Book it never changes, so that adopting a database always leads
+// to the schema the later migrations start from.
+type initialBook struct {
+	ID               uint      `gorm:"primarykey"`
+	Title            string    `gorm:"size:200;not null;index"`
+	Author           string    `gorm:"size:100;index"`
+	Format           string    `gorm:"size:10;not null"`
+	FilePath         string    `gorm:"size:500;not null"`
+	FileSize         int64     `gorm:"not null"`
+	Encoding         string    `gorm:"size:20"`
+	Language         string    `gorm:"size:20"`
+	Publisher        string    `gorm:"size:200"`
+	ISBN             string    `gorm:"column:isbn;size:20"`
+	Description      string    `gorm:"type:text"`
+	CoverPath        string    `gorm:"size:500"`
+	OriginalFilename string    `gorm:"size:255"`
+	SHA256           string    `gorm:"column:sha256;size:64;index"`
+	FileIssue        string    `gorm:"size:20"`
+	CreatedAt        time.Time `gorm:"index"`
+	UpdatedAt        time.Time
+	DeletedAt        gorm.DeletedAt `gorm:"index"`
+}
+
+func (initialBook) TableName() string {
+	return "books"
+}
+
+// initialBlob is the blobs table of the first migration
+type initialBlob struct {
+	Hash      string `gorm:"primaryKey;size:64"`
+	Size      int64  `gorm:"not null"`
+	RefCount  int64  `gorm:"not null;default:0"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (initialBlob) TableName() string {
+	return "blobs"
+}
+
+// adopt brings a database created before migrations existed up to the
+// schema of the first migration. The books table of the baseline init.sql
+// or AutoMigrate lacks the columns added since, and the blobs table may
+// be missing. Only what is missing is added; existing columns and rows are
+// left as they are.
+func adopt(conn *gorm.DB) error {
+	migrator := conn.Migrator()
+
+	stmt := &gorm.Statement{DB: conn}
+	if err := stmt.Parse(&initialBook{}); err != nil {
+		return err
+	}
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName == "" || migrator.HasColumn(&initialBook{}, field.DBName) {
+			continue
+		}
+		if err := migrator.AddColumn(&initialBook{}, field.Name); err != nil {
+			return err
+		}
+	}
+	for _, index := range stmt.Schema.ParseIndexes() {
+		if migrator.HasIndex(&initialBook{}, index.Name) {
+			continue
+		}
+		if err := migrator.CreateIndex(&initialBook{}, index.Name); err != nil {
+			return err
+		}
+	}
+
+	if !migrator.HasTable(&initialBlob{}) {
+		if err := migrator.CreateTable(&initialBlob{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
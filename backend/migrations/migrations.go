@@ -0,0 +1,447 @@
+package migrations
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// files holds the migrations of every database, one directory per driver.
+// A migration is a pair of files, "<version>_<name>.up.sql" applying it and
+// "<version>_<name>.down.sql" rolling it back.
+//
+//go:embed mysql postgres sqlite
+var files embed.FS
+
+// fileName matches the name of a migration file
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// table records the migrations applied to a database
+const table = "schema_migrations"
+
+// createTable creates the migration table; the same statement works with
+// every driver
+const createTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+)`
+
+// Migration lock, so that only one process migrates a database at a time
+const (
+	lockName    = "bookpavilion_migrations" // MySQL named lock
+	lockKey     = 4820551632147711          // PostgreSQL advisory lock key
+	lockTimeout = time.Minute
+	lockRetry   = 500 * time.Millisecond
+)
+
+// ErrLocked is returned when another process holds the migration lock for
+// longer than the lock timeout
+var ErrLocked = errors.New("another process is migrating the database")
+
+// UnknownVersionError is returned when the database has a migration applied
+// that this build doesn't know, as after going back to an older build
+type UnknownVersionError struct {
+	Version int64
+}
+
+func (e *UnknownVersionError) Error() string {
+	return fmt.Sprintf("database has migration %04d applied, which this build doesn't know; "+
+		"roll it back with the build that added it", e.Version)
+}
+
+// Migration is one version of the schema, with the statements that apply
+// it and roll it back
+type Migration struct {
+	Version int64
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// String returns the migration's file name without its suffix
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// Status tells whether a migration is applied to the database
+type Status struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time // nil while the migration is pending
+	Unknown   bool       // applied, but not part of this build
+}
+
+// record is a row of the migration table
+type record struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (record) TableName() string {
+	return table
+}
+
+// Migrator applies and rolls back the migrations of a database
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// New creates a Migrator for db, with the migrations of its driver
+func New(db *gorm.DB) (*Migrator, error) {
+	migrations, err := load(db.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Migrations returns the migrations of the database's driver, in version
+// order
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Status describes every migration of this build, and any migration
+// applied to the database that this build doesn't know, in version order
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied(m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if rec, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &rec.AppliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, rec := range applied {
+		appliedAt := rec.AppliedAt
+		statuses = append(statuses, Status{Version: rec.Version, Name: rec.Name, AppliedAt: &appliedAt, Unknown: true})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Pending returns the migrations not applied to the database yet. It fails
+// with an UnknownVersionError if the database is ahead of this build.
+func (m *Migrator) Pending() ([]Migration, error) {
+	applied, err := m.applied(m.db)
+	if err != nil {
+		return nil, err
+	}
+	return m.pending(applied)
+}
+
+// Up applies the pending migrations in version order and returns them.
+// A database created before migrations existed, with a books table but no
+// migration table, is taken to be at the first version.
+func (m *Migrator) Up() ([]Migration, error) {
+	var done []Migration
+	err := m.locked(func(conn *gorm.DB) error {
+		if err := m.createTable(conn); err != nil {
+			return err
+		}
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		pending, err := m.pending(applied)
+		if err != nil {
+			return err
+		}
+		for _, migration := range pending {
+			if err := m.run(conn, migration, true); err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down rolls back the last n applied migrations, latest first, and returns
+// them
+func (m *Migrator) Down(n int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int64, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		for _, version := range versions {
+			if len(done) == n {
+				break
+			}
+			migration, ok := m.find(version)
+			if !ok {
+				return &UnknownVersionError{Version: version}
+			}
+			if err := m.run(conn, migration, false); err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// pending returns the migrations missing from applied
+func (m *Migrator) pending(applied map[int64]record) ([]Migration, error) {
+	for version := range applied {
+		if _, ok := m.find(version); !ok {
+			return nil, &UnknownVersionError{Version: version}
+		}
+	}
+	var pending []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// find returns the migration with the given version
+func (m *Migrator) find(version int64) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+// applied reads the migration table. A database without one has nothing
+// applied.
+func (m *Migrator) applied(db *gorm.DB) (map[int64]record, error) {
+	applied := make(map[int64]record)
+	if !db.Migrator().HasTable(table) {
+		return applied, nil
+	}
+	var records []record
+	if err := db.Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %v", err)
+	}
+	for _, rec := range records {
+		applied[rec.Version] = rec
+	}
+	return applied, nil
+}
+
+// createTable creates the migration table if it doesn't exist. If the
+// books table exists already, the database was created by init.sql or
+// AutoMigrate: it is brought up to the schema of the first migration,
+// which is then recorded without running it.
+func (m *Migrator) createTable(conn *gorm.DB) error {
+	if conn.Migrator().HasTable(table) {
+		return nil
+	}
+	existing := conn.Migrator().HasTable("books")
+	if existing {
+		if err := adopt(conn); err != nil {
+			return fmt.Errorf("failed to upgrade existing schema: %v", err)
+		}
+	}
+	if err := conn.Exec(createTable).Error; err != nil {
+		return fmt.Errorf("failed to create migration table: %v", err)
+	}
+	if existing && len(m.migrations) > 0 {
+		first := m.migrations[0]
+		rec := record{Version: first.Version, Name: first.Name, AppliedAt: time.Now()}
+		if err := conn.Create(&rec).Error; err != nil {
+			return fmt.Errorf("failed to record existing schema: %v", err)
+		}
+	}
+	return nil
+}
+
+// run applies or rolls back a migration in a transaction, and records it.
+// PostgreSQL and SQLite roll back a failed migration entirely; MySQL
+// commits each schema change on its own, so a failed migration may have
+// to be cleaned up by hand.
+func (m *Migrator) run(conn *gorm.DB, migration Migration, up bool) error {
+	action, statements := "apply", migration.Up
+	if !up {
+		action, statements = "roll back", migration.Down
+	}
+	return conn.Transaction(func(tx *gorm.DB) error {
+		// Check again in the transaction: SQLite has no migration lock,
+		// but its transactions hold the write lock from the start
+		var count int64
+		if err := tx.Model(&record{}).Where("version = ?", migration.Version).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to read applied migrations: %v", err)
+		}
+		if (count > 0) == up {
+			return nil
+		}
+
+		for _, stmt := range statements {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return fmt.Errorf("failed to %s migration %s: %v", action, migration, err)
+			}
+		}
+
+		var err error
+		if up {
+			err = tx.Create(&record{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+		} else {
+			err = tx.Delete(&record{}, migration.Version).Error
+		}
+		if err != nil {
+			return fmt.Errorf("failed to record migration %s: %v", migration, err)
+		}
+		return nil
+	})
+}
+
+// locked runs fn on a single connection holding the migration lock
+func (m *Migrator) locked(fn func(conn *gorm.DB) error) error {
+	return m.db.Connection(func(conn *gorm.DB) error {
+		unlock, err := lock(conn)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		return fn(conn)
+	})
+}
+
+// lock takes the migration lock on a connection, waiting up to the lock
+// timeout, and returns the function releasing it. MySQL and PostgreSQL
+// hold the lock for the session; SQLite needs none, as it serializes
+// writing transactions.
+func lock(conn *gorm.DB) (func(), error) {
+	switch conn.Dialector.Name() {
+	case "mysql":
+		var got *int64
+		if err := conn.Raw("SELECT GET_LOCK(?, ?)", lockName, int(lockTimeout.Seconds())).Scan(&got).Error; err != nil {
+			return nil, fmt.Errorf("failed to take migration lock: %v", err)
+		}
+		if got == nil || *got != 1 {
+			return nil, ErrLocked
+		}
+		return func() {
+			var released *int64
+			conn.Raw("SELECT RELEASE_LOCK(?)", lockName).Scan(&released)
+		}, nil
+	case "postgres":
+		deadline := time.Now().Add(lockTimeout)
+		for {
+			var got bool
+			if err := conn.Raw("SELECT pg_try_advisory_lock(?)", lockKey).Scan(&got).Error; err != nil {
+				return nil, fmt.Errorf("failed to take migration lock: %v", err)
+			}
+			if got {
+				break
+			}
+			if time.Now().After(deadline) {
+				return nil, ErrLocked
+			}
+			time.Sleep(lockRetry)
+		}
+		return func() {
+			var released bool
+			conn.Raw("SELECT pg_advisory_unlock(?)", lockKey).Scan(&released)
+		}, nil
+	default:
+		return func() {}, nil
+	}
+}
+
+// load reads the migrations of a driver from the embedded files
+func load(driver string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, driver)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for database driver %q", driver)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	seen := make(map[string]bool) // "<version>.<direction>"
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s/%s", driver, entry.Name())
+		}
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		name, direction := match[2], match[3]
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", migration, name, version)
+		}
+
+		data, err := fs.ReadFile(files, path.Join(driver, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %v", entry.Name(), err)
+		}
+		if direction == "up" {
+			migration.Up = splitStatements(string(data))
+		} else {
+			migration.Down = splitStatements(string(data))
+		}
+		seen[fmt.Sprintf("%d.%s", version, direction)] = true
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		for _, direction := range []string{"up", "down"} {
+			if !seen[fmt.Sprintf("%d.%s", migration.Version, direction)] {
+				return nil, fmt.Errorf("migration %s/%s has no %s file", driver, migration, direction)
+			}
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// splitStatements splits a migration file into statements. A statement
+// ends with a semicolon at the end of a line, and lines starting with "--"
+// are comments.
+func splitStatements(sql string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(sql, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "--") || (trimmed == "" && current.Len() == 0) {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
@@ -0,0 +1,266 @@
+package migrations
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/zven/bookpavilion/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB opens an empty SQLite database
+func openTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	t.Cleanup(func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	})
+	return db
+}
+
+// newTestMigrator creates a Migrator for an empty SQLite database
+func newTestMigrator(t *testing.T) (*Migrator, *gorm.DB) {
+	db := openTestDB(t)
+	migrator, err := New(db)
+	if err != nil {
+		t.Fatalf("Failed to create migrator: %v", err)
+	}
+	return migrator, db
+}
+
+// names lists the names of migrations
+func names(migrations []Migration) []string {
+	names := make([]string, len(migrations))
+	for i, migration := range migrations {
+		names[i] = migration.String()
+	}
+	return names
+}
+
+func TestLoad(t *testing.T) {
+	// Every driver has the same migrations
+	var versions []string
+	for _, driver := range []string{"mysql", "postgres", "sqlite"} {
+		migrations, err := load(driver)
+		if err != nil {
+			t.Fatalf("Failed to load %s migrations: %v", driver, err)
+		}
+		if len(migrations) == 0 || len(migrations[0].Up) == 0 || len(migrations[0].Down) == 0 {
+			t.Errorf("expected %s migrations with statements but got %+v", driver, migrations)
+		}
+		if versions == nil {
+			versions = names(migrations)
+		} else if got := names(migrations); !reflect.DeepEqual(got, versions) {
+			t.Errorf("expected %s migrations %v but got %v", driver, versions, got)
+		}
+	}
+
+	if _, err := load("oracle"); err == nil {
+		t.Errorf("expected error for unknown driver but got none")
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	sql := `-- A comment; not a statement
+CREATE TABLE t (
+    a INT, -- trailing comment
+    b INT
+);
+
+CREATE INDEX idx_t_a ON t(a);
+-- Only a comment
+UPDATE t SET b = 1`
+
+	expected := []string{
+		"CREATE TABLE t (\n    a INT, -- trailing comment\n    b INT\n)",
+		"CREATE INDEX idx_t_a ON t(a)",
+		"UPDATE t SET b = 1",
+	}
+	if got := splitStatements(sql); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected statements %q but got %q", expected, got)
+	}
+	if got := splitStatements("-- Nothing to do\n"); len(got) != 0 {
+		t.Errorf("expected no statements but got %q", got)
+	}
+}
+
+func TestUpDown(t *testing.T) {
+	migrator, db := newTestMigrator(t)
+	all := names(migrator.Migrations())
+
+	applied, err := migrator.Up()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := names(applied); !reflect.DeepEqual(got, all) {
+		t.Errorf("expected applied migrations %v but got %v", all, got)
+	}
+
+	// The schema has a column for every field of the models
+	for _, model := range []interface{}{&models.Book{}, &models.Blob{}} {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatalf("Failed to parse model: %v", err)
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !db.Migrator().HasColumn(model, field.DBName) {
+				t.Errorf("expected column %s.%s", stmt.Schema.Table, field.DBName)
+			}
+		}
+	}
+	book := models.Book{Title: "Test Book", Format: models.FormatPDF, FilePath: "test.pdf"}
+	if err := db.Create(&book).Error; err != nil {
+		t.Errorf("failed to create book: %v", err)
+	}
+
+	// Nothing is left to apply
+	if applied, err := migrator.Up(); err != nil || len(applied) != 0 {
+		t.Errorf("expected nothing applied but got %v (%v)", names(applied), err)
+	}
+
+	rolledBack, err := migrator.Down(1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := names(rolledBack); !reflect.DeepEqual(got, all[len(all)-1:]) {
+		t.Errorf("expected rolled back migrations %v but got %v", all[len(all)-1:], got)
+	}
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, status := range statuses {
+		if pending := i == len(statuses)-1; (status.AppliedAt == nil) != pending {
+			t.Errorf("unexpected status %+v", status)
+		}
+	}
+
+	// Rolling back more than is applied rolls back everything
+	if rolledBack, err = migrator.Down(len(all) + 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rolledBack) != len(all)-1 || db.Migrator().HasTable("books") {
+		t.Errorf("expected every migration rolled back but got %v", names(rolledBack))
+	}
+	if pending, err := migrator.Pending(); err != nil || len(pending) != len(all) {
+		t.Errorf("expected %d pending migrations but got %v (%v)", len(all), names(pending), err)
+	}
+}
+
+// baselineSchema is the books table created by init.sql before
+// migrations existed, in SQLite syntax
+const baselineSchema = `CREATE TABLE books (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    title VARCHAR(200) NOT NULL,
+    author VARCHAR(100),
+    format VARCHAR(10) NOT NULL,
+    file_path VARCHAR(500) NOT NULL,
+    file_size INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    deleted_at DATETIME
+);
+CREATE INDEX idx_books_deleted_at ON books(deleted_at);
+INSERT INTO books (title, format, file_path, file_size) VALUES ('Old Book', 'pdf', 'old.pdf', 100);`
+
+func TestAdoptExistingSchema(t *testing.T) {
+	migrator, db := newTestMigrator(t)
+
+	// A database created before migrations existed
+	for _, stmt := range splitStatements(baselineSchema) {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("Failed to create schema: %v", err)
+		}
+	}
+
+	applied, err := migrator.Up()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	all := names(migrator.Migrations())
+	if got := names(applied); !reflect.DeepEqual(got, all[1:]) {
+		t.Errorf("expected applied migrations %v but got %v", all[1:], got)
+	}
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("expected migration %04d to be applied", status.Version)
+		}
+	}
+
+	// The schema has a column for every field of the models
+	for _, model := range []interface{}{&models.Book{}, &models.Blob{}} {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatalf("Failed to parse model: %v", err)
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !db.Migrator().HasColumn(model, field.DBName) {
+				t.Errorf("expected column %s.%s", stmt.Schema.Table, field.DBName)
+			}
+		}
+	}
+	for _, index := range []string{"idx_books_title", "idx_books_sha256"} {
+		if !db.Migrator().HasIndex(&models.Book{}, index) {
+			t.Errorf("expected index %s", index)
+		}
+	}
+
+	// Existing books are kept, and new ones can be stored
+	var old models.Book
+	if err := db.First(&old).Error; err != nil || old.Title != "Old Book" {
+		t.Errorf("expected the existing book but got %+v (%v)", old, err)
+	}
+	book := models.Book{
+		Title: "Test Book", Format: models.FormatPDF, FilePath: "test.pdf", FileSize: 100,
+		Language: "en", ISBN: "9780000000000", SHA256: "abc", OriginalFilename: "test.pdf",
+	}
+	if err := db.Create(&book).Error; err != nil {
+		t.Errorf("failed to create book: %v", err)
+	}
+	if err := db.Create(&models.Blob{Hash: "abc", Size: 100, RefCount: 1}).Error; err != nil {
+		t.Errorf("failed to create blob: %v", err)
+	}
+}
+
+func TestUnknownVersion(t *testing.T) {
+	migrator, db := newTestMigrator(t)
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Applied by a newer build
+	if err := db.Create(&record{Version: 9999, Name: "future", AppliedAt: time.Now()}).Error; err != nil {
+		t.Fatalf("Failed to record migration: %v", err)
+	}
+
+	var unknown *UnknownVersionError
+	if _, err := migrator.Up(); !errors.As(err, &unknown) || unknown.Version != 9999 {
+		t.Errorf("expected unknown version error but got %v", err)
+	}
+	if _, err := migrator.Down(1); !errors.As(err, &unknown) {
+		t.Errorf("expected unknown version error but got %v", err)
+	}
+
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	last := statuses[len(statuses)-1]
+	if last.Version != 9999 || !last.Unknown || last.AppliedAt == nil {
+		t.Errorf("expected the unknown migration last but got %+v", last)
+	}
+}
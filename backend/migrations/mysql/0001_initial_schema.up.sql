@@ -0,0 +1,35 @@
+-- Books and the files they are stored in
+CREATE TABLE books (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    title VARCHAR(200) NOT NULL,
+    author VARCHAR(100),
+    format VARCHAR(10) NOT NULL,
+    file_path VARCHAR(500) NOT NULL,
+    file_size BIGINT UNSIGNED NOT NULL,
+    encoding VARCHAR(20),
+    language VARCHAR(20),
+    publisher VARCHAR(200),
+    isbn VARCHAR(20),
+    description TEXT,
+    cover_path VARCHAR(500),
+    original_filename VARCHAR(255),
+    sha256 CHAR(64),
+    file_issue VARCHAR(20),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_books_title ON books(title);
+CREATE INDEX idx_books_author ON books(author);
+CREATE INDEX idx_books_created_at ON books(created_at);
+CREATE INDEX idx_books_deleted_at ON books(deleted_at);
+CREATE INDEX idx_books_sha256 ON books(sha256);
+
+CREATE TABLE blobs (
+    hash CHAR(64) PRIMARY KEY,
+    size BIGINT NOT NULL,
+    ref_count BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1 @@
+-- Nothing to undo: the columns are NOT NULL from 0001 on
@@ -0,0 +1,4 @@
+-- Databases created by AutoMigrate left format and file_path nullable
+UPDATE books SET format = '' WHERE format IS NULL;
+UPDATE books SET file_path = '' WHERE file_path IS NULL;
+ALTER TABLE books MODIFY format VARCHAR(10) NOT NULL, MODIFY file_path VARCHAR(500) NOT NULL;
@@ -0,0 +1,35 @@
+-- Books and the files they are stored in
+CREATE TABLE books (
+    id BIGSERIAL PRIMARY KEY,
+    title VARCHAR(200) NOT NULL,
+    author VARCHAR(100),
+    format VARCHAR(10) NOT NULL,
+    file_path VARCHAR(500) NOT NULL,
+    file_size BIGINT NOT NULL,
+    encoding VARCHAR(20),
+    language VARCHAR(20),
+    publisher VARCHAR(200),
+    isbn VARCHAR(20),
+    description TEXT,
+    cover_path VARCHAR(500),
+    original_filename VARCHAR(255),
+    sha256 VARCHAR(64),
+    file_issue VARCHAR(20),
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_books_title ON books(title);
+CREATE INDEX idx_books_author ON books(author);
+CREATE INDEX idx_books_created_at ON books(created_at);
+CREATE INDEX idx_books_deleted_at ON books(deleted_at);
+CREATE INDEX idx_books_sha256 ON books(sha256);
+
+CREATE TABLE blobs (
+    hash VARCHAR(64) PRIMARY KEY,
+    size BIGINT NOT NULL,
+    ref_count BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
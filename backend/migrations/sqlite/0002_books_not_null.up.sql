@@ -0,0 +1,4 @@
+-- SQLite can't make existing columns NOT NULL without rebuilding the
+-- table. Databases created from 0001 already have the constraint; those
+-- created by AutoMigrate keep nullable columns, which the application
+-- never leaves empty.
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Blob 按内容哈希存储的图书文件，内容相同的图书共用一份
+type Blob struct {
+	Hash      string    `gorm:"primaryKey;size:64" json:"hash"` // SHA-256，小写十六进制
+	Size      int64     `json:"size"`
+	RefCount  int64     `gorm:"not null;default:0" json:"ref_count"` // 引用该文件的图书数，为 0 时删除
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -1,7 +1,10 @@
 package models
 
 import (
+	"strings"
 	"time"
+	"unicode/utf8"
+
 	"gorm.io/gorm"
 )
 
@@ -13,19 +16,75 @@ const (
 	FormatEPUB BookFormat = "epub"
 	FormatTXT  BookFormat = "txt"
 	FormatMOBI BookFormat = "mobi"
+	FormatAZW3 BookFormat = "azw3"
+	FormatCBZ  BookFormat = "cbz"
 )
 
+// ContentType 返回该格式文件的 MIME 类型
+func (f BookFormat) ContentType() string {
+	switch f {
+	case FormatPDF:
+		return "application/pdf"
+	case FormatEPUB:
+		return "application/epub+zip"
+	case FormatTXT:
+		return "text/plain"
+	case FormatMOBI:
+		return "application/x-mobipocket-ebook"
+	case FormatAZW3:
+		return "application/vnd.amazon.ebook"
+	case FormatCBZ:
+		return "application/vnd.comicbook+zip"
+	default:
+		return "application/octet-stream"
+	}
+}
+
 // Book 图书模型
 type Book struct {
-	ID        uint           `gorm:"primarykey" json:"id"`
-	Title     string         `gorm:"size:200;not null" json:"title"`
-	Author    string         `gorm:"size:100" json:"author"`
-	Format    BookFormat     `gorm:"size:10" json:"format"`
-	FilePath  string         `gorm:"size:500" json:"file_path"`
-	FileSize  int64          `json:"file_size"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID               uint           `gorm:"primarykey" json:"id"`
+	Title            string         `gorm:"size:200;not null" json:"title"`
+	Author           string         `gorm:"size:100" json:"author"`
+	Format           BookFormat     `gorm:"size:10" json:"format"`
+	FilePath         string         `gorm:"size:500" json:"file_path"`
+	FileSize         int64          `json:"file_size"`
+	Encoding         string         `gorm:"size:20" json:"encoding,omitempty"` // TXT 文本编码，首次读取时检测
+	Language         string         `gorm:"size:20" json:"language,omitempty"`
+	Publisher        string         `gorm:"size:200" json:"publisher,omitempty"`
+	ISBN             string         `gorm:"column:isbn;size:20" json:"isbn,omitempty"`
+	Description      string         `gorm:"type:text" json:"description,omitempty"`
+	CoverPath        string         `gorm:"size:500" json:"cover_path,omitempty"`                // 封面缩略图路径前缀，为空表示没有封面
+	OriginalFilename string         `gorm:"size:255" json:"original_filename,omitempty"`         // 上传时的文件名，已清理，仅供展示
+	SHA256           string         `gorm:"column:sha256;size:64;index" json:"sha256,omitempty"` // 文件内容的 SHA-256，对应 Blob.Hash
+	FileIssue        FileIssue      `gorm:"size:20" json:"file_issue,omitempty"`                 // 存储核对发现的文件问题，为空表示正常
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// BookUpdate 图书信息修改请求，为 nil 的字段保持不变
+type BookUpdate struct {
+	Title       *string `json:"title"`
+	Author      *string `json:"author"`
+	Language    *string `json:"language"`
+	Publisher   *string `json:"publisher"`
+	ISBN        *string `json:"isbn"`
+	Description *string `json:"description"`
+}
+
+// Apply 将修改应用到图书上
+func (u *BookUpdate) Apply(b *Book) {
+	set := func(field *string, value *string) {
+		if value != nil {
+			*field = strings.TrimSpace(*value)
+		}
+	}
+	set(&b.Title, u.Title)
+	set(&b.Author, u.Author)
+	set(&b.Language, u.Language)
+	set(&b.Publisher, u.Publisher)
+	set(&b.ISBN, u.ISBN)
+	set(&b.Description, u.Description)
 }
 
 // TableName 指定表名
@@ -44,5 +103,20 @@ func (b *Book) Validate() error {
 	if b.FilePath == "" {
 		return ErrFilePathRequired
 	}
+	if utf8.RuneCountInString(b.Title) > 200 {
+		return ErrTitleTooLong
+	}
+	if utf8.RuneCountInString(b.Author) > 100 {
+		return ErrAuthorTooLong
+	}
+	if utf8.RuneCountInString(b.Language) > 20 {
+		return ErrLanguageTooLong
+	}
+	if utf8.RuneCountInString(b.Publisher) > 200 {
+		return ErrPublisherTooLong
+	}
+	if utf8.RuneCountInString(b.ISBN) > 20 {
+		return ErrISBNTooLong
+	}
 	return nil
 }
@@ -0,0 +1,58 @@
+package models
+
+import "strings"
+
+// BookContent 图书内容
+type BookContent struct {
+	BookID  uint       `json:"book_id"`
+	Format  BookFormat `json:"format"`
+	Content string     `json:"content,omitempty"`
+	Pages   []BookPage `json:"pages,omitempty"`
+}
+
+// BookPage 分页内容（PDF 等按页组织的格式）
+type BookPage struct {
+	Number int    `json:"number"`
+	Text   string `json:"text"`
+}
+
+// Text 返回图书的完整文本，分页内容以空行连接
+func (c *BookContent) Text() string {
+	if len(c.Pages) == 0 {
+		return c.Content
+	}
+	texts := make([]string, 0, len(c.Pages))
+	for _, page := range c.Pages {
+		texts = append(texts, page.Text)
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// BookChapter 章节，Offset 为章节在全文中的字符偏移，Length 为章节字符数
+type BookChapter struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+	Text   string `json:"text,omitempty"`
+}
+
+// 分段内容的位置单位
+const (
+	ChunkUnitByte = "byte" // TXT 源文件中的字节位置
+	ChunkUnitChar = "char" // 提取文本中的字符位置
+)
+
+// ContentChunk 分段内容。客户端应将 NextOffset 原样作为下一次请求的 offset
+type ContentChunk struct {
+	BookID     uint   `json:"book_id"`
+	Unit       string `json:"unit"`
+	Offset     int64  `json:"offset"`
+	NextOffset int64  `json:"next_offset"`
+	Total      int64  `json:"total"`
+	Chapter    int    `json:"chapter,omitempty"`
+	Page       int    `json:"page,omitempty"`
+	TotalPages int    `json:"total_pages,omitempty"`
+	Text       string `json:"text"`
+	EOF        bool   `json:"eof"`
+}
@@ -1,24 +1,86 @@
 package models
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // Define model-related errors
 var (
 	// Book errors
+	ErrBookNotFound     = errors.New("book not found")
 	ErrTitleRequired    = errors.New("book title is required")
+	ErrTitleTooLong     = errors.New("book title is too long")
+	ErrAuthorTooLong    = errors.New("book author is too long")
+	ErrLanguageTooLong  = errors.New("book language is too long")
+	ErrPublisherTooLong = errors.New("book publisher is too long")
+	ErrISBNTooLong      = errors.New("book ISBN is too long")
 	ErrFormatRequired   = errors.New("book format is required")
 	ErrFilePathRequired = errors.New("book file path is required")
 	ErrInvalidFormat    = errors.New("unsupported book format")
 	ErrFileNotFound     = errors.New("book file not found")
 	ErrFileTooLarge     = errors.New("book file exceeds size limit")
+	ErrBookNotInTrash   = errors.New("book is not in the trash")
+
+	// Content errors
+	ErrChapterNotFound = errors.New("chapter not found")
+	ErrInvalidRange    = errors.New("requested content range is not satisfiable")
+
+	// Query errors
+	ErrInvalidSortField = errors.New("unsupported sort field")
+	ErrInvalidSortOrder = errors.New("sort order must be asc or desc")
+	ErrInvalidSizeRange = errors.New("invalid file size range")
+	ErrInvalidDateRange = errors.New("invalid date range")
+
+	// Search errors
+	ErrEmptyQuery     = errors.New("search query is empty")
+	ErrInvalidPattern = errors.New("invalid search pattern")
+
+	// Upload errors
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadExpired        = errors.New("upload has expired")
+	ErrUploadLocked         = errors.New("upload is being written by another request")
+	ErrUploadOffsetMismatch = errors.New("upload offset does not match")
+	ErrFilenameRequired     = errors.New("upload filename is required")
+
+	// Import errors
+	ErrInvalidArchive  = errors.New("file is not a ZIP or tar.gz archive")
+	ErrArchiveTooLarge = errors.New("import archive exceeds size limit")
+	ErrCorruptEntry    = errors.New("archive entry is corrupt")
+
+	// Storage reconciliation errors
+	ErrReconcileRunning = errors.New("storage reconciliation is already running")
+
+	// Cover errors
+	ErrCoverNotFound    = errors.New("book has no cover image")
+	ErrInvalidCoverSize = errors.New("unsupported cover size")
 )
 
 // IsValidBookFormat checks if the book format is valid
 func IsValidBookFormat(format BookFormat) bool {
 	switch format {
-	case FormatPDF, FormatEPUB, FormatTXT, FormatMOBI:
+	case FormatPDF, FormatEPUB, FormatTXT, FormatMOBI, FormatAZW3, FormatCBZ:
 		return true
 	default:
 		return false
 	}
 }
+
+// FormatMismatchError 上传文件的内容与扩展名声明的格式不符
+type FormatMismatchError struct {
+	Declared BookFormat // 扩展名声明的格式
+	Detected string     // 按内容检测到的 MIME 类型
+}
+
+func (e *FormatMismatchError) Error() string {
+	return fmt.Sprintf("file content does not match format %s (detected %s)", e.Declared, e.Detected)
+}
+
+// DuplicateBookError 上传文件的内容与已有图书完全相同
+type DuplicateBookError struct {
+	BookID uint // 已有图书的 ID
+}
+
+func (e *DuplicateBookError) Error() string {
+	return fmt.Sprintf("identical file already uploaded as book %d", e.BookID)
+}
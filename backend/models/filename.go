@@ -0,0 +1,45 @@
+package models
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxFilenameLength 原始文件名的最大字节数
+const MaxFilenameLength = 255
+
+// SanitizeFilename 清理客户端提供的文件名：只保留最后一级名称（兼容 / 和 \ 分隔），
+// 去掉控制字符、格式字符（如可伪装扩展名的 U+202E 双向覆盖）与无效的 UTF-8，
+// 并在保留扩展名的前提下截断到 MaxFilenameLength 字节。
+// 清理后为空或为 "."、".." 时返回空字符串
+func SanitizeFilename(name string) string {
+	name = strings.ToValidUTF8(name, "")
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." {
+		return ""
+	}
+
+	if len(name) > MaxFilenameLength {
+		ext := ""
+		if i := strings.LastIndexByte(name, '.'); i > 0 && len(name)-i <= 16 {
+			ext = name[i:]
+		}
+		stem := name[:MaxFilenameLength-len(ext)]
+		// 不截断多字节字符
+		for !utf8.ValidString(stem) {
+			stem = stem[:len(stem)-1]
+		}
+		name = stem + ext
+	}
+	return name
+}
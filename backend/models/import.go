@@ -0,0 +1,40 @@
+package models
+
+// ImportStatus 批量导入中单个文件的处理结果
+type ImportStatus string
+
+const (
+	ImportCreated   ImportStatus = "created"   // 已创建图书
+	ImportDuplicate ImportStatus = "duplicate" // 与已有图书内容相同，已跳过
+	ImportRejected  ImportStatus = "rejected"  // 未通过检查
+)
+
+// ImportResult 批量导入中单个文件的结果
+type ImportResult struct {
+	Filename string       `json:"filename"` // 文件在压缩包中的路径
+	Status   ImportStatus `json:"status"`
+	BookID   uint         `json:"book_id,omitempty"` // 创建的图书，重复时为已有的图书
+	Error    string       `json:"error,omitempty"`   // 跳过或拒绝的原因
+}
+
+// ImportReport 批量导入的结果
+type ImportReport struct {
+	Created    int            `json:"created"`
+	Duplicates int            `json:"duplicates"`
+	Rejected   int            `json:"rejected"`
+	Results    []ImportResult `json:"results"`
+	Error      string         `json:"error,omitempty"` // 压缩包损坏导致导入中途停止时的原因
+}
+
+// Add 记录单个文件的结果并更新计数
+func (r *ImportReport) Add(result ImportResult) {
+	switch result.Status {
+	case ImportCreated:
+		r.Created++
+	case ImportDuplicate:
+		r.Duplicates++
+	case ImportRejected:
+		r.Rejected++
+	}
+	r.Results = append(r.Results, result)
+}
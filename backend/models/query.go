@@ -0,0 +1,97 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// 分页默认值
+const (
+	DefaultPageSize = 10
+	MaxPageSize     = 100
+)
+
+// ClampPage 补全分页参数：页码至少为 1，每页条数默认 DefaultPageSize，最多 MaxPageSize
+func ClampPage(page, pageSize int) (int, int) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+	return page, pageSize
+}
+
+// 可排序字段，映射到数据库列名
+var sortColumns = map[string]string{
+	"title":      "title",
+	"author":     "author",
+	"created_at": "created_at",
+	"file_size":  "file_size",
+}
+
+// BookQuery 图书列表查询条件，零值表示不限制
+type BookQuery struct {
+	Page     int
+	PageSize int
+
+	Keyword       string       // 在书名和作者中搜索
+	Formats       []BookFormat // 格式，多个时取并集
+	Author        string       // 作者，精确匹配
+	MinSize       int64        // 最小文件大小（字节）
+	MaxSize       int64        // 最大文件大小（字节）
+	CreatedFrom   *time.Time   // 上传时间下限（含）
+	CreatedBefore *time.Time   // 上传时间上限（不含）
+
+	SortBy string // title、author、created_at 或 file_size
+	Order  string // asc 或 desc
+}
+
+// Validate 校验并补全查询条件
+func (q *BookQuery) Validate() error {
+	q.Page, q.PageSize = ClampPage(q.Page, q.PageSize)
+
+	q.Keyword = strings.TrimSpace(q.Keyword)
+	q.Author = strings.TrimSpace(q.Author)
+	for _, format := range q.Formats {
+		if !IsValidBookFormat(format) {
+			return ErrInvalidFormat
+		}
+	}
+
+	if q.MinSize < 0 || q.MaxSize < 0 || (q.MaxSize > 0 && q.MinSize > q.MaxSize) {
+		return ErrInvalidSizeRange
+	}
+	if q.CreatedFrom != nil && q.CreatedBefore != nil && !q.CreatedFrom.Before(*q.CreatedBefore) {
+		return ErrInvalidDateRange
+	}
+
+	if q.SortBy == "" {
+		q.SortBy = "created_at"
+	}
+	if _, ok := sortColumns[q.SortBy]; !ok {
+		return ErrInvalidSortField
+	}
+	q.Order = strings.ToLower(q.Order)
+	switch q.Order {
+	case "":
+		q.Order = "desc"
+	case "asc", "desc":
+	default:
+		return ErrInvalidSortOrder
+	}
+	return nil
+}
+
+// SortColumn 返回排序字段对应的列名，仅在 Validate 成功后调用
+func (q *BookQuery) SortColumn() string {
+	return sortColumns[q.SortBy]
+}
+
+// Offset 返回分页偏移量
+func (q *BookQuery) Offset() int {
+	return (q.Page - 1) * q.PageSize
+}
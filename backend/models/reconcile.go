@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// FileIssue 存储核对发现的问题
+type FileIssue string
+
+const (
+	FileOrphan       FileIssue = "orphan"        // 存储中没有图书引用的文件
+	FileMissing      FileIssue = "missing"       // 图书的文件不在存储中
+	FileSizeMismatch FileIssue = "size_mismatch" // 文件大小与图书记录不符
+	FileHashMismatch FileIssue = "hash_mismatch" // 文件内容的 SHA-256 与图书记录不符
+)
+
+// ReconcileAction 修复时对问题采取的处理
+type ReconcileAction string
+
+const (
+	ActionQuarantined ReconcileAction = "quarantined" // 孤立文件已移入隔离区
+	ActionDeleted     ReconcileAction = "deleted"     // 未完成写入留下的暂存文件已删除
+	ActionFlagged     ReconcileAction = "flagged"     // 已在图书上标记问题
+)
+
+// ReconcileIssue 存储核对发现的单个问题
+type ReconcileIssue struct {
+	Issue    FileIssue       `json:"issue"`
+	Key      string          `json:"key"`                // 文件在存储中的路径
+	BookID   uint            `json:"book_id,omitempty"`  // 引用该文件的图书，孤立文件为空
+	Expected string          `json:"expected,omitempty"` // 图书记录的大小或哈希
+	Actual   string          `json:"actual,omitempty"`   // 存储中文件的实际大小或哈希
+	Action   ReconcileAction `json:"action,omitempty"`   // 修复时采取的处理，仅报告时为空
+	Error    string          `json:"error,omitempty"`    // 修复失败的原因
+}
+
+// ReconcileOptions 存储核对的选项
+type ReconcileOptions struct {
+	Repair bool // 修复发现的问题，否则只报告
+	Hash   bool // 校验文件的 SHA-256，否则只比较大小
+}
+
+// ReconcileReport 存储核对的结果
+type ReconcileReport struct {
+	Repair     bool             `json:"repair"` // 是否修复了发现的问题
+	Hash       bool             `json:"hash"`   // 是否校验了文件的 SHA-256
+	Files      int              `json:"files"`  // 核对的存储文件数
+	Books      int              `json:"books"`  // 核对的图书数，包括回收站中的图书
+	Orphans    int              `json:"orphans"`
+	Missing    int              `json:"missing"`
+	Mismatches int              `json:"mismatches"`
+	Cleared    int              `json:"cleared"` // 问题已消失、取消标记的图书数
+	Issues     []ReconcileIssue `json:"issues"`
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+}
+
+// Add 记录单个问题并更新计数
+func (r *ReconcileReport) Add(issue ReconcileIssue) {
+	switch issue.Issue {
+	case FileOrphan:
+		r.Orphans++
+	case FileMissing:
+		r.Missing++
+	case FileSizeMismatch, FileHashMismatch:
+		r.Mismatches++
+	}
+	r.Issues = append(r.Issues, issue)
+}
@@ -0,0 +1,37 @@
+package models
+
+// SearchMatch 文本中的一处命中。Offset 为在全文中的字符位置，
+// Snippet 为带 <mark> 高亮、已做 HTML 转义的上下文
+type SearchMatch struct {
+	Chapter      int    `json:"chapter"`
+	ChapterTitle string `json:"chapter_title,omitempty"`
+	Offset       int    `json:"offset"`
+	Length       int    `json:"length"`
+	Snippet      string `json:"snippet"`
+}
+
+// BookSearchHit 全文搜索命中的图书
+type BookSearchHit struct {
+	Book    Book          `json:"book"`
+	Score   float64       `json:"score"`
+	Matches []SearchMatch `json:"matches"`
+}
+
+// SearchResult 全文搜索结果，按相关度排序
+type SearchResult struct {
+	Query string          `json:"query"`
+	Total int             `json:"total"`
+	Page  int             `json:"page"`
+	Size  int             `json:"size"`
+	Books []BookSearchHit `json:"books"`
+}
+
+// InBookSearchResult 单本图书内的搜索结果，命中按位置排序。
+// 命中过多时只返回前若干条，Truncated 为 true
+type InBookSearchResult struct {
+	BookID    uint          `json:"book_id"`
+	Query     string        `json:"query"`
+	Count     int           `json:"count"`
+	Truncated bool          `json:"truncated"`
+	Matches   []SearchMatch `json:"matches"`
+}
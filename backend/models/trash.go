@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// TrashedBook 回收站中的图书
+type TrashedBook struct {
+	Book
+	DeletedAt time.Time  `json:"deleted_at"`
+	PurgeAt   *time.Time `json:"purge_at,omitempty"` // 到期后自动彻底删除，为空表示不自动删除
+}
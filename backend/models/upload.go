@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Upload 断点续传（tus 协议）中的上传
+type Upload struct {
+	ID        string            `json:"id"`
+	Length    int64             `json:"length"`             // 文件总大小
+	Offset    int64             `json:"offset"`             // 已接收的字节数
+	Metadata  map[string]string `json:"metadata,omitempty"` // 客户端提供的 filename、title、author 等
+	ExpiresAt time.Time         `json:"expires_at"`         // 过期后未完成的上传会被清除
+	BookID    uint              `json:"book_id,omitempty"`  // 上传完成后创建的图书
+}
+
+// Complete 判断文件是否已全部接收
+func (u *Upload) Complete() bool {
+	return u.Offset >= u.Length
+}
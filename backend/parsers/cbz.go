@@ -0,0 +1,126 @@
+package parsers
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Errors returned by the CBZ parser
+var (
+	ErrInvalidCBZ = errors.New("invalid cbz file")
+)
+
+// CBZ is a comic book archive: a zip file of page images
+type CBZ struct {
+	pages []*zip.File
+}
+
+// cbzImageTypes maps page file extensions to media types
+var cbzImageTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".bmp":  "image/bmp",
+}
+
+// ParseCBZ reads the page list of a comic book archive from r. Pages are
+// the image files of the archive in natural name order, so that "page2"
+// comes before "page10".
+func ParseCBZ(r io.ReaderAt, size int64) (*CBZ, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCBZ, err)
+	}
+
+	c := &CBZ{}
+	for _, f := range zr.File {
+		base := path.Base(f.Name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(base, ".") || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		if _, ok := cbzImageTypes[strings.ToLower(path.Ext(base))]; ok {
+			c.pages = append(c.pages, f)
+		}
+	}
+	if len(c.pages) == 0 {
+		return nil, fmt.Errorf("%w: no page images", ErrInvalidCBZ)
+	}
+
+	sort.SliceStable(c.pages, func(i, j int) bool {
+		return naturalLess(c.pages[i].Name, c.pages[j].Name)
+	})
+	return c, nil
+}
+
+// PageCount returns the number of pages
+func (c *CBZ) PageCount() int {
+	return len(c.pages)
+}
+
+// Page returns the image of page i, numbered from 0, and its media type
+func (c *CBZ) Page(i int) ([]byte, string, error) {
+	if i < 0 || i >= len(c.pages) {
+		return nil, "", fmt.Errorf("%w: no page %d", ErrInvalidCBZ, i)
+	}
+	f := c.pages[i]
+	data, err := readZipEntry(f)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidCBZ, err)
+	}
+	return data, cbzImageTypes[strings.ToLower(path.Ext(f.Name))], nil
+}
+
+// Cover returns the first page, which serves as the cover
+func (c *CBZ) Cover() ([]byte, bool) {
+	data, _, err := c.Page(0)
+	if err != nil || len(data) == 0 {
+		return nil, false
+	}
+	return data, true
+}
+
+// naturalLess compares names case-insensitively, treating runs of digits
+// as numbers
+func naturalLess(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			na, nb := digitRun(a), digitRun(b)
+			// Compare by value: strip leading zeros, then by length and digits
+			va, vb := strings.TrimLeft(a[:na], "0"), strings.TrimLeft(b[:nb], "0")
+			if len(va) != len(vb) {
+				return len(va) < len(vb)
+			}
+			if va != vb {
+				return va < vb
+			}
+			a, b = a[na:], b[nb:]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// digitRun returns the length of the run of digits at the start of s
+func digitRun(s string) int {
+	n := 0
+	for n < len(s) && isDigit(s[n]) {
+		n++
+	}
+	return n
+}
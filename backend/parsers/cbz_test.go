@@ -0,0 +1,78 @@
+package parsers
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// buildZip creates an in-memory zip archive with entries in the given order
+func buildZip(t *testing.T, names []string, contents []string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i, name := range names {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create entry %s: %v", name, err)
+		}
+		w.Write([]byte(contents[i]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to close zip writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseCBZ(t *testing.T) {
+	data := buildZip(t,
+		[]string{"comic/page10.jpg", "comic/page2.png", "__MACOSX/comic/._page1.jpg", "comic/ComicInfo.xml", "comic/Page1.jpg"},
+		[]string{"ten", "two", "junk", "<ComicInfo/>", "one"},
+	)
+
+	cbz, err := ParseCBZ(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cbz.PageCount() != 3 {
+		t.Fatalf("expected 3 pages but got %d", cbz.PageCount())
+	}
+
+	for i, expected := range []string{"one", "two", "ten"} {
+		page, _, err := cbz.Page(i)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(page) != expected {
+			t.Errorf("page %d: expected %q but got %q", i, expected, page)
+		}
+	}
+
+	if cover, ok := cbz.Cover(); !ok || string(cover) != "one" {
+		t.Errorf("expected the first page as cover but got %q", cover)
+	}
+}
+
+func TestParseCBZInvalid(t *testing.T) {
+	data := buildZip(t, []string{"readme.txt"}, []string{"no images"})
+	if _, err := ParseCBZ(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrInvalidCBZ) {
+		t.Errorf("expected ErrInvalidCBZ but got %v", err)
+	}
+}
+
+func TestCBZPageTooLarge(t *testing.T) {
+	// Deflates to a small archive
+	data := buildZip(t, []string{"001.png"}, []string{strings.Repeat("\x00", maxZipEntrySize+1)})
+	cbz, err := ParseCBZ(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, err := cbz.Page(0); !errors.Is(err, ErrInvalidCBZ) {
+		t.Errorf("expected ErrInvalidCBZ but got %v", err)
+	}
+	if _, ok := cbz.Cover(); ok {
+		t.Error("expected no cover")
+	}
+}
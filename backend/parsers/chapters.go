@@ -0,0 +1,114 @@
+package parsers
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// TextChapter is a chapter of plain text. Offset is the character (rune)
+// offset of the chapter in the text it was split from.
+type TextChapter struct {
+	Title  string
+	Offset int
+	Text   string
+}
+
+// maxHeadingLength is the longest line, in characters, taken as a heading
+const maxHeadingLength = 40
+
+var (
+	// chapterHeadings are unambiguous headings such as "第十二章 风起",
+	// "卷三", "Chapter 12" or "楔子"
+	chapterHeadings = []*regexp.Regexp{
+		regexp.MustCompile(`^第[0-9０-９零〇一二两三四五六七八九十百千万]+[章回节卷集部篇](?:[\s　]|$|[:：、.．])`),
+		regexp.MustCompile(`^卷[0-9０-９零〇一二两三四五六七八九十百千]+(?:[\s　]|$|[:：、.．])`),
+		regexp.MustCompile(`^[上中下]卷(?:[\s　]|$)`),
+		regexp.MustCompile(`^(?:序章|序言|楔子|引子|前言|尾声|后记|後記|番外)(?:[\s　]|$|[:：、.．])`),
+		regexp.MustCompile(`(?i)^(?:chapter|chap\.)\s+(?:[0-9]+|[ivxlcdm]+|one|two|three|four|five|six|seven|eight|nine|ten|eleven|twelve|[a-z]+teen|twenty|thirty)\b`),
+		regexp.MustCompile(`(?i)^(?:book|part|volume)\s+(?:[0-9]+|[ivxlcdm]+|one|two|three|four|five|six|seven|eight|nine|ten)\b`),
+		regexp.MustCompile(`(?i)^(?:prologue|epilogue|preface|introduction|afterword)$`),
+	}
+
+	// numberedHeadings such as "12. The Return" or "三、归来" are only used
+	// when a text has no unambiguous headings, since they also match list items
+	numberedHeadings = []*regexp.Regexp{
+		regexp.MustCompile(`^[0-9]{1,3}[.、．]\s*\S`),
+		regexp.MustCompile(`^[零〇一二三四五六七八九十百]+[、．.]\s*\S`),
+	}
+)
+
+// headingLine is a line recognised as a chapter heading
+type headingLine struct {
+	byteOffset int
+	runeOffset int
+	title      string
+}
+
+// SplitChapters splits plain text into chapters at recognised headings.
+// Text before the first heading becomes an untitled chapter. A text
+// without headings is returned as a single untitled chapter.
+func SplitChapters(text string) []TextChapter {
+	var strong, weak []headingLine
+
+	byteOffset, runeOffset := 0, 0
+	for byteOffset < len(text) {
+		end := strings.IndexByte(text[byteOffset:], '\n')
+		if end < 0 {
+			end = len(text)
+		} else {
+			end += byteOffset + 1
+		}
+		line := text[byteOffset:end]
+
+		title := strings.TrimSpace(line)
+		if title != "" && utf8.RuneCountInString(title) <= maxHeadingLength {
+			heading := headingLine{byteOffset: byteOffset, runeOffset: runeOffset, title: title}
+			if matchAny(chapterHeadings, title) {
+				strong = append(strong, heading)
+			} else if matchAny(numberedHeadings, title) {
+				weak = append(weak, heading)
+			}
+		}
+
+		runeOffset += utf8.RuneCountInString(line)
+		byteOffset = end
+	}
+
+	headings := strong
+	if len(headings) == 0 && len(weak) >= 2 {
+		headings = weak
+	}
+	if len(headings) == 0 {
+		if strings.TrimSpace(text) == "" {
+			return nil
+		}
+		return []TextChapter{{Text: text}}
+	}
+
+	chapters := make([]TextChapter, 0, len(headings)+1)
+	if prelude := text[:headings[0].byteOffset]; strings.TrimSpace(prelude) != "" {
+		chapters = append(chapters, TextChapter{Text: prelude})
+	}
+	for i, heading := range headings {
+		end := len(text)
+		if i+1 < len(headings) {
+			end = headings[i+1].byteOffset
+		}
+		chapters = append(chapters, TextChapter{
+			Title:  heading.title,
+			Offset: heading.runeOffset,
+			Text:   text[heading.byteOffset:end],
+		})
+	}
+	return chapters
+}
+
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
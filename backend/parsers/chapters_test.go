@@ -0,0 +1,75 @@
+package parsers
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitChapters(t *testing.T) {
+	testCases := []struct {
+		name   string
+		text   string
+		titles []string
+	}{
+		{
+			name:   "Chinese Chapters",
+			text:   "内容简介：一个故事。\n\n第一章 开端\n天地玄黄。\n　　第二章　风起\n宇宙洪荒。\n书中提到第三章的内容。\n第十二回\n完。",
+			titles: []string{"", "第一章 开端", "第二章　风起", "第十二回"},
+		},
+		{
+			name:   "Volumes and Prologue",
+			text:   "楔子\n很久以前。\n卷一 山雨\n第1章 来客\n正文。\n尾声\n结束。",
+			titles: []string{"楔子", "卷一 山雨", "第1章 来客", "尾声"},
+		},
+		{
+			name:   "English Chapters",
+			text:   "Prologue\nOnce.\nChapter 1\nIt was a dark night.\nCHAPTER II: The Storm\nRain.\nChapter Three\nEnd.",
+			titles: []string{"Prologue", "Chapter 1", "CHAPTER II: The Storm", "Chapter Three"},
+		},
+		{
+			name:   "Numbered Headings",
+			text:   "1. Beginnings\nText.\n2. Middles\nMore text.\n3. Endings\nDone.",
+			titles: []string{"1. Beginnings", "2. Middles", "3. Endings"},
+		},
+		{
+			name:   "Numbered Items Ignored Next To Chapters",
+			text:   "第一章 清单\n1. 苹果\n2. 香蕉\n第二章 结束\n好。",
+			titles: []string{"第一章 清单", "第二章 结束"},
+		},
+		{
+			name:   "No Headings",
+			text:   "Just one long paragraph of text.\nAnd another line.",
+			titles: []string{""},
+		},
+		{
+			name:   "Long Line Is Not A Heading",
+			text:   "第一章 " + strings.Repeat("很长的句子", 20) + "\n正文。",
+			titles: []string{""},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			chapters := SplitChapters(tc.text)
+			if len(chapters) != len(tc.titles) {
+				t.Fatalf("expected %d chapters but got %d: %+v", len(tc.titles), len(chapters), chapters)
+			}
+
+			var joined strings.Builder
+			for i, chapter := range chapters {
+				if chapter.Title != tc.titles[i] {
+					t.Errorf("chapter %d: expected title %q but got %q", i, tc.titles[i], chapter.Title)
+				}
+				// Offsets are character offsets into the original text
+				if chapter.Offset != utf8.RuneCountInString(joined.String()) {
+					t.Errorf("chapter %d: expected offset %d but got %d", i, utf8.RuneCountInString(joined.String()), chapter.Offset)
+				}
+				joined.WriteString(chapter.Text)
+			}
+			if joined.String() != tc.text {
+				t.Errorf("chapters do not cover the whole text")
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package parsers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// Encoding names as stored on books
+const (
+	EncodingUTF8        = "utf-8"
+	EncodingUTF16LE     = "utf-16le"
+	EncodingUTF16BE     = "utf-16be"
+	EncodingGB18030     = "gb18030"
+	EncodingBig5        = "big5"
+	EncodingWindows1252 = "windows-1252"
+)
+
+// EncodingSampleSize is the number of leading bytes examined by DetectEncoding
+const EncodingSampleSize = 64 * 1024
+
+// commonHanzi holds frequent characters of simplified and traditional
+// Chinese. Text decoded with the wrong Chinese encoding still decodes, but
+// to rare characters, so the share of common ones tells the two apart.
+const commonHanzi = "的一是不了人我在有他这为之大来以个中上们到说国和地也子时道出而要于就下得可你年生自会那后能对着事其里所去行过家十用发天如然作方成者多日都三小军二无同么经法当起与好看学进种将还分此心前面又定见只主没公从已" +
+	"知全两把开回门想现实问本长机正意外老因些情明月动手力相关点起身新战让道理间向使物头被走体高最给儿再已地样感部更神文白城" +
+	"們這為來個說國時會對過後裡還與當發經將從開問現實應無長機邊麼點麵樣種進動頭見間話幾讓戰體學氣關聽東車門義處書認員們總軍" +
+	"爭於並勢歡歲號難題陽"
+
+var commonHanziSet = func() map[rune]bool {
+	set := make(map[rune]bool)
+	for _, r := range commonHanzi {
+		set[r] = true
+	}
+	return set
+}()
+
+// DetectEncoding guesses the character encoding of text data from its byte
+// order mark or, failing that, from the content of its leading bytes
+func DetectEncoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return EncodingUTF8
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return EncodingUTF16BE
+	}
+
+	sample := data
+	if len(sample) > EncodingSampleSize {
+		sample = sample[:EncodingSampleSize]
+	}
+	// Don't count a character cut off by the end of the sample as invalid
+	for i := 0; i < utf8.UTFMax-1 && len(sample) > 0; i++ {
+		if r, _ := utf8.DecodeLastRune(sample); r != utf8.RuneError {
+			break
+		}
+		sample = sample[:len(sample)-1]
+	}
+
+	if enc := detectUTF16(sample); enc != "" {
+		return enc
+	}
+	if utf8.Valid(sample) {
+		return EncodingUTF8
+	}
+
+	best, bestScore := "", -1
+	for _, name := range []string{EncodingGB18030, EncodingBig5} {
+		score, ok := scoreChinese(sample, name)
+		if ok && score > bestScore {
+			best, bestScore = name, score
+		}
+	}
+	if best != "" {
+		return best
+	}
+	return EncodingWindows1252
+}
+
+// detectUTF16 recognises BOM-less UTF-16 by the zero bytes of ASCII characters
+func detectUTF16(sample []byte) string {
+	if len(sample) < 4 {
+		return ""
+	}
+	var even, odd int
+	for i := 0; i+1 < len(sample); i += 2 {
+		if sample[i] == 0 {
+			even++
+		}
+		if sample[i+1] == 0 {
+			odd++
+		}
+	}
+	pairs := len(sample) / 2
+	switch {
+	case odd > pairs/3 && even < pairs/20:
+		return EncodingUTF16LE
+	case even > pairs/3 && odd < pairs/20:
+		return EncodingUTF16BE
+	}
+	return ""
+}
+
+// scoreChinese decodes the sample with a Chinese encoding and counts the
+// common characters in the result. It reports false if the sample is not
+// valid in that encoding.
+func scoreChinese(sample []byte, name string) (int, bool) {
+	enc := lookupEncoding(name)
+	if enc == nil {
+		return 0, false
+	}
+	decoded, err := enc.NewDecoder().Bytes(sample)
+	if err != nil {
+		return 0, false
+	}
+
+	score, invalid, total := 0, 0, 0
+	for _, r := range string(decoded) {
+		if r < 0x80 {
+			continue
+		}
+		total++
+		switch {
+		case r == utf8.RuneError:
+			invalid++
+		case commonHanziSet[r]:
+			score++
+		}
+	}
+	// A trailing partial character may be cut by the sample boundary
+	if invalid > 1 && invalid*100 > total {
+		return 0, false
+	}
+	return score, true
+}
+
+// lookupEncoding returns the decoder for a stored encoding name
+func lookupEncoding(name string) encoding.Encoding {
+	switch strings.ToLower(name) {
+	case EncodingUTF8, "utf8":
+		return unicode.UTF8BOM
+	case EncodingUTF16LE:
+		return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM)
+	case EncodingUTF16BE:
+		return unicode.UTF16(unicode.BigEndian, unicode.UseBOM)
+	case EncodingGB18030, "gbk", "gb2312":
+		return simplifiedchinese.GB18030
+	case EncodingBig5, "big5-hkscs":
+		return traditionalchinese.Big5
+	case EncodingWindows1252, "latin1", "iso-8859-1":
+		return charmap.Windows1252
+	}
+	return nil
+}
+
+// DecodeText converts text in the named encoding to UTF-8, dropping any
+// byte order mark
+func DecodeText(data []byte, name string) (string, error) {
+	enc := lookupEncoding(name)
+	if enc == nil {
+		return "", fmt.Errorf("unsupported text encoding: %s", name)
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode %s text: %v", name, err)
+	}
+	return string(decoded), nil
+}
+
+// DecodeTextChunk decodes the complete characters at the start of data,
+// which may end in the middle of a character unless atEOF is set. It returns
+// the text and the number of bytes of data it consumed.
+func DecodeTextChunk(data []byte, name string, atEOF bool) (string, int, error) {
+	enc := lookupEncoding(name)
+	if enc == nil {
+		return "", 0, fmt.Errorf("unsupported text encoding: %s", name)
+	}
+
+	decoder := enc.NewDecoder()
+	dst := make([]byte, len(data)*2+utf8.UTFMax)
+	for {
+		nDst, nSrc, err := decoder.Transform(dst, data, atEOF)
+		switch {
+		case err == nil || errors.Is(err, transform.ErrShortSrc):
+			return string(dst[:nDst]), nSrc, nil
+		case errors.Is(err, transform.ErrShortDst):
+			dst = make([]byte, len(dst)*2)
+			decoder.Reset()
+		default:
+			return "", 0, fmt.Errorf("failed to decode %s text: %v", name, err)
+		}
+	}
+}
+
+// alignWindow is how far AlignTextOffset looks back for a character boundary
+const alignWindow = 4096
+
+// AlignTextOffset moves a byte offset into text in the named encoding forward
+// to the start of the next character
+func AlignTextOffset(r io.ReaderAt, offset int64, name string) (int64, error) {
+	if offset <= 0 {
+		return 0, nil
+	}
+
+	switch strings.ToLower(name) {
+	case EncodingUTF8, "utf8":
+		buf := make([]byte, utf8.UTFMax)
+		n, err := r.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := 0; i < n && !utf8.RuneStart(buf[i]); i++ {
+			offset++
+		}
+		return offset, nil
+	case EncodingUTF16LE, EncodingUTF16BE:
+		if offset%2 != 0 {
+			offset++
+		}
+		// Don't start on the second half of a surrogate pair
+		buf := make([]byte, 2)
+		if n, _ := r.ReadAt(buf, offset); n == 2 {
+			unit := uint16(buf[0]) | uint16(buf[1])<<8
+			if name == EncodingUTF16BE {
+				unit = uint16(buf[0])<<8 | uint16(buf[1])
+			}
+			if unit >= 0xDC00 && unit <= 0xDFFF {
+				offset += 2
+			}
+		}
+		return offset, nil
+	case EncodingGB18030, "gbk", "gb2312", EncodingBig5, "big5-hkscs":
+		return alignMultiByte(r, offset, name)
+	}
+	return offset, nil
+}
+
+// alignMultiByte aligns an offset into GB18030 or Big5 text. Bytes below
+// 0x30 never occur inside a multi-byte character, so decoding resumes from
+// the last such byte before the offset and steps over whole characters.
+func alignMultiByte(r io.ReaderAt, offset int64, name string) (int64, error) {
+	start := offset - alignWindow
+	if start < 0 {
+		start = 0
+	}
+	buf := make([]byte, offset-start+4)
+	n, err := r.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	buf = buf[:n]
+
+	pos := 0
+	if start > 0 {
+		anchor := -1
+		for i := int(offset-start) - 1; i >= 0; i-- {
+			if buf[i] < 0x30 {
+				anchor = i + 1
+				break
+			}
+		}
+		if anchor < 0 {
+			// No boundary in sight; fall back to the offset itself
+			return offset, nil
+		}
+		pos = anchor
+	}
+
+	gb := name != EncodingBig5 && name != "big5-hkscs"
+	for int64(pos)+start < offset && pos < len(buf) {
+		switch {
+		case buf[pos] < 0x80:
+			pos++
+		case gb && pos+1 < len(buf) && buf[pos+1] >= 0x30 && buf[pos+1] <= 0x39:
+			pos += 4
+		default:
+			pos += 2
+		}
+	}
+	return start + int64(pos), nil
+}
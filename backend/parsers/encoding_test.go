@@ -0,0 +1,162 @@
+package parsers
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const (
+	simplifiedText  = "第一回 宴桃园豪杰三结义\n话说天下大势，分久必合，合久必分。周末七国分争，并入于秦。及秦灭之后，楚、汉分争，又并入于汉。"
+	traditionalText = "第一回 宴桃園豪傑三結義\n話說天下大勢，分久必合，合久必分。周末七國分爭，並入於秦。及秦滅之後，楚、漢分爭，又並入於漢。"
+)
+
+func encode(t *testing.T, enc encoding.Encoding, s string) []byte {
+	t.Helper()
+	b, err := enc.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatalf("Failed to encode test text: %v", err)
+	}
+	return b
+}
+
+func TestDetectEncoding(t *testing.T) {
+	testCases := []struct {
+		name     string
+		data     []byte
+		expected string
+		text     string
+	}{
+		{name: "UTF-8", data: []byte(simplifiedText), expected: EncodingUTF8, text: simplifiedText},
+		{name: "UTF-8 BOM", data: append([]byte{0xEF, 0xBB, 0xBF}, simplifiedText...), expected: EncodingUTF8, text: simplifiedText},
+		{name: "ASCII", data: []byte("Chapter 1\nIt was a dark and stormy night."), expected: EncodingUTF8, text: "Chapter 1\nIt was a dark and stormy night."},
+		{
+			name:     "UTF-16LE BOM",
+			data:     encode(t, unicode.UTF16(unicode.LittleEndian, unicode.UseBOM), simplifiedText),
+			expected: EncodingUTF16LE,
+			text:     simplifiedText,
+		},
+		{
+			name:     "UTF-16BE Without BOM",
+			data:     encode(t, unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), "Chapter 1: The Beginning"),
+			expected: EncodingUTF16BE,
+			text:     "Chapter 1: The Beginning",
+		},
+		{name: "GBK", data: encode(t, simplifiedchinese.GBK, simplifiedText), expected: EncodingGB18030, text: simplifiedText},
+		{name: "Big5", data: encode(t, traditionalchinese.Big5, traditionalText), expected: EncodingBig5, text: traditionalText},
+		{
+			name:     "GB18030 Longer Than Sample",
+			data:     encode(t, simplifiedchinese.GB18030, strings.Repeat(simplifiedText, 1000)),
+			expected: EncodingGB18030,
+			text:     strings.Repeat(simplifiedText, 1000),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			enc := DetectEncoding(tc.data)
+			if enc != tc.expected {
+				t.Fatalf("expected encoding %s but got %s", tc.expected, enc)
+			}
+
+			text, err := DecodeText(tc.data, enc)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if text != tc.text {
+				t.Errorf("decoded text does not match the original: %q", text)
+			}
+		})
+	}
+}
+
+func TestDecodeTextUnknownEncoding(t *testing.T) {
+	if _, err := DecodeText([]byte("abc"), "ebcdic"); err == nil {
+		t.Error("expected error for unknown encoding but got none")
+	}
+}
+
+func TestDecodeTextInChunks(t *testing.T) {
+	// Reading from arbitrary byte offsets must reproduce the text exactly,
+	// without splitting or dropping characters at chunk boundaries
+	testCases := []struct {
+		name     string
+		enc      encoding.Encoding
+		encoding string
+		text     string
+	}{
+		{name: "UTF-8", enc: unicode.UTF8, encoding: EncodingUTF8, text: simplifiedText},
+		{name: "UTF-16LE", enc: unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), encoding: EncodingUTF16LE, text: simplifiedText + "𝄞"},
+		{name: "UTF-16BE", enc: unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), encoding: EncodingUTF16BE, text: simplifiedText},
+		{name: "GB18030", enc: simplifiedchinese.GB18030, encoding: EncodingGB18030, text: simplifiedText + "€𝄞"},
+		{name: "Big5", enc: traditionalchinese.Big5, encoding: EncodingBig5, text: traditionalText},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data := encode(t, tc.enc, tc.text)
+
+			for _, size := range []int{5, 7, 16} {
+				var out strings.Builder
+				for offset := int64(0); offset < int64(len(data)); {
+					start, err := AlignTextOffset(bytes.NewReader(data), offset, tc.encoding)
+					if err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					if start != offset {
+						t.Fatalf("offset %d returned by the previous chunk is not aligned", offset)
+					}
+					end := start + int64(size)
+					if end > int64(len(data)) {
+						end = int64(len(data))
+					}
+					text, n, err := DecodeTextChunk(data[start:end], tc.encoding, end == int64(len(data)))
+					if err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					out.WriteString(text)
+					offset = start + int64(n)
+				}
+				if out.String() != tc.text {
+					t.Errorf("chunk size %d: expected %q but got %q", size, tc.text, out.String())
+				}
+			}
+		})
+	}
+}
+
+func TestAlignTextOffset(t *testing.T) {
+	gbk := encode(t, simplifiedchinese.GBK, "ab中文cd")
+	utf := []byte("ab中文cd")
+
+	testCases := []struct {
+		name     string
+		data     []byte
+		encoding string
+		offset   int64
+		expected int64
+	}{
+		{name: "UTF-8 Boundary", data: utf, encoding: EncodingUTF8, offset: 2, expected: 2},
+		{name: "UTF-8 Inside Character", data: utf, encoding: EncodingUTF8, offset: 3, expected: 5},
+		{name: "GBK Boundary", data: gbk, encoding: EncodingGB18030, offset: 4, expected: 4},
+		{name: "GBK Inside Character", data: gbk, encoding: EncodingGB18030, offset: 5, expected: 6},
+		{name: "UTF-16 Odd Offset", data: []byte("a\x00b\x00"), encoding: EncodingUTF16LE, offset: 1, expected: 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			offset, err := AlignTextOffset(bytes.NewReader(tc.data), tc.offset, tc.encoding)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if offset != tc.expected {
+				t.Errorf("expected offset %d but got %d", tc.expected, offset)
+			}
+		})
+	}
+}
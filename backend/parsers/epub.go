@@ -0,0 +1,422 @@
+package parsers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Errors returned by the EPUB parser
+var (
+	ErrInvalidEPUB = errors.New("invalid epub file")
+)
+
+// maxZipEntrySize caps the uncompressed size of a zip entry that is read
+// into memory, as a small archive can inflate to gigabytes
+const maxZipEntrySize = 64 << 20
+
+var errZipEntryTooLarge = errors.New("zip entry too large")
+
+// EPUBSection is a readable document from the EPUB spine
+type EPUBSection struct {
+	Href  string
+	Title string
+	Text  string
+}
+
+// EPUBMetadata is the publication information from the package document
+type EPUBMetadata struct {
+	Title       string
+	Authors     []string
+	Language    string
+	Publisher   string
+	ISBN        string
+	Description string
+}
+
+// EPUB is a parsed EPUB publication
+type EPUB struct {
+	Metadata EPUBMetadata
+
+	zip      *zip.Reader
+	opfPath  string
+	manifest map[string]opfItem
+	spine    []opfItemRef
+	coverID  string
+}
+
+type containerXML struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type opfPackage struct {
+	Metadata opfMetadata  `xml:"metadata"`
+	Manifest []opfItem    `xml:"manifest>item"`
+	Spine    []opfItemRef `xml:"spine>itemref"`
+}
+
+// opfMetadata holds the Dublin Core elements of a package document. Element
+// names are matched without their dc: namespace.
+type opfMetadata struct {
+	Titles       []string        `xml:"title"`
+	Creators     []opfCreator    `xml:"creator"`
+	Languages    []string        `xml:"language"`
+	Publishers   []string        `xml:"publisher"`
+	Descriptions []string        `xml:"description"`
+	Identifiers  []opfIdentifier `xml:"identifier"`
+	Metas        []opfMeta       `xml:"meta"`
+}
+
+type opfCreator struct {
+	ID    string `xml:"id,attr"`
+	Role  string `xml:"role,attr"`
+	Value string `xml:",chardata"`
+}
+
+type opfIdentifier struct {
+	Scheme string `xml:"scheme,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// opfMeta is an EPUB 2 <meta name content> or EPUB 3 <meta property refines> element
+type opfMeta struct {
+	Name     string `xml:"name,attr"`
+	Content  string `xml:"content,attr"`
+	Property string `xml:"property,attr"`
+	Refines  string `xml:"refines,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type opfItem struct {
+	ID         string `xml:"id,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr"`
+}
+
+type opfItemRef struct {
+	IDRef  string `xml:"idref,attr"`
+	Linear string `xml:"linear,attr"`
+}
+
+// OpenEPUB parses the EPUB file at the given path. The whole file is read
+// into memory, so the returned EPUB does not hold the file open.
+func OpenEPUB(filePath string) (*EPUB, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return ParseEPUB(bytes.NewReader(data), int64(len(data)))
+}
+
+// ParseEPUB parses an EPUB publication from r
+func ParseEPUB(r io.ReaderAt, size int64) (*EPUB, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEPUB, err)
+	}
+
+	e := &EPUB{zip: zr, manifest: make(map[string]opfItem)}
+
+	var container containerXML
+	if err := e.decodeXML("META-INF/container.xml", &container); err != nil {
+		return nil, err
+	}
+	for _, rootfile := range container.Rootfiles {
+		if rootfile.MediaType == "" || rootfile.MediaType == "application/oebps-package+xml" {
+			e.opfPath = rootfile.FullPath
+			break
+		}
+	}
+	if e.opfPath == "" {
+		return nil, fmt.Errorf("%w: no package document in container.xml", ErrInvalidEPUB)
+	}
+
+	var pkg opfPackage
+	if err := e.decodeXML(e.opfPath, &pkg); err != nil {
+		return nil, err
+	}
+	for _, item := range pkg.Manifest {
+		e.manifest[item.ID] = item
+	}
+	e.spine = pkg.Spine
+	e.Metadata = pkg.Metadata.toEPUBMetadata()
+	for _, el := range pkg.Metadata.Metas {
+		if el.Name == "cover" {
+			e.coverID = el.Content
+		}
+	}
+
+	return e, nil
+}
+
+// toEPUBMetadata picks the book information out of the package metadata
+func (m *opfMetadata) toEPUBMetadata() EPUBMetadata {
+	var meta EPUBMetadata
+	meta.Title = firstNonEmpty(m.Titles)
+	meta.Language = firstNonEmpty(m.Languages)
+	meta.Publisher = firstNonEmpty(m.Publishers)
+
+	// EPUB 3 gives creator roles in refining meta elements
+	roles := make(map[string]string)
+	for _, el := range m.Metas {
+		if el.Property == "role" && strings.HasPrefix(el.Refines, "#") {
+			roles[el.Refines[1:]] = strings.TrimSpace(el.Value)
+		}
+	}
+	for _, creator := range m.Creators {
+		role := creator.Role
+		if role == "" {
+			role = roles[creator.ID]
+		}
+		name := strings.TrimSpace(creator.Value)
+		if name != "" && (role == "" || role == "aut") {
+			meta.Authors = append(meta.Authors, name)
+		}
+	}
+
+	for _, id := range m.Identifiers {
+		isbn := NormalizeISBN(id.Value)
+		if isbn != "" && (strings.EqualFold(id.Scheme, "isbn") || meta.ISBN == "") {
+			meta.ISBN = isbn
+		}
+	}
+
+	if description := firstNonEmpty(m.Descriptions); description != "" {
+		// Descriptions frequently carry escaped HTML
+		meta.Description = description
+		if doc, err := ExtractHTMLText([]byte(description)); err == nil && doc.Text != "" {
+			meta.Description = doc.Text
+		}
+	}
+	return meta
+}
+
+// firstNonEmpty returns the first value that is not blank, trimmed
+func firstNonEmpty(values []string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// NormalizeISBN returns the digits of an ISBN-10 or ISBN-13 given with or
+// without a "urn:isbn:" or "ISBN" prefix and separators, or "" if s is not
+// an ISBN
+func NormalizeISBN(s string) string {
+	s = strings.TrimSpace(s)
+	lower := strings.ToLower(s)
+	for _, prefix := range []string{"urn:isbn:", "isbn:", "isbn"} {
+		if strings.HasPrefix(lower, prefix) {
+			s = strings.TrimSpace(s[len(prefix):])
+			break
+		}
+	}
+
+	var digits []byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= '0' && c <= '9':
+			digits = append(digits, c)
+		case c == 'X' || c == 'x':
+			digits = append(digits, 'X')
+		case c == '-' || c == ' ':
+		default:
+			return ""
+		}
+	}
+
+	switch len(digits) {
+	case 10:
+		if bytes.IndexByte(digits[:9], 'X') >= 0 {
+			return ""
+		}
+	case 13:
+		if bytes.IndexByte(digits, 'X') >= 0 {
+			return ""
+		}
+		if !bytes.HasPrefix(digits, []byte("978")) && !bytes.HasPrefix(digits, []byte("979")) {
+			return ""
+		}
+	default:
+		return ""
+	}
+	return string(digits)
+}
+
+// Sections returns the readable text of every spine document in reading order.
+// Documents that contain no text (such as image-only cover pages) are skipped.
+func (e *EPUB) Sections() ([]EPUBSection, error) {
+	var sections []EPUBSection
+	for _, ref := range e.spine {
+		item, ok := e.manifest[ref.IDRef]
+		if !ok || !isXHTML(item.MediaType) {
+			continue
+		}
+
+		name := e.resolve(item.Href)
+		data, err := e.readFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		doc, err := ExtractHTMLText(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", name, err)
+		}
+		if doc.Text == "" {
+			continue
+		}
+
+		sections = append(sections, EPUBSection{
+			Href:  name,
+			Title: doc.Title,
+			Text:  doc.Text,
+		})
+	}
+	return sections, nil
+}
+
+// Cover returns the cover image. EPUB 3 marks it with the
+// cover-image manifest property and EPUB 2 with a <meta name="cover">
+// element; failing both, an image item whose id or file name contains
+// "cover" is used. It reports false if the book has no cover image.
+func (e *EPUB) Cover() ([]byte, bool) {
+	item, ok := e.coverItem()
+	if !ok {
+		return nil, false
+	}
+	data, err := e.readFile(e.resolve(item.Href))
+	if err != nil || len(data) == 0 {
+		return nil, false
+	}
+	return data, true
+}
+
+// coverItem finds the manifest item of the cover image
+func (e *EPUB) coverItem() (opfItem, bool) {
+	// Go through the manifest in a fixed order; map order is random
+	ids := make([]string, 0, len(e.manifest))
+	for id, item := range e.manifest {
+		if strings.HasPrefix(item.MediaType, "image/") {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		if hasProperty(e.manifest[id].Properties, "cover-image") {
+			return e.manifest[id], true
+		}
+	}
+	if item, ok := e.manifest[e.coverID]; ok && strings.HasPrefix(item.MediaType, "image/") {
+		return item, true
+	}
+	for _, id := range ids {
+		item := e.manifest[id]
+		if strings.Contains(strings.ToLower(id), "cover") || strings.Contains(strings.ToLower(path.Base(item.Href)), "cover") {
+			return item, true
+		}
+	}
+	return opfItem{}, false
+}
+
+// hasProperty reports whether a space-separated manifest properties list
+// contains name
+func hasProperty(properties, name string) bool {
+	for _, p := range strings.Fields(properties) {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the zip entry name of a manifest href, which is relative
+// to the package document
+func (e *EPUB) resolve(href string) string {
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	if i := strings.IndexByte(href, '#'); i >= 0 {
+		href = href[:i]
+	}
+	return path.Join(path.Dir(e.opfPath), href)
+}
+
+// readFile reads a zip entry by name
+func (e *EPUB) readFile(name string) ([]byte, error) {
+	for _, f := range e.zip.File {
+		if f.Name != name {
+			continue
+		}
+		data, err := readZipEntry(f)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidEPUB, name, err)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("%w: missing %s", ErrInvalidEPUB, name)
+}
+
+// readZipEntry reads a zip entry of at most maxZipEntrySize bytes. The
+// read is limited as well, in case the size in the header is wrong.
+func readZipEntry(f *zip.File) ([]byte, error) {
+	if f.UncompressedSize64 > maxZipEntrySize {
+		return nil, errZipEntryTooLarge
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxZipEntrySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxZipEntrySize {
+		return nil, errZipEntryTooLarge
+	}
+	return data, nil
+}
+
+// decodeXML reads a zip entry and decodes it as XML into v
+func (e *EPUB) decodeXML(name string, v interface{}) error {
+	data, err := e.readFile(name)
+	if err != nil {
+		return err
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		// Package documents are UTF-8 in practice; accept the declared label as is
+		return input, nil
+	}
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("%w: failed to parse %s: %v", ErrInvalidEPUB, name, err)
+	}
+	return nil
+}
+
+// isXHTML reports whether a manifest media type is a readable content document
+func isXHTML(mediaType string) bool {
+	switch mediaType {
+	case "application/xhtml+xml", "text/html", "application/x-dtbook+xml":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,236 @@
+package parsers
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildEPUB creates an in-memory EPUB archive from a map of entry names to contents
+func buildEPUB(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	// The mimetype entry must come first
+	w, err := zw.Create("mimetype")
+	if err != nil {
+		t.Fatalf("Failed to create mimetype entry: %v", err)
+	}
+	w.Write([]byte("application/epub+zip"))
+
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create entry %s: %v", name, err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to close zip writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+const testContainer = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`
+
+const testOPF = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>测试之书</dc:title>
+    <dc:creator id="author">佚名</dc:creator>
+    <meta refines="#author" property="role" scheme="marc:relators">aut</meta>
+    <dc:creator id="translator">译者</dc:creator>
+    <meta refines="#translator" property="role" scheme="marc:relators">trl</meta>
+    <dc:identifier id="uid">urn:uuid:0f7e2a4c-5b1d-4a3e-9c6f-2d8b7e1a9c30</dc:identifier>
+    <dc:identifier>urn:isbn:978-7-02-002475-9</dc:identifier>
+    <dc:language>zh-CN</dc:language>
+    <dc:publisher>人民文学出版社</dc:publisher>
+    <dc:description>&lt;p&gt;一本&lt;b&gt;测试&lt;/b&gt;用书。&lt;/p&gt;</dc:description>
+  </metadata>
+  <manifest>
+    <item id="cover" href="Text/cover.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch1" href="Text/chapter%201.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch2" href="Text/chapter2.xhtml" media-type="application/xhtml+xml"/>
+    <item id="css" href="style.css" media-type="text/css"/>
+  </manifest>
+  <spine>
+    <itemref idref="cover"/>
+    <itemref idref="ch2"/>
+    <itemref idref="ch1"/>
+  </spine>
+</package>`
+
+func TestParseEPUB(t *testing.T) {
+	data := buildEPUB(t, map[string]string{
+		"META-INF/container.xml": testContainer,
+		"OEBPS/content.opf":      testOPF,
+		"OEBPS/style.css":        "p { color: red; }",
+		"OEBPS/Text/cover.xhtml": `<html><body><img src="cover.jpg"/></body></html>`,
+		"OEBPS/Text/chapter 1.xhtml": `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>第一章</title>
+<style>body { margin: 0 }</style></head>
+<body><h1>第一章 开端</h1><p>天地玄黄，</p><p>宇宙洪荒。</p><script>alert(1)</script></body></html>`,
+		"OEBPS/Text/chapter2.xhtml": `<html><body><h2>Prologue</h2><p>It was a   dark
+and stormy night.</p></body></html>`,
+	})
+
+	epub, err := ParseEPUB(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sections, err := epub.Sections()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The image-only cover page is skipped and the spine order is kept
+	if len(sections) != 2 {
+		t.Fatalf("expected 2 sections but got %d", len(sections))
+	}
+	if sections[0].Title != "Prologue" {
+		t.Errorf("expected title Prologue but got %q", sections[0].Title)
+	}
+	if sections[0].Text != "Prologue\nIt was a dark and stormy night." {
+		t.Errorf("unexpected text %q", sections[0].Text)
+	}
+	if sections[1].Title != "第一章" {
+		t.Errorf("expected title 第一章 but got %q", sections[1].Title)
+	}
+	if sections[1].Text != "第一章 开端\n天地玄黄，\n宇宙洪荒。" {
+		t.Errorf("unexpected text %q", sections[1].Text)
+	}
+	for _, section := range sections {
+		if strings.Contains(section.Text, "alert") || strings.Contains(section.Text, "margin") {
+			t.Errorf("script or style leaked into text: %q", section.Text)
+		}
+	}
+}
+
+func TestEPUBMetadata(t *testing.T) {
+	data := buildEPUB(t, map[string]string{
+		"META-INF/container.xml": testContainer,
+		"OEBPS/content.opf":      testOPF,
+	})
+
+	epub, err := ParseEPUB(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	meta := epub.Metadata
+	if meta.Title != "测试之书" {
+		t.Errorf("unexpected title %q", meta.Title)
+	}
+	// The translator is not an author
+	if len(meta.Authors) != 1 || meta.Authors[0] != "佚名" {
+		t.Errorf("unexpected authors %q", meta.Authors)
+	}
+	if meta.ISBN != "9787020024759" || meta.Language != "zh-CN" || meta.Publisher != "人民文学出版社" {
+		t.Errorf("unexpected metadata %+v", meta)
+	}
+	if meta.Description != "一本测试用书。" {
+		t.Errorf("unexpected description %q", meta.Description)
+	}
+}
+
+func TestEPUBCover(t *testing.T) {
+	const opf = `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="%s">
+  <metadata>%s</metadata>
+  <manifest>
+    <item id="img1" href="Images/illustration.jpg" media-type="image/jpeg"/>
+    <item id="cover-img" href="Images/front.jpg" media-type="image/jpeg" %s/>
+  </manifest>
+  <spine/>
+</package>`
+
+	testCases := []struct {
+		name string
+		opf  string
+		ok   bool
+	}{
+		{name: "EPUB 3 Property", opf: fmt.Sprintf(opf, "3.0", "", `properties="cover-image"`), ok: true},
+		{name: "EPUB 2 Meta", opf: fmt.Sprintf(opf, "2.0", `<meta name="cover" content="cover-img"/>`, ""), ok: true},
+		{name: "Item Name", opf: fmt.Sprintf(opf, "2.0", "", ""), ok: true},
+		{name: "No Cover", opf: strings.Replace(fmt.Sprintf(opf, "2.0", "", ""), "cover-img", "img2", 1), ok: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data := buildEPUB(t, map[string]string{
+				"META-INF/container.xml":        testContainer,
+				"OEBPS/content.opf":             tc.opf,
+				"OEBPS/Images/front.jpg":        "front cover",
+				"OEBPS/Images/illustration.jpg": "illustration",
+			})
+			epub, err := ParseEPUB(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			cover, ok := epub.Cover()
+			if ok != tc.ok {
+				t.Fatalf("expected cover found to be %v but got %v", tc.ok, ok)
+			}
+			if ok && string(cover) != "front cover" {
+				t.Errorf("unexpected cover %q", cover)
+			}
+		})
+	}
+}
+
+func TestNormalizeISBN(t *testing.T) {
+	testCases := map[string]string{
+		"978-7-02-002475-9":      "9787020024759",
+		"urn:isbn:9787020024759": "9787020024759",
+		"ISBN 0-306-40615-2":     "0306406152",
+		"080442957x":             "080442957X",
+		"urn:uuid:1234":          "",
+		"1234567890123":          "",
+		"12345":                  "",
+	}
+
+	for input, expected := range testCases {
+		if isbn := NormalizeISBN(input); isbn != expected {
+			t.Errorf("NormalizeISBN(%q): expected %q but got %q", input, expected, isbn)
+		}
+	}
+}
+
+func TestParseEPUBInvalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		data []byte
+	}{
+		{name: "Not a Zip", data: []byte("not an epub")},
+		{name: "Missing Container", data: buildEPUB(t, map[string]string{"OEBPS/content.opf": testOPF})},
+		{name: "Missing Package", data: buildEPUB(t, map[string]string{"META-INF/container.xml": testContainer})},
+		{
+			// Deflates to a small archive
+			name: "Entry Too Large",
+			data: buildEPUB(t, map[string]string{
+				"META-INF/container.xml": testContainer,
+				"OEBPS/content.opf":      testOPF + strings.Repeat(" ", maxZipEntrySize),
+			}),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseEPUB(bytes.NewReader(tc.data), int64(len(tc.data)))
+			if !errors.Is(err, ErrInvalidEPUB) {
+				t.Errorf("expected ErrInvalidEPUB but got %v", err)
+			}
+		})
+	}
+}
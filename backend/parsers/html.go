@@ -0,0 +1,149 @@
+package parsers
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// blockElements start a new line when converting markup to plain text
+var blockElements = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true, atom.Blockquote: true,
+	atom.Br: true, atom.Dd: true, atom.Div: true, atom.Dl: true, atom.Dt: true,
+	atom.Figcaption: true, atom.Figure: true, atom.Footer: true, atom.H1: true,
+	atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Header: true, atom.Hr: true, atom.Li: true, atom.Ol: true, atom.P: true,
+	atom.Pre: true, atom.Section: true, atom.Table: true, atom.Tr: true, atom.Ul: true,
+}
+
+// skippedElements have no readable text content
+var skippedElements = map[atom.Atom]bool{
+	atom.Head: true, atom.Script: true, atom.Style: true, atom.Svg: true,
+	atom.Noscript: true, atom.Template: true,
+}
+
+// HTMLDocument holds the readable text of an HTML or XHTML document
+type HTMLDocument struct {
+	Title string
+	Text  string
+}
+
+// ExtractHTMLText strips markup from an HTML/XHTML document and returns
+// its readable text. Block-level elements are separated by newlines.
+func ExtractHTMLText(data []byte) (*HTMLDocument, error) {
+	doc := &HTMLDocument{}
+	tokenizer := html.NewTokenizer(bytes.NewReader(data))
+
+	var (
+		builder   strings.Builder
+		skipDepth int
+		preDepth  int
+		inTitle   bool
+		inHeading bool
+		heading   strings.Builder
+		title     strings.Builder
+	)
+
+	newline := func() {
+		s := builder.String()
+		if len(s) > 0 && !strings.HasSuffix(s, "\n") {
+			builder.WriteByte('\n')
+		}
+	}
+
+	for {
+		tt := tokenizer.Next()
+		switch tt {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != io.EOF {
+				return nil, err
+			}
+			doc.Title = strings.TrimSpace(title.String())
+			if doc.Title == "" {
+				doc.Title = strings.TrimSpace(heading.String())
+			}
+			doc.Text = normalizeText(builder.String())
+			return doc, nil
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			a := atom.Lookup(name)
+			if a == atom.Title {
+				inTitle = tt == html.StartTagToken
+				continue
+			}
+			if skippedElements[a] && tt == html.StartTagToken {
+				skipDepth++
+				continue
+			}
+			if blockElements[a] {
+				newline()
+			}
+			if a == atom.Pre && tt == html.StartTagToken {
+				preDepth++
+			}
+			if (a == atom.H1 || a == atom.H2 || a == atom.H3) && heading.Len() == 0 && tt == html.StartTagToken {
+				inHeading = true
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			a := atom.Lookup(name)
+			if a == atom.Title {
+				inTitle = false
+				continue
+			}
+			if skippedElements[a] {
+				if skipDepth > 0 {
+					skipDepth--
+				}
+				continue
+			}
+			if blockElements[a] {
+				newline()
+			}
+			if a == atom.Pre && preDepth > 0 {
+				preDepth--
+			}
+			if a == atom.H1 || a == atom.H2 || a == atom.H3 {
+				inHeading = false
+			}
+		case html.TextToken:
+			text := string(tokenizer.Text())
+			if inTitle {
+				title.WriteString(text)
+				continue
+			}
+			if skipDepth > 0 {
+				continue
+			}
+			if preDepth == 0 {
+				// Source line breaks are plain whitespace outside <pre>
+				text = strings.Map(func(r rune) rune {
+					if r == '\n' || r == '\r' || r == '\t' {
+						return ' '
+					}
+					return r
+				}, text)
+			}
+			if inHeading {
+				heading.WriteString(text)
+			}
+			builder.WriteString(text)
+		}
+	}
+}
+
+// normalizeText collapses runs of whitespace inside lines and drops empty lines
+func normalizeText(s string) string {
+	lines := strings.Split(s, "\n")
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			out = append(out, line)
+		}
+	}
+	return strings.Join(out, "\n")
+}
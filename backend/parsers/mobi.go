@@ -0,0 +1,389 @@
+package parsers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Errors returned by the MOBI parser
+var (
+	ErrInvalidMOBI             = errors.New("invalid mobi file")
+	ErrEncryptedMOBI           = errors.New("drm protected mobi files are not supported")
+	ErrUnsupportedMOBICompress = errors.New("unsupported mobi compression")
+)
+
+// MOBI compression types
+const (
+	mobiNoCompression       = 1
+	mobiPalmDOCCompression  = 2
+	mobiHuffCDICCompression = 17480
+)
+
+// MOBI text encodings
+const (
+	mobiEncodingCP1252 = 1252
+	mobiEncodingUTF8   = 65001
+)
+
+// EXTH record types
+const (
+	exthAuthor      = 100
+	exthPublisher   = 101
+	exthDescription = 103
+	exthISBN        = 104
+	exthSubject     = 105
+	exthPublishDate = 106
+	exthCoverOffset = 201
+	exthThumbOffset = 202
+	exthTitle       = 503
+	exthLanguage    = 524
+)
+
+// MOBIMetadata is the book information from the MOBI and EXTH headers
+type MOBIMetadata struct {
+	Title       string
+	Authors     []string
+	Publisher   string
+	Description string
+	ISBN        string
+	Language    string
+	Subjects    []string
+	PublishDate string
+
+	// CoverOffset and ThumbOffset are relative to the first image record,
+	// or -1 when the book has none
+	CoverOffset int
+	ThumbOffset int
+}
+
+// MOBI is a parsed MOBI or AZW3 (KF8) book
+type MOBI struct {
+	Metadata MOBIMetadata
+
+	data    []byte
+	records []int
+
+	compression     uint16
+	textLength      uint32
+	textRecordCount uint16
+	encoding        uint32
+	extraDataFlags  uint16
+	firstImageIndex uint32
+}
+
+// OpenMOBI parses the MOBI file at the given path
+func OpenMOBI(filePath string) (*MOBI, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return newMOBI(data)
+}
+
+// ParseMOBI parses a MOBI book from r
+func ParseMOBI(r io.ReaderAt, size int64) (*MOBI, error) {
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return newMOBI(data)
+}
+
+func newMOBI(data []byte) (*MOBI, error) {
+	// Palm database header
+	if len(data) < 78 {
+		return nil, fmt.Errorf("%w: file too short", ErrInvalidMOBI)
+	}
+	if typ := string(data[60:68]); typ != "BOOKMOBI" && typ != "TEXtREAd" {
+		return nil, fmt.Errorf("%w: unknown database type %q", ErrInvalidMOBI, typ)
+	}
+
+	count := int(binary.BigEndian.Uint16(data[76:78]))
+	if count == 0 || 78+count*8 > len(data) {
+		return nil, fmt.Errorf("%w: bad record list", ErrInvalidMOBI)
+	}
+	m := &MOBI{data: data, records: make([]int, count)}
+	for i := 0; i < count; i++ {
+		offset := int(binary.BigEndian.Uint32(data[78+i*8:]))
+		if offset > len(data) || (i > 0 && offset < m.records[i-1]) {
+			return nil, fmt.Errorf("%w: bad record offset", ErrInvalidMOBI)
+		}
+		m.records[i] = offset
+	}
+
+	// PalmDOC header
+	rec0 := m.record(0)
+	if len(rec0) < 16 {
+		return nil, fmt.Errorf("%w: short header record", ErrInvalidMOBI)
+	}
+	m.compression = binary.BigEndian.Uint16(rec0[0:2])
+	m.textLength = binary.BigEndian.Uint32(rec0[4:8])
+	m.textRecordCount = binary.BigEndian.Uint16(rec0[8:10])
+	if binary.BigEndian.Uint16(rec0[12:14]) != 0 {
+		return nil, ErrEncryptedMOBI
+	}
+	m.encoding = mobiEncodingCP1252
+	m.Metadata.CoverOffset = -1
+	m.Metadata.ThumbOffset = -1
+
+	// MOBI header; plain PalmDOC files have none
+	if len(rec0) >= 24 && string(rec0[16:20]) == "MOBI" {
+		if err := m.parseMOBIHeader(rec0); err != nil {
+			return nil, err
+		}
+	}
+	if m.Metadata.Title == "" {
+		m.Metadata.Title = strings.TrimRight(string(data[0:32]), "\x00")
+	}
+
+	return m, nil
+}
+
+// parseMOBIHeader reads the MOBI header and the optional EXTH block
+func (m *MOBI) parseMOBIHeader(rec0 []byte) error {
+	headerLength := int(binary.BigEndian.Uint32(rec0[20:24]))
+	if 16+headerLength > len(rec0) || headerLength < 0x74 {
+		return fmt.Errorf("%w: bad mobi header", ErrInvalidMOBI)
+	}
+	m.encoding = binary.BigEndian.Uint32(rec0[28:32])
+	m.firstImageIndex = binary.BigEndian.Uint32(rec0[108:112])
+	if headerLength >= 0xE4 {
+		m.extraDataFlags = binary.BigEndian.Uint16(rec0[0xF2:0xF4])
+	}
+
+	nameOffset := int(binary.BigEndian.Uint32(rec0[84:88]))
+	nameLength := int(binary.BigEndian.Uint32(rec0[88:92]))
+	if nameOffset > 0 && nameOffset+nameLength <= len(rec0) {
+		m.Metadata.Title = m.decodeString(rec0[nameOffset : nameOffset+nameLength])
+	}
+
+	exthFlags := binary.BigEndian.Uint32(rec0[128:132])
+	if exthFlags&0x40 != 0 {
+		m.parseEXTH(rec0[16+headerLength:])
+	}
+	return nil
+}
+
+// parseEXTH reads the metadata records of an EXTH block
+func (m *MOBI) parseEXTH(exth []byte) {
+	if len(exth) < 12 || string(exth[0:4]) != "EXTH" {
+		return
+	}
+	count := int(binary.BigEndian.Uint32(exth[8:12]))
+	pos := 12
+	for i := 0; i < count && pos+8 <= len(exth); i++ {
+		typ := binary.BigEndian.Uint32(exth[pos:])
+		length := int(binary.BigEndian.Uint32(exth[pos+4:]))
+		if length < 8 || pos+length > len(exth) {
+			return
+		}
+		value := exth[pos+8 : pos+length]
+		pos += length
+
+		switch typ {
+		case exthAuthor:
+			m.Metadata.Authors = append(m.Metadata.Authors, m.decodeString(value))
+		case exthPublisher:
+			m.Metadata.Publisher = m.decodeString(value)
+		case exthDescription:
+			if doc, err := ExtractHTMLText(value); err == nil {
+				m.Metadata.Description = doc.Text
+			}
+		case exthISBN:
+			if isbn := NormalizeISBN(m.decodeString(value)); isbn != "" {
+				m.Metadata.ISBN = isbn
+			}
+		case exthSubject:
+			m.Metadata.Subjects = append(m.Metadata.Subjects, m.decodeString(value))
+		case exthPublishDate:
+			m.Metadata.PublishDate = m.decodeString(value)
+		case exthTitle:
+			m.Metadata.Title = m.decodeString(value)
+		case exthLanguage:
+			m.Metadata.Language = m.decodeString(value)
+		case exthCoverOffset:
+			if len(value) == 4 {
+				m.Metadata.CoverOffset = int(binary.BigEndian.Uint32(value))
+			}
+		case exthThumbOffset:
+			if len(value) == 4 {
+				m.Metadata.ThumbOffset = int(binary.BigEndian.Uint32(value))
+			}
+		}
+	}
+}
+
+// record returns the raw bytes of record i
+func (m *MOBI) record(i int) []byte {
+	if i < 0 || i >= len(m.records) {
+		return nil
+	}
+	end := len(m.data)
+	if i+1 < len(m.records) {
+		end = m.records[i+1]
+	}
+	return m.data[m.records[i]:end]
+}
+
+// decodeString converts header text in the book's encoding to UTF-8
+func (m *MOBI) decodeString(b []byte) string {
+	if m.encoding == mobiEncodingCP1252 {
+		if s, err := charmap.Windows1252.NewDecoder().Bytes(b); err == nil {
+			b = s
+		}
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// HTML returns the decompressed book markup as UTF-8
+func (m *MOBI) HTML() ([]byte, error) {
+	switch m.compression {
+	case mobiNoCompression, mobiPalmDOCCompression:
+	case mobiHuffCDICCompression:
+		return nil, fmt.Errorf("%w: HUFF/CDIC", ErrUnsupportedMOBICompress)
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedMOBICompress, m.compression)
+	}
+
+	var buf bytes.Buffer
+	for i := 1; i <= int(m.textRecordCount); i++ {
+		rec := m.record(i)
+		if rec == nil {
+			return nil, fmt.Errorf("%w: missing text record %d", ErrInvalidMOBI, i)
+		}
+		rec = rec[:len(rec)-trailingEntriesSize(rec, m.extraDataFlags)]
+		if m.compression == mobiPalmDOCCompression {
+			rec = decompressPalmDOC(rec)
+		}
+		buf.Write(rec)
+	}
+
+	text := buf.Bytes()
+	if uint32(len(text)) > m.textLength {
+		text = text[:m.textLength]
+	}
+	if m.encoding == mobiEncodingCP1252 {
+		decoded, err := charmap.Windows1252.NewDecoder().Bytes(text)
+		if err != nil {
+			return nil, err
+		}
+		text = decoded
+	}
+	return text, nil
+}
+
+// Text returns the readable text of the book
+func (m *MOBI) Text() (string, error) {
+	markup, err := m.HTML()
+	if err != nil {
+		return "", err
+	}
+	doc, err := ExtractHTMLText(markup)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidMOBI, err)
+	}
+	return doc.Text, nil
+}
+
+// Image returns the image record at the given offset from the first image
+// record, as referenced by the EXTH cover and thumbnail entries
+func (m *MOBI) Image(offset int) ([]byte, bool) {
+	if offset < 0 || m.firstImageIndex == 0 || m.firstImageIndex == 0xFFFFFFFF {
+		return nil, false
+	}
+	rec := m.record(int(m.firstImageIndex) + offset)
+	if len(rec) == 0 {
+		return nil, false
+	}
+	return rec, true
+}
+
+// Cover returns the cover image named in the EXTH header, falling back to
+// the thumbnail. It reports false if the book has neither.
+func (m *MOBI) Cover() ([]byte, bool) {
+	if img, ok := m.Image(m.Metadata.CoverOffset); ok {
+		return img, true
+	}
+	return m.Image(m.Metadata.ThumbOffset)
+}
+
+// trailingEntriesSize returns the number of bytes of trailing entries
+// appended to a text record, as described by the extra data flags
+func trailingEntriesSize(rec []byte, flags uint16) int {
+	size := 0
+	for f := flags >> 1; f != 0 && size < len(rec); f >>= 1 {
+		if f&1 != 0 {
+			size += backwardVarint(rec[:len(rec)-size])
+		}
+	}
+	if flags&1 != 0 && size < len(rec) {
+		// Multibyte character overlap
+		size += int(rec[len(rec)-size-1]&0x3) + 1
+	}
+	if size > len(rec) {
+		return len(rec)
+	}
+	return size
+}
+
+// backwardVarint reads a variable-width integer stored at the end of b
+func backwardVarint(b []byte) int {
+	value, shift := 0, 0
+	for i := len(b) - 1; i >= 0 && shift < 28; i-- {
+		c := b[i]
+		value |= int(c&0x7F) << shift
+		shift += 7
+		if c&0x80 != 0 {
+			break
+		}
+	}
+	return value
+}
+
+// decompressPalmDOC expands PalmDOC (LZ77) compressed data
+func decompressPalmDOC(src []byte) []byte {
+	out := make([]byte, 0, len(src)*2)
+	for i := 0; i < len(src); {
+		c := src[i]
+		i++
+		switch {
+		case c == 0 || (c >= 0x09 && c <= 0x7F):
+			out = append(out, c)
+		case c >= 0x01 && c <= 0x08:
+			// Literal run of c bytes
+			end := i + int(c)
+			if end > len(src) {
+				end = len(src)
+			}
+			out = append(out, src[i:end]...)
+			i = end
+		case c >= 0x80 && c <= 0xBF:
+			// Back reference: 11 bits of distance and 3 bits of length
+			if i >= len(src) {
+				return out
+			}
+			pair := (int(c)<<8 | int(src[i])) & 0x3FFF
+			i++
+			distance := pair >> 3
+			length := pair&0x7 + 3
+			if distance == 0 || distance > len(out) {
+				continue
+			}
+			start := len(out) - distance
+			for j := 0; j < length; j++ {
+				out = append(out, out[start+j])
+			}
+		default:
+			// Space followed by a character
+			out = append(out, ' ', c^0x80)
+		}
+	}
+	return out
+}
@@ -0,0 +1,182 @@
+package parsers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// buildMOBI assembles a Palm database from record 0 and the following records
+func buildMOBI(name string, records ...[]byte) []byte {
+	var buf bytes.Buffer
+	header := make([]byte, 78)
+	copy(header, name)
+	copy(header[60:], "BOOKMOBI")
+	binary.BigEndian.PutUint16(header[76:], uint16(len(records)))
+	buf.Write(header)
+
+	offset := 78 + len(records)*8 + 2
+	for i, rec := range records {
+		entry := make([]byte, 8)
+		binary.BigEndian.PutUint32(entry, uint32(offset))
+		binary.BigEndian.PutUint32(entry[4:], uint32(i))
+		buf.Write(entry)
+		offset += len(rec)
+	}
+	buf.Write([]byte{0, 0})
+	for _, rec := range records {
+		buf.Write(rec)
+	}
+	return buf.Bytes()
+}
+
+// exthRecord encodes a single EXTH record
+func exthRecord(typ uint32, value []byte) []byte {
+	rec := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint32(rec, typ)
+	binary.BigEndian.PutUint32(rec[4:], uint32(8+len(value)))
+	return append(rec, value...)
+}
+
+// buildRecord0 builds the PalmDOC and MOBI headers with an EXTH block
+func buildRecord0(textLength, textRecords int, exth [][]byte, fullName string) []byte {
+	const mobiHeaderLength = 0xE8
+	rec := make([]byte, 16+mobiHeaderLength)
+	binary.BigEndian.PutUint16(rec[0:], mobiPalmDOCCompression)
+	binary.BigEndian.PutUint32(rec[4:], uint32(textLength))
+	binary.BigEndian.PutUint16(rec[8:], uint16(textRecords))
+	binary.BigEndian.PutUint16(rec[10:], 4096)
+
+	copy(rec[16:], "MOBI")
+	binary.BigEndian.PutUint32(rec[20:], mobiHeaderLength)
+	binary.BigEndian.PutUint32(rec[24:], 2)
+	binary.BigEndian.PutUint32(rec[28:], mobiEncodingUTF8)
+	binary.BigEndian.PutUint32(rec[108:], uint32(textRecords+1))
+	binary.BigEndian.PutUint32(rec[128:], 0x40)
+	// Multibyte overlap and one trailing entry
+	binary.BigEndian.PutUint16(rec[0xF2:], 0x3)
+
+	var block []byte
+	for _, r := range exth {
+		block = append(block, r...)
+	}
+	head := make([]byte, 12)
+	copy(head, "EXTH")
+	binary.BigEndian.PutUint32(head[4:], uint32(12+len(block)))
+	binary.BigEndian.PutUint32(head[8:], uint32(len(exth)))
+	rec = append(rec, head...)
+	rec = append(rec, block...)
+
+	binary.BigEndian.PutUint32(rec[84:], uint32(len(rec)))
+	binary.BigEndian.PutUint32(rec[88:], uint32(len(fullName)))
+	return append(rec, fullName...)
+}
+
+func TestParseMOBI(t *testing.T) {
+	// "<p>abc" literal, back reference (distance 3, length 6), " t" pair,
+	// "he end" literal, a literal run holding UTF-8 bytes, then "</p>"
+	compressed := []byte("<p>abc")
+	compressed = append(compressed, 0x80, 0x1B, 0xF4)
+	compressed = append(compressed, []byte("he end")...)
+	compressed = append(compressed, 0x03, 0xE4, 0xB8, 0xAD)
+	compressed = append(compressed, []byte("</p>")...)
+	expected := "<p>abcabcabc the end中</p>"
+
+	// Multibyte overlap byte, then a 3-byte trailing entry
+	textRecord := append(compressed, 0x00, 'X', 'Y', 0x83)
+
+	cover := []byte{0xFF, 0xD8, 0xFF, 0xE0}
+	rec0 := buildRecord0(len(expected), 1, [][]byte{
+		exthRecord(exthAuthor, []byte("鲁迅")),
+		exthRecord(exthPublisher, []byte("人民文学出版社")),
+		exthRecord(exthISBN, []byte("9787020024759")),
+		exthRecord(exthLanguage, []byte("zh")),
+		exthRecord(exthCoverOffset, []byte{0, 0, 0, 0}),
+		exthRecord(exthTitle, []byte("呐喊")),
+	}, "Full Name")
+
+	data := buildMOBI("Nahan", rec0, textRecord, cover)
+	mobi, err := ParseMOBI(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	markup, err := mobi.HTML()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(markup) != expected {
+		t.Errorf("expected %q but got %q", expected, markup)
+	}
+
+	text, err := mobi.Text()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if text != "abcabcabc the end中" {
+		t.Errorf("unexpected text %q", text)
+	}
+
+	meta := mobi.Metadata
+	if meta.Title != "呐喊" {
+		t.Errorf("expected EXTH title but got %q", meta.Title)
+	}
+	if len(meta.Authors) != 1 || meta.Authors[0] != "鲁迅" {
+		t.Errorf("unexpected authors %v", meta.Authors)
+	}
+	if meta.Publisher != "人民文学出版社" || meta.ISBN != "9787020024759" || meta.Language != "zh" {
+		t.Errorf("unexpected metadata %+v", meta)
+	}
+	if image, ok := mobi.Cover(); !ok || !bytes.Equal(image, cover) {
+		t.Errorf("expected cover record but got %v", image)
+	}
+}
+
+func TestParseMOBIInvalid(t *testing.T) {
+	encrypted := buildRecord0(0, 0, nil, "")
+	binary.BigEndian.PutUint16(encrypted[12:], 2)
+
+	testCases := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{name: "Too Short", data: []byte("BOOKMOBI"), err: ErrInvalidMOBI},
+		{name: "Wrong Type", data: append(bytes.Repeat([]byte{0}, 60), bytes.Repeat([]byte("PDF "), 10)...), err: ErrInvalidMOBI},
+		{name: "Encrypted", data: buildMOBI("drm", encrypted), err: ErrEncryptedMOBI},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseMOBI(bytes.NewReader(tc.data), int64(len(tc.data)))
+			if !errors.Is(err, tc.err) {
+				t.Errorf("expected %v but got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestTrailingEntriesSize(t *testing.T) {
+	testCases := []struct {
+		name  string
+		rec   []byte
+		flags uint16
+		size  int
+	}{
+		{name: "None", rec: []byte("text"), flags: 0, size: 0},
+		{name: "One Entry", rec: []byte("text\x01\x82"), flags: 0x2, size: 2},
+		{name: "Multibyte Overlap", rec: []byte("text\x01"), flags: 0x1, size: 2},
+		// Entries claiming more bytes than the record has
+		{name: "Entry Too Long", rec: []byte("\x85"), flags: 0x6, size: 1},
+		{name: "Entries Past Record", rec: []byte("ab\x82"), flags: 0xE, size: 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if size := trailingEntriesSize(tc.rec, tc.flags); size != tc.size {
+				t.Errorf("expected size %d but got %d", tc.size, size)
+			}
+		})
+	}
+}
@@ -0,0 +1,486 @@
+package parsers
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Errors returned by the PDF parser
+var (
+	ErrInvalidPDF   = errors.New("invalid pdf file")
+	ErrEncryptedPDF = errors.New("encrypted pdf files are not supported")
+)
+
+// maxPDFStreamSize caps the decoded size of a stream, so that a small
+// compressed stream can't expand to fill the memory
+const maxPDFStreamSize = 64 << 20
+
+var errPDFStreamTooLarge = errors.New("pdf stream too large")
+
+// PDFPage is the extracted text of a single page
+type PDFPage struct {
+	Number int
+	Text   string
+}
+
+// PDFInfo is the document information dictionary, with the document
+// language from the catalog
+type PDFInfo struct {
+	Title    string
+	Author   string
+	Subject  string
+	Keywords string
+	Language string
+}
+
+// PDF is a parsed PDF document
+type PDF struct {
+	data    []byte
+	offsets map[int]int
+	objStms map[int]objStmEntry
+	cache   map[int]interface{}
+	trailer pdfDict
+	fonts   map[pdfRef]*pdfFont
+
+	objStmsLoaded bool
+}
+
+type objStmEntry struct {
+	stream int
+	index  int
+}
+
+// objHeader matches "12 0 obj" at the start of a line or after whitespace
+var objHeader = regexp.MustCompile(`(?:^|[\r\n\t \f\x00])(\d+)[\r\n\t \f\x00]+(\d+)[\r\n\t \f\x00]+obj\b`)
+
+// OpenPDF parses the PDF file at the given path
+func OpenPDF(filePath string) (*PDF, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return newPDF(data)
+}
+
+// ParsePDF parses a PDF document from r
+func ParsePDF(r io.ReaderAt, size int64) (*PDF, error) {
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return newPDF(data)
+}
+
+// newPDF indexes the objects in data. Rather than trusting the cross-reference
+// table, which is frequently damaged, every "n g obj" header is located by
+// scanning the file; later definitions win, as with incremental updates.
+func newPDF(data []byte) (*PDF, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data[:min(len(data), 1024)], "\x00\r\n\t "), []byte("%PDF-")) {
+		return nil, fmt.Errorf("%w: missing %%PDF header", ErrInvalidPDF)
+	}
+
+	p := &PDF{
+		data:    data,
+		offsets: make(map[int]int),
+		objStms: make(map[int]objStmEntry),
+		cache:   make(map[int]interface{}),
+		fonts:   make(map[pdfRef]*pdfFont),
+	}
+
+	for _, m := range objHeader.FindAllSubmatchIndex(data, -1) {
+		num, err := strconv.Atoi(string(data[m[2]:m[3]]))
+		if err != nil {
+			continue
+		}
+		p.offsets[num] = m[2]
+	}
+	if len(p.offsets) == 0 {
+		return nil, fmt.Errorf("%w: no objects found", ErrInvalidPDF)
+	}
+
+	p.trailer = p.findTrailer()
+	if p.trailer == nil {
+		return nil, fmt.Errorf("%w: no document catalog", ErrInvalidPDF)
+	}
+	if _, ok := p.trailer["Encrypt"]; ok {
+		return nil, ErrEncryptedPDF
+	}
+
+	return p, nil
+}
+
+// findTrailer locates the trailer dictionary: the last "trailer" keyword,
+// a cross-reference stream, or failing both, the document catalog itself.
+func (p *PDF) findTrailer() pdfDict {
+	for idx := len(p.data); ; {
+		idx = bytes.LastIndex(p.data[:idx], []byte("trailer"))
+		if idx < 0 {
+			break
+		}
+		l := &pdfLexer{data: p.data, pos: idx + len("trailer")}
+		if obj, err := l.object(); err == nil {
+			if dict, ok := obj.(pdfDict); ok {
+				if _, ok := dict["Root"]; ok {
+					return dict
+				}
+			}
+		}
+	}
+
+	var catalog pdfRef
+	for num := range p.offsets {
+		obj := p.resolve(pdfRef{num: num})
+		var dict pdfDict
+		switch o := obj.(type) {
+		case *pdfStream:
+			dict = o.dict
+		case pdfDict:
+			dict = o
+		}
+		if dict == nil {
+			continue
+		}
+		switch dict["Type"] {
+		case pdfName("XRef"):
+			if _, ok := dict["Root"]; ok {
+				return dict
+			}
+		case pdfName("Catalog"):
+			catalog = pdfRef{num: num}
+		}
+	}
+	if catalog.num != 0 {
+		return pdfDict{"Root": catalog}
+	}
+	return nil
+}
+
+// resolve follows an indirect reference; any other object is returned as is
+func (p *PDF) resolve(obj interface{}) interface{} {
+	for depth := 0; depth < 32; depth++ {
+		ref, ok := obj.(pdfRef)
+		if !ok {
+			return obj
+		}
+		obj = p.load(ref.num)
+	}
+	return nil
+}
+
+// load returns the object with the given number, or nil if it does not exist
+func (p *PDF) load(num int) interface{} {
+	if obj, ok := p.cache[num]; ok {
+		return obj
+	}
+	// Guard against reference cycles while the object is being parsed
+	p.cache[num] = nil
+
+	var obj interface{}
+	if offset, ok := p.offsets[num]; ok {
+		obj = p.parseIndirect(offset)
+	} else {
+		p.loadObjStms()
+		if entry, ok := p.objStms[num]; ok {
+			obj = p.parseFromObjStm(entry)
+		}
+	}
+	p.cache[num] = obj
+	return obj
+}
+
+// parseIndirect parses "n g obj ... endobj" at offset
+func (p *PDF) parseIndirect(offset int) interface{} {
+	l := &pdfLexer{data: p.data, pos: offset}
+	for i := 0; i < 3; i++ {
+		if _, err := l.token(); err != nil {
+			return nil
+		}
+	}
+	obj, err := l.object()
+	if err != nil {
+		return nil
+	}
+
+	dict, ok := obj.(pdfDict)
+	if !ok {
+		return obj
+	}
+	save := l.pos
+	if tok, err := l.token(); err != nil || tok != pdfKeyword("stream") {
+		l.pos = save
+		return dict
+	}
+
+	// The stream data starts after the EOL following the keyword
+	start := l.pos
+	if start < len(p.data) && p.data[start] == '\r' {
+		start++
+	}
+	if start < len(p.data) && p.data[start] == '\n' {
+		start++
+	}
+
+	end := -1
+	length, ok := pdfNumber(p.resolve(dict["Length"]))
+	// Checked before adding, so that a huge length can't overflow
+	if ok && length >= 0 && length <= float64(len(p.data)-start) {
+		candidate := start + int(length)
+		rest := bytes.TrimLeft(p.data[candidate:min(len(p.data), candidate+32)], "\r\n\t \f\x00")
+		if bytes.HasPrefix(rest, []byte("endstream")) {
+			end = candidate
+		}
+	}
+	if end < 0 {
+		// Length is missing or wrong; fall back to the endstream keyword
+		idx := bytes.Index(p.data[start:], []byte("endstream"))
+		if idx < 0 {
+			return nil
+		}
+		end = start + idx
+		for end > start && (p.data[end-1] == '\n' || p.data[end-1] == '\r') {
+			end--
+		}
+	}
+
+	return &pdfStream{dict: dict, data: p.data[start:end]}
+}
+
+// loadObjStms indexes the objects stored inside compressed object streams
+func (p *PDF) loadObjStms() {
+	if p.objStmsLoaded {
+		return
+	}
+	p.objStmsLoaded = true
+
+	for num := range p.offsets {
+		stream, ok := p.resolve(pdfRef{num: num}).(*pdfStream)
+		if !ok || stream.dict["Type"] != pdfName("ObjStm") {
+			continue
+		}
+		data, err := p.decodeStream(stream)
+		if err != nil {
+			continue
+		}
+		n, _ := pdfNumber(stream.dict["N"])
+		l := &pdfLexer{data: data}
+		for i := 0; i < int(n); i++ {
+			objNum, err1 := l.token()
+			_, err2 := l.token()
+			if err1 != nil || err2 != nil {
+				break
+			}
+			if on, ok := objNum.(int64); ok {
+				if _, exists := p.offsets[int(on)]; !exists {
+					p.objStms[int(on)] = objStmEntry{stream: num, index: i}
+				}
+			}
+		}
+	}
+}
+
+// parseFromObjStm parses the index-th object of a compressed object stream
+func (p *PDF) parseFromObjStm(entry objStmEntry) interface{} {
+	stream, ok := p.resolve(pdfRef{num: entry.stream}).(*pdfStream)
+	if !ok {
+		return nil
+	}
+	data, err := p.decodeStream(stream)
+	if err != nil {
+		return nil
+	}
+	first, _ := pdfNumber(stream.dict["First"])
+
+	l := &pdfLexer{data: data}
+	var offset int64 = -1
+	for i := 0; i <= entry.index; i++ {
+		_, err1 := l.token()
+		off, err2 := l.token()
+		if err1 != nil || err2 != nil {
+			return nil
+		}
+		if i == entry.index {
+			offset, _ = off.(int64)
+		}
+	}
+	if first < 0 || offset < 0 || first > float64(len(data)) {
+		return nil
+	}
+	pos := int(first) + int(offset)
+	if pos < 0 || pos >= len(data) {
+		return nil
+	}
+	l.pos = pos
+	obj, err := l.object()
+	if err != nil {
+		return nil
+	}
+	return obj
+}
+
+// decodeStream applies the stream's filters
+func (p *PDF) decodeStream(s *pdfStream) ([]byte, error) {
+	var filters []interface{}
+	switch f := p.resolve(s.dict["Filter"]).(type) {
+	case pdfName:
+		filters = []interface{}{f}
+	case pdfArray:
+		filters = f
+	}
+
+	data := s.data
+	for _, filter := range filters {
+		name, _ := p.resolve(filter).(pdfName)
+		switch name {
+		case "FlateDecode", "Fl":
+			r, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			decoded, err := io.ReadAll(io.LimitReader(r, maxPDFStreamSize+1))
+			if len(decoded) > maxPDFStreamSize {
+				return nil, errPDFStreamTooLarge
+			}
+			// Truncated streams are common; keep whatever could be inflated
+			if err != nil && len(decoded) == 0 {
+				return nil, err
+			}
+			data = decoded
+		case "ASCIIHexDecode", "AHx":
+			l := &pdfLexer{data: append(append([]byte{'<'}, data...), '>')}
+			str, err := l.hexString()
+			if err != nil {
+				return nil, err
+			}
+			data = []byte(str.(pdfString))
+		case "ASCII85Decode", "A85":
+			src := bytes.TrimSpace(data)
+			src = bytes.TrimPrefix(src, []byte("<~"))
+			src = bytes.TrimSuffix(src, []byte("~>"))
+			dst := make([]byte, len(src)*4/5+4)
+			n, _, err := ascii85.Decode(dst, src, true)
+			if err != nil {
+				return nil, err
+			}
+			data = dst[:n]
+		default:
+			return nil, fmt.Errorf("unsupported pdf filter: %s", name)
+		}
+	}
+	return data, nil
+}
+
+// pageRef is a page dictionary with its inherited resources
+type pageRef struct {
+	dict      pdfDict
+	resources pdfDict
+}
+
+// pages walks the page tree in document order
+func (p *PDF) pages() []pageRef {
+	root, _ := p.resolve(p.trailer["Root"]).(pdfDict)
+	if root == nil {
+		return nil
+	}
+
+	var result []pageRef
+	visited := make(map[interface{}]bool)
+	var walk func(node interface{}, resources pdfDict)
+	walk = func(node interface{}, resources pdfDict) {
+		if ref, ok := node.(pdfRef); ok {
+			if visited[ref] {
+				return
+			}
+			visited[ref] = true
+		}
+		dict, ok := p.resolve(node).(pdfDict)
+		if !ok {
+			return
+		}
+		if res, ok := p.resolve(dict["Resources"]).(pdfDict); ok {
+			resources = res
+		}
+		kids, hasKids := p.resolve(dict["Kids"]).(pdfArray)
+		if dict["Type"] == pdfName("Page") || !hasKids {
+			result = append(result, pageRef{dict: dict, resources: resources})
+			return
+		}
+		for _, kid := range kids {
+			walk(kid, resources)
+		}
+	}
+	walk(root["Pages"], nil)
+	return result
+}
+
+// Pages extracts the text of every page
+func (p *PDF) Pages() ([]PDFPage, error) {
+	refs := p.pages()
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("%w: no pages", ErrInvalidPDF)
+	}
+
+	pages := make([]PDFPage, 0, len(refs))
+	for i, page := range refs {
+		var content []byte
+		switch c := p.resolve(page.dict["Contents"]).(type) {
+		case *pdfStream:
+			content, _ = p.decodeStream(c)
+		case pdfArray:
+			for _, part := range c {
+				if s, ok := p.resolve(part).(*pdfStream); ok {
+					if data, err := p.decodeStream(s); err == nil {
+						content = append(content, data...)
+						content = append(content, '\n')
+					}
+				}
+			}
+		}
+
+		ex := &textExtractor{pdf: p}
+		ex.run(content, page.resources, 0)
+		pages = append(pages, PDFPage{
+			Number: i + 1,
+			Text:   strings.TrimSpace(ex.text.String()),
+		})
+	}
+	return pages, nil
+}
+
+// Info returns the document information. Missing entries are left empty.
+func (p *PDF) Info() PDFInfo {
+	var info PDFInfo
+	if dict, ok := p.resolve(p.trailer["Info"]).(pdfDict); ok {
+		info.Title = p.infoString(dict["Title"])
+		info.Author = p.infoString(dict["Author"])
+		info.Subject = p.infoString(dict["Subject"])
+		info.Keywords = p.infoString(dict["Keywords"])
+	}
+	if root, ok := p.resolve(p.trailer["Root"]).(pdfDict); ok {
+		info.Language = p.infoString(root["Lang"])
+	}
+	return info
+}
+
+// infoString decodes a text string entry of the information dictionary
+func (p *PDF) infoString(obj interface{}) string {
+	s, ok := p.resolve(obj).(pdfString)
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(decodePDFDocString(string(s)), "\x00"))
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,347 @@
+package parsers
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+// PDF object model. Integers and reals are kept apart so that indirect
+// references ("12 0 R") can be recognised.
+type (
+	pdfName    string
+	pdfString  string
+	pdfKeyword string
+	pdfArray   []interface{}
+	pdfDict    map[pdfName]interface{}
+)
+
+type pdfRef struct {
+	num int
+	gen int
+}
+
+type pdfStream struct {
+	dict pdfDict
+	data []byte
+}
+
+var errPDFSyntax = errors.New("pdf syntax error")
+
+// maxPDFDepth is the deepest nesting of arrays and dictionaries parsed.
+// Real documents stay within a few levels; the limit keeps a crafted file
+// from exhausting the stack.
+const maxPDFDepth = 512
+
+var errPDFTooDeep = errors.New("pdf objects nested too deeply")
+
+// pdfLexer tokenizes PDF object syntax and content streams
+type pdfLexer struct {
+	data  []byte
+	pos   int
+	depth int // arrays and dictionaries being parsed
+}
+
+func isPDFSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0:
+		return true
+	}
+	return false
+}
+
+func isPDFDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// skipSpace skips whitespace and comments
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if isPDFSpace(c) {
+			l.pos++
+			continue
+		}
+		if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\r' && l.data[l.pos] != '\n' {
+				l.pos++
+			}
+			continue
+		}
+		return
+	}
+}
+
+// token returns the next primitive token. Composite objects are returned
+// as the delimiter keywords "[", "]", "<<", ">>", "{" and "}".
+func (l *pdfLexer) token() (interface{}, error) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, errPDFSyntax
+	}
+
+	c := l.data[l.pos]
+	switch {
+	case c == '/':
+		l.pos++
+		start := l.pos
+		for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+			l.pos++
+		}
+		return pdfName(unescapeName(l.data[start:l.pos])), nil
+	case c == '(':
+		return l.literalString()
+	case c == '<':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '<' {
+			l.pos += 2
+			return pdfKeyword("<<"), nil
+		}
+		return l.hexString()
+	case c == '>':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '>' {
+			l.pos += 2
+			return pdfKeyword(">>"), nil
+		}
+		l.pos++
+		return nil, errPDFSyntax
+	case c == '[' || c == ']' || c == '{' || c == '}':
+		l.pos++
+		return pdfKeyword(string(c)), nil
+	case c == ')':
+		l.pos++
+		return nil, errPDFSyntax
+	}
+
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	word := string(l.data[start:l.pos])
+	if word == "" {
+		l.pos++
+		return nil, errPDFSyntax
+	}
+	if n, err := strconv.ParseInt(word, 10, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(word, 64); err == nil {
+		return f, nil
+	}
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	return pdfKeyword(word), nil
+}
+
+// object parses a complete object, including arrays, dictionaries and
+// indirect references. Streams are handled by the document.
+func (l *pdfLexer) object() (interface{}, error) {
+	tok, err := l.token()
+	if err != nil {
+		return nil, err
+	}
+	return l.objectFrom(tok)
+}
+
+func (l *pdfLexer) objectFrom(tok interface{}) (interface{}, error) {
+	switch t := tok.(type) {
+	case pdfKeyword:
+		if t == "[" || t == "<<" {
+			if l.depth >= maxPDFDepth {
+				return nil, errPDFTooDeep
+			}
+			l.depth++
+			defer func() { l.depth-- }()
+		}
+		switch t {
+		case "[":
+			arr := pdfArray{}
+			for {
+				next, err := l.token()
+				if err != nil {
+					return nil, err
+				}
+				if next == pdfKeyword("]") {
+					return arr, nil
+				}
+				obj, err := l.objectFrom(next)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, obj)
+			}
+		case "<<":
+			dict := pdfDict{}
+			for {
+				next, err := l.token()
+				if err != nil {
+					return nil, err
+				}
+				if next == pdfKeyword(">>") {
+					return dict, nil
+				}
+				key, ok := next.(pdfName)
+				if !ok {
+					return nil, errPDFSyntax
+				}
+				value, err := l.object()
+				if err != nil {
+					return nil, err
+				}
+				dict[key] = value
+			}
+		}
+		return t, nil
+	case int64:
+		// Look ahead for "gen R"
+		save := l.pos
+		if gen, err := l.token(); err == nil {
+			if g, ok := gen.(int64); ok {
+				if r, err := l.token(); err == nil && r == pdfKeyword("R") {
+					return pdfRef{num: int(t), gen: int(g)}, nil
+				}
+			}
+		}
+		l.pos = save
+		return t, nil
+	}
+	return tok, nil
+}
+
+func (l *pdfLexer) literalString() (interface{}, error) {
+	l.pos++ // (
+	var buf bytes.Buffer
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfString(buf.String()), nil
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return nil, errPDFSyntax
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				buf.WriteByte('\n')
+			case 'r':
+				buf.WriteByte('\r')
+			case 't':
+				buf.WriteByte('\t')
+			case 'b':
+				buf.WriteByte('\b')
+			case 'f':
+				buf.WriteByte('\f')
+			case '\r':
+				// Line continuation
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					buf.WriteByte(byte(v))
+				} else {
+					buf.WriteByte(e)
+				}
+			}
+			continue
+		}
+		buf.WriteByte(c)
+	}
+	return nil, errPDFSyntax
+}
+
+func (l *pdfLexer) hexString() (interface{}, error) {
+	l.pos++ // <
+	var buf bytes.Buffer
+	var hi byte
+	odd := false
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		if c == '>' {
+			if odd {
+				buf.WriteByte(hi << 4)
+			}
+			return pdfString(buf.String()), nil
+		}
+		v, ok := hexValue(c)
+		if !ok {
+			continue
+		}
+		if odd {
+			buf.WriteByte(hi<<4 | v)
+		} else {
+			hi = v
+		}
+		odd = !odd
+	}
+	return nil, errPDFSyntax
+}
+
+func hexValue(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// unescapeName decodes #xx escapes in a name
+func unescapeName(b []byte) string {
+	if bytes.IndexByte(b, '#') < 0 {
+		return string(b)
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(b); i++ {
+		if b[i] == '#' && i+2 < len(b) {
+			hi, ok1 := hexValue(b[i+1])
+			lo, ok2 := hexValue(b[i+2])
+			if ok1 && ok2 {
+				buf.WriteByte(hi<<4 | lo)
+				i += 2
+				continue
+			}
+		}
+		buf.WriteByte(b[i])
+	}
+	return buf.String()
+}
+
+// pdfNumber converts an integer or real object to float64
+func pdfNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
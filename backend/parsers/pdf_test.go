@@ -0,0 +1,224 @@
+package parsers
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildPDF assembles a PDF from numbered object bodies (object i+1 is objects[i]).
+// Empty bodies are left out, as for objects stored in object streams.
+func buildPDF(objects []string, trailer string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		if obj == "" {
+			continue
+		}
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for i, off := range offsets {
+		if objects[i] == "" {
+			buf.WriteString("0000000000 65535 f \n")
+			continue
+		}
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n%s\nstartxref\n%d\n%%%%EOF\n", trailer, xref)
+	return buf.Bytes()
+}
+
+// stream formats a stream object, optionally Flate-compressed
+func stream(dict string, data string, compress bool) string {
+	body := []byte(data)
+	if compress {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write(body)
+		zw.Close()
+		body = buf.Bytes()
+		dict += " /Filter /FlateDecode"
+	}
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(body), body)
+}
+
+const testCMap = `/CIDInit /ProcSet findresource begin
+12 dict begin
+begincmap
+1 begincodespacerange
+<0000> <FFFF>
+endcodespacerange
+2 beginbfchar
+<0001> <4E2D>
+<0002> <6587>
+endbfchar
+1 beginbfrange
+<0010> <0012> <0041>
+endbfrange
+endcmap
+CMapName currentdict /CMap defineresource pop
+end
+end`
+
+func TestParsePDF(t *testing.T) {
+	// Object 8 (the CJK font) lives inside the object stream 9
+	fontObj := "<< /Type /Font /Subtype /Type0 /BaseFont /SimSun /Encoding /Identity-H /ToUnicode 7 0 R >>"
+	objStm := fmt.Sprintf("8 0 %s", fontObj)
+	objStm = stream(fmt.Sprintf("/Type /ObjStm /N 1 /First %d", len("8 0 ")), objStm, true)
+
+	data := buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /Resources << /Font << /F1 5 0 R /F2 8 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 6 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /Contents [10 0 R] >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding << /Differences [39 /quoteright] >> >>",
+		stream("", "BT /F1 12 Tf 72 720 Td (Hello) Tj [(W) 20 (orld) -300 (it\\047s)] TJ 0 -14 Td (Second line) Tj ET", false),
+		stream("", testCMap, true),
+		"",
+		objStm,
+		stream("", "BT /F2 12 Tf 1 0 0 1 72 720 Tm <00010002> Tj 1 0 0 1 72 700 Tm <001000110012> Tj ET", true),
+	}, "<< /Size 11 /Root 1 0 R >>")
+
+	pdf, err := ParsePDF(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pages, err := pdf.Pages()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pages) != 2 {
+		t.Fatalf("expected 2 pages but got %d", len(pages))
+	}
+
+	expected := []string{
+		"HelloWorld it’s\nSecond line",
+		"中文\nABC",
+	}
+	for i, page := range pages {
+		if page.Number != i+1 {
+			t.Errorf("expected page number %d but got %d", i+1, page.Number)
+		}
+		if page.Text != expected[i] {
+			t.Errorf("page %d: expected %q but got %q", i+1, expected[i], page.Text)
+		}
+	}
+}
+
+func TestPDFInfo(t *testing.T) {
+	data := buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R /Lang (zh-CN) >>",
+		"<< /Type /Pages /Kids [] /Count 0 >>",
+		"<< /Title <FEFF6D4B8BD54E66> /Author (Jos\351 Saramago) /Subject (  A test  ) >>",
+	}, "<< /Size 4 /Root 1 0 R /Info 3 0 R >>")
+
+	pdf, err := ParsePDF(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := PDFInfo{Title: "测试书", Author: "José Saramago", Subject: "A test", Language: "zh-CN"}
+	if info := pdf.Info(); info != expected {
+		t.Errorf("expected %+v but got %+v", expected, info)
+	}
+}
+
+func TestParsePDFInvalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{name: "Not a PDF", data: []byte("test content"), err: ErrInvalidPDF},
+		{name: "No Objects", data: []byte("%PDF-1.4\n%%EOF"), err: ErrInvalidPDF},
+		{
+			name: "Encrypted",
+			data: buildPDF([]string{"<< /Type /Catalog >>", "<< /Filter /Standard >>"}, "<< /Root 1 0 R /Encrypt 2 0 R >>"),
+			err:  ErrEncryptedPDF,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParsePDF(bytes.NewReader(tc.data), int64(len(tc.data)))
+			if !errors.Is(err, tc.err) {
+				t.Errorf("expected %v but got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestParsePDFMalformed(t *testing.T) {
+	page := "<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>"
+	testCases := []struct {
+		name string
+		data []byte
+	}{
+		{
+			// Would exhaust the stack without the nesting limit
+			name: "Deep Nesting",
+			data: buildPDF([]string{"<< /Type /Catalog >>"}, strings.Repeat("[", 1<<20)),
+		},
+		{
+			name: "Huge Length",
+			data: buildPDF([]string{
+				"<< /Type /Catalog /Pages 2 0 R >>",
+				"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+				page,
+				"<< /Length 1e30 >>\nstream\nBT (Hello) Tj ET\nendstream",
+			}, "<< /Size 5 /Root 1 0 R >>"),
+		},
+		{
+			name: "Negative Object Stream Offset",
+			data: buildPDF([]string{
+				"<< /Type /Catalog /Pages 2 0 R >>",
+				"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+				page,
+				"",
+				stream("/Type /ObjStm /N 1 /First -100", "4 0 << /Length 0 >>", true),
+			}, "<< /Size 6 /Root 1 0 R >>"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Errors are fine; panics and crashes are not
+			pdf, err := ParsePDF(bytes.NewReader(tc.data), int64(len(tc.data)))
+			if err != nil {
+				return
+			}
+			pdf.Info()
+			pdf.Pages()
+		})
+	}
+
+	t.Run("Nesting Limit", func(t *testing.T) {
+		l := &pdfLexer{data: []byte(strings.Repeat("[", maxPDFDepth+1))}
+		if _, err := l.object(); err != errPDFTooDeep {
+			t.Errorf("expected error %v but got %v", errPDFTooDeep, err)
+		}
+		l = &pdfLexer{data: []byte(strings.Repeat("[", maxPDFDepth) + strings.Repeat("]", maxPDFDepth))}
+		if _, err := l.object(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Decompression Bomb", func(t *testing.T) {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write(make([]byte, maxPDFStreamSize+1))
+		zw.Close()
+		s := &pdfStream{dict: pdfDict{"Filter": pdfName("FlateDecode")}, data: buf.Bytes()}
+		if _, err := (&PDF{}).decodeStream(s); err != errPDFStreamTooLarge {
+			t.Errorf("expected error %v but got %v", errPDFStreamTooLarge, err)
+		}
+	})
+}
@@ -0,0 +1,626 @@
+package parsers
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// pdfFont decodes the bytes of a shown string into Unicode text
+type pdfFont struct {
+	toUnicode *pdfCMap
+	composite bool
+	encoding  *[256]rune
+
+	// Glyph widths in thousandths of an em, used to tell word gaps from
+	// per-glyph positioning
+	widths       map[int]float64
+	defaultWidth float64
+}
+
+// pdfCMap is a parsed ToUnicode CMap
+type pdfCMap struct {
+	codeLengths []int
+	chars       map[string]string
+}
+
+// textExtractor interprets page content streams and collects the shown text
+type textExtractor struct {
+	pdf      *PDF
+	text     bytes.Buffer
+	font     *pdfFont
+	fontSize float64
+	leading  float64
+
+	// Position of the current line start and the advance of the text shown
+	// since, in unscaled text space units
+	lineX, lineY float64
+	advance      float64
+
+	// Baseline and end of the most recently shown text, kept across text
+	// objects since each BT resets the text matrix
+	lastY, lastEnd float64
+	haveLast       bool
+}
+
+// maxFormDepth limits recursion into nested form XObjects
+const maxFormDepth = 8
+
+func (ex *textExtractor) run(content []byte, resources pdfDict, depth int) {
+	l := &pdfLexer{data: content}
+	var operands []interface{}
+
+	for {
+		tok, err := l.token()
+		if err != nil {
+			if l.pos >= len(l.data) {
+				return
+			}
+			operands = operands[:0]
+			continue
+		}
+		op, isOp := tok.(pdfKeyword)
+		if !isOp || op == "[" || op == "<<" {
+			obj, err := l.objectFrom(tok)
+			if err != nil {
+				operands = operands[:0]
+				continue
+			}
+			operands = append(operands, obj)
+			continue
+		}
+
+		switch op {
+		case "BT":
+			ex.lineX, ex.lineY, ex.advance = 0, 0, 0
+		case "Tf":
+			if len(operands) >= 2 {
+				if name, ok := operands[0].(pdfName); ok {
+					ex.font = ex.loadFont(resources, name)
+				}
+				ex.fontSize, _ = pdfNumber(operands[1])
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				tx, _ := pdfNumber(operands[0])
+				ty, _ := pdfNumber(operands[1])
+				if op == "TD" {
+					ex.leading = -ty
+				}
+				ex.moveTo(ex.lineX+tx, ex.lineY+ty)
+			}
+		case "TL":
+			if len(operands) >= 1 {
+				ex.leading, _ = pdfNumber(operands[0])
+			}
+		case "Tm":
+			if len(operands) >= 6 {
+				x, _ := pdfNumber(operands[4])
+				y, _ := pdfNumber(operands[5])
+				ex.moveTo(x, y)
+			}
+		case "T*":
+			ex.nextLine()
+		case "Tj":
+			if len(operands) >= 1 {
+				ex.show(operands[0])
+			}
+		case "'":
+			ex.nextLine()
+			if len(operands) >= 1 {
+				ex.show(operands[len(operands)-1])
+			}
+		case "\"":
+			ex.nextLine()
+			if len(operands) >= 3 {
+				ex.show(operands[2])
+			}
+		case "TJ":
+			if len(operands) >= 1 {
+				if arr, ok := operands[0].(pdfArray); ok {
+					for _, item := range arr {
+						if n, ok := pdfNumber(item); ok {
+							ex.advance -= n / 1000 * ex.fontSize
+							continue
+						}
+						ex.show(item)
+					}
+				}
+			}
+		case "ET":
+		case "Do":
+			if len(operands) >= 1 && depth < maxFormDepth {
+				if name, ok := operands[0].(pdfName); ok {
+					ex.runForm(resources, name, depth)
+				}
+			}
+		case "BI":
+			// Skip inline image data, which may contain arbitrary bytes
+			idx := bytes.Index(l.data[l.pos:], []byte("ID"))
+			if idx < 0 {
+				return
+			}
+			l.pos += idx + 2
+			end := bytes.Index(l.data[l.pos:], []byte("EI"))
+			for end >= 0 {
+				next := l.pos + end + 2
+				if end > 0 && isPDFSpace(l.data[l.pos+end-1]) && (next >= len(l.data) || isPDFSpace(l.data[next])) {
+					break
+				}
+				more := bytes.Index(l.data[next:], []byte("EI"))
+				if more < 0 {
+					end = -1
+					break
+				}
+				end = next - l.pos + more
+			}
+			if end < 0 {
+				return
+			}
+			l.pos += end + 2
+		}
+		operands = operands[:0]
+	}
+}
+
+// runForm extracts text from a form XObject
+func (ex *textExtractor) runForm(resources pdfDict, name pdfName, depth int) {
+	xobjects, _ := ex.pdf.resolve(resources["XObject"]).(pdfDict)
+	if xobjects == nil {
+		return
+	}
+	form, ok := ex.pdf.resolve(xobjects[name]).(*pdfStream)
+	if !ok || form.dict["Subtype"] != pdfName("Form") {
+		return
+	}
+	data, err := ex.pdf.decodeStream(form)
+	if err != nil {
+		return
+	}
+	formResources, _ := ex.pdf.resolve(form.dict["Resources"]).(pdfDict)
+	if formResources == nil {
+		formResources = resources
+	}
+	saved := ex.font
+	ex.run(data, formResources, depth+1)
+	ex.font = saved
+}
+
+// moveTo starts a new text line at (x, y). A change of baseline starts a new
+// line of output; a horizontal jump past the end of the shown text is a word gap.
+func (ex *textExtractor) moveTo(x, y float64) {
+	ex.lineX, ex.lineY, ex.advance = x, y, 0
+}
+
+// nextLine moves to the start of the next line
+func (ex *textExtractor) nextLine() {
+	leading := ex.leading
+	if leading == 0 {
+		leading = math.Max(ex.fontSize, 1)
+	}
+	ex.moveTo(ex.lineX, ex.lineY-leading)
+}
+
+// place separates text shown at the current position from the text shown
+// before it
+func (ex *textExtractor) place() {
+	x := ex.lineX + ex.advance
+	if ex.haveLast {
+		if math.Abs(ex.lineY-ex.lastY) > 0.5 {
+			ex.newline()
+		} else if gap := x - ex.lastEnd; gap > 0.2*math.Max(ex.fontSize, 1) || gap < -ex.fontSize {
+			ex.space()
+		}
+	}
+}
+
+func (ex *textExtractor) show(obj interface{}) {
+	s, ok := obj.(pdfString)
+	if !ok {
+		return
+	}
+	ex.place()
+	if ex.font == nil {
+		ex.text.WriteString(decodePDFDocString(string(s)))
+		ex.advance += float64(len(s)) * 0.5 * ex.fontSize
+	} else {
+		ex.text.WriteString(ex.font.decode([]byte(s)))
+		ex.advance += ex.font.width([]byte(s)) / 1000 * ex.fontSize
+	}
+	ex.lastY, ex.lastEnd, ex.haveLast = ex.lineY, ex.lineX+ex.advance, true
+}
+
+func (ex *textExtractor) newline() {
+	b := ex.text.Bytes()
+	n := len(b)
+	for n > 0 && b[n-1] == ' ' {
+		n--
+	}
+	ex.text.Truncate(n)
+	if n > 0 && b[n-1] != '\n' {
+		ex.text.WriteByte('\n')
+	}
+}
+
+func (ex *textExtractor) space() {
+	b := ex.text.Bytes()
+	if n := len(b); n > 0 && b[n-1] != ' ' && b[n-1] != '\n' {
+		ex.text.WriteByte(' ')
+	}
+}
+
+// loadFont returns the decoder for a font resource, caching it per object
+func (ex *textExtractor) loadFont(resources pdfDict, name pdfName) *pdfFont {
+	fonts, _ := ex.pdf.resolve(resources["Font"]).(pdfDict)
+	if fonts == nil {
+		return nil
+	}
+	ref, isRef := fonts[name].(pdfRef)
+	if isRef {
+		if font, ok := ex.pdf.fonts[ref]; ok {
+			return font
+		}
+	}
+	dict, ok := ex.pdf.resolve(fonts[name]).(pdfDict)
+	if !ok {
+		return nil
+	}
+
+	font := &pdfFont{composite: dict["Subtype"] == pdfName("Type0")}
+	if stream, ok := ex.pdf.resolve(dict["ToUnicode"]).(*pdfStream); ok {
+		if data, err := ex.pdf.decodeStream(stream); err == nil {
+			font.toUnicode = parseCMap(data)
+		}
+	}
+	if font.composite {
+		ex.pdf.loadCIDWidths(font, dict)
+	} else {
+		font.encoding = ex.pdf.simpleEncoding(dict["Encoding"])
+		ex.pdf.loadSimpleWidths(font, dict)
+	}
+
+	if isRef {
+		ex.pdf.fonts[ref] = font
+	}
+	return font
+}
+
+// decode converts a shown string to text
+func (f *pdfFont) decode(b []byte) string {
+	var out strings.Builder
+	if f.toUnicode != nil {
+		for len(b) > 0 {
+			n := f.toUnicode.match(b, f.composite)
+			if s, ok := f.toUnicode.chars[string(b[:n])]; ok {
+				out.WriteString(s)
+			}
+			b = b[n:]
+		}
+		return out.String()
+	}
+	if f.composite {
+		// Without a ToUnicode map, CIDs can't be mapped to characters
+		return ""
+	}
+	for _, c := range b {
+		if r := f.encoding[c]; r != 0 {
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
+
+// width returns the total advance of a shown string in thousandths of an em
+func (f *pdfFont) width(b []byte) float64 {
+	total := 0.0
+	step := 1
+	if f.composite {
+		step = 2
+	}
+	for i := 0; i+step <= len(b); i += step {
+		code := bytesToInt(b[i : i+step])
+		if w, ok := f.widths[code]; ok {
+			total += w
+		} else {
+			total += f.defaultWidth
+		}
+	}
+	return total
+}
+
+// loadSimpleWidths reads the FirstChar/Widths arrays of a simple font
+func (p *PDF) loadSimpleWidths(font *pdfFont, dict pdfDict) {
+	// The standard 14 fonts may omit widths; assume an average glyph
+	font.defaultWidth = 500
+	widths, _ := p.resolve(dict["Widths"]).(pdfArray)
+	if widths == nil {
+		return
+	}
+	first, _ := pdfNumber(p.resolve(dict["FirstChar"]))
+	font.widths = make(map[int]float64, len(widths))
+	for i, w := range widths {
+		if v, ok := pdfNumber(p.resolve(w)); ok {
+			font.widths[int(first)+i] = v
+		}
+	}
+}
+
+// loadCIDWidths reads the DW and W entries of a Type0 font's descendant.
+// Codes are assumed to equal CIDs, as with the Identity-H encoding.
+func (p *PDF) loadCIDWidths(font *pdfFont, dict pdfDict) {
+	font.defaultWidth = 1000
+	descendants, _ := p.resolve(dict["DescendantFonts"]).(pdfArray)
+	if len(descendants) == 0 {
+		return
+	}
+	cidFont, _ := p.resolve(descendants[0]).(pdfDict)
+	if cidFont == nil {
+		return
+	}
+	if dw, ok := pdfNumber(p.resolve(cidFont["DW"])); ok {
+		font.defaultWidth = dw
+	}
+	w, _ := p.resolve(cidFont["W"]).(pdfArray)
+	font.widths = make(map[int]float64)
+	for i := 0; i < len(w); {
+		start, ok := pdfNumber(p.resolve(w[i]))
+		if !ok || i+1 >= len(w) {
+			return
+		}
+		// Either "c [w1 w2 ...]" or "c_first c_last w"
+		if list, ok := p.resolve(w[i+1]).(pdfArray); ok {
+			for j, v := range list {
+				if width, ok := pdfNumber(p.resolve(v)); ok {
+					font.widths[int(start)+j] = width
+				}
+			}
+			i += 2
+			continue
+		}
+		if i+2 >= len(w) {
+			return
+		}
+		end, _ := pdfNumber(p.resolve(w[i+1]))
+		width, _ := pdfNumber(p.resolve(w[i+2]))
+		for cid := int(start); cid <= int(end) && cid-int(start) <= maxCMapRange; cid++ {
+			font.widths[cid] = width
+		}
+		i += 3
+	}
+}
+
+// match returns the length of the code at the start of b
+func (c *pdfCMap) match(b []byte, composite bool) int {
+	for _, n := range c.codeLengths {
+		if n <= len(b) {
+			if _, ok := c.chars[string(b[:n])]; ok {
+				return n
+			}
+		}
+	}
+	if composite && len(b) >= 2 {
+		return 2
+	}
+	return 1
+}
+
+// parseCMap reads the bfchar and bfrange mappings of a ToUnicode CMap
+func parseCMap(data []byte) *pdfCMap {
+	cmap := &pdfCMap{chars: make(map[string]string)}
+	lengths := make(map[int]bool)
+	l := &pdfLexer{data: data}
+
+	var operands []interface{}
+	var mode string
+	for {
+		tok, err := l.token()
+		if err != nil {
+			if l.pos >= len(l.data) {
+				break
+			}
+			continue
+		}
+		if kw, ok := tok.(pdfKeyword); ok && kw != "[" && kw != "<<" {
+			switch kw {
+			case "begincodespacerange", "beginbfchar", "beginbfrange":
+				mode = string(kw)
+			case "endcodespacerange":
+				for i := 0; i+1 < len(operands); i += 2 {
+					if lo, ok := operands[i].(pdfString); ok {
+						lengths[len(lo)] = true
+					}
+				}
+				mode = ""
+			case "endbfchar":
+				for i := 0; i+1 < len(operands); i += 2 {
+					src, ok1 := operands[i].(pdfString)
+					dst, ok2 := operands[i+1].(pdfString)
+					if ok1 && ok2 {
+						cmap.chars[string(src)] = decodeUTF16BE([]byte(dst))
+						lengths[len(src)] = true
+					}
+				}
+				mode = ""
+			case "endbfrange":
+				for i := 0; i+2 < len(operands); i += 3 {
+					lo, ok1 := operands[i].(pdfString)
+					hi, ok2 := operands[i+1].(pdfString)
+					if !ok1 || !ok2 || len(lo) != len(hi) || len(lo) == 0 {
+						continue
+					}
+					lengths[len(lo)] = true
+					cmap.addRange([]byte(lo), []byte(hi), operands[i+2])
+				}
+				mode = ""
+			}
+			operands = operands[:0]
+			continue
+		}
+		if mode == "" {
+			continue
+		}
+		obj, err := l.objectFrom(tok)
+		if err == nil {
+			operands = append(operands, obj)
+		}
+	}
+
+	for n := 4; n >= 1; n-- {
+		if lengths[n] {
+			cmap.codeLengths = append(cmap.codeLengths, n)
+		}
+	}
+	return cmap
+}
+
+// maxCMapRange bounds the size of a single bfrange to keep malformed maps cheap
+const maxCMapRange = 1 << 16
+
+func (c *pdfCMap) addRange(lo, hi []byte, dst interface{}) {
+	start := bytesToInt(lo)
+	end := bytesToInt(hi)
+	if end < start || end-start > maxCMapRange {
+		return
+	}
+	for code := start; code <= end; code++ {
+		key := intToBytes(code, len(lo))
+		switch d := dst.(type) {
+		case pdfString:
+			// Increment the last UTF-16 unit of the destination
+			buf := []byte(d)
+			if len(buf) < 2 {
+				continue
+			}
+			units := make([]byte, len(buf))
+			copy(units, buf)
+			last := int(units[len(units)-2])<<8 | int(units[len(units)-1])
+			last += code - start
+			units[len(units)-2] = byte(last >> 8)
+			units[len(units)-1] = byte(last)
+			c.chars[string(key)] = decodeUTF16BE(units)
+		case pdfArray:
+			if idx := code - start; idx < len(d) {
+				if s, ok := d[idx].(pdfString); ok {
+					c.chars[string(key)] = decodeUTF16BE([]byte(s))
+				}
+			}
+		}
+	}
+}
+
+func bytesToInt(b []byte) int {
+	v := 0
+	for _, c := range b {
+		v = v<<8 | int(c)
+	}
+	return v
+}
+
+func intToBytes(v, n int) []byte {
+	b := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+	return b
+}
+
+func decodeUTF16BE(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// decodePDFDocString decodes a text string, which is either UTF-16BE with
+// a byte order mark or PDFDocEncoding (approximated by Windows-1252)
+func decodePDFDocString(s string) string {
+	if strings.HasPrefix(s, "\xfe\xff") {
+		return decodeUTF16BE([]byte(s[2:]))
+	}
+	if strings.HasPrefix(s, "\xef\xbb\xbf") {
+		return s[3:]
+	}
+	decoded, err := charmap.Windows1252.NewDecoder().String(s)
+	if err != nil {
+		return s
+	}
+	return decoded
+}
+
+// simpleEncoding builds the code-to-rune table of a simple font
+func (p *PDF) simpleEncoding(enc interface{}) *[256]rune {
+	table := new([256]rune)
+	base := charmap.Windows1252
+	var differences pdfArray
+
+	switch e := p.resolve(enc).(type) {
+	case pdfName:
+		if e == "MacRomanEncoding" {
+			base = charmap.Macintosh
+		}
+	case pdfDict:
+		if e["BaseEncoding"] == pdfName("MacRomanEncoding") {
+			base = charmap.Macintosh
+		}
+		differences, _ = p.resolve(e["Differences"]).(pdfArray)
+	}
+
+	for i := 0; i < 256; i++ {
+		if i < 0x20 {
+			continue
+		}
+		table[i] = base.DecodeByte(byte(i))
+	}
+
+	code := 0
+	for _, item := range differences {
+		switch v := item.(type) {
+		case int64:
+			code = int(v)
+		case pdfName:
+			if code >= 0 && code < 256 {
+				table[code] = glyphRune(string(v))
+			}
+			code++
+		}
+	}
+	return table
+}
+
+// commonGlyphs maps frequent glyph names that aren't single characters
+var commonGlyphs = map[string]rune{
+	"space": ' ', "exclam": '!', "quotedbl": '"', "numbersign": '#', "dollar": '$',
+	"percent": '%', "ampersand": '&', "quotesingle": '\'', "parenleft": '(',
+	"parenright": ')', "asterisk": '*', "plus": '+', "comma": ',', "hyphen": '-',
+	"period": '.', "slash": '/', "zero": '0', "one": '1', "two": '2', "three": '3',
+	"four": '4', "five": '5', "six": '6', "seven": '7', "eight": '8', "nine": '9',
+	"colon": ':', "semicolon": ';', "less": '<', "equal": '=', "greater": '>',
+	"question": '?', "at": '@', "bracketleft": '[', "backslash": '\\',
+	"bracketright": ']', "underscore": '_', "quoteleft": '‘', "quoteright": '’',
+	"quotedblleft": '“', "quotedblright": '”', "endash": '–', "emdash": '—',
+	"bullet": '•', "ellipsis": '…', "fi": 'ﬁ', "fl": 'ﬂ', "ff": 'ﬀ',
+}
+
+// glyphRune maps a glyph name to a rune
+func glyphRune(name string) rune {
+	if r, ok := commonGlyphs[name]; ok {
+		return r
+	}
+	if len(name) == 1 {
+		return rune(name[0])
+	}
+	for _, prefix := range []string{"uni", "u"} {
+		if strings.HasPrefix(name, prefix) && len(name) >= len(prefix)+4 {
+			if v, err := strconv.ParseUint(name[len(prefix):len(prefix)+4], 16, 32); err == nil {
+				return rune(v)
+			}
+		}
+	}
+	return 0
+}
@@ -7,7 +7,8 @@ echo "Setting up test environment..."
 
 # Create test database
 echo "Creating test database..."
-mysql -u root -p < scripts/setup_test_db.sql
+mysql -u root -p -e "CREATE DATABASE IF NOT EXISTS bookpavilion_test;"
+DB_USER=${DB_USER:-root} DB_NAME=bookpavilion_test go run . migrate up
 
 # Create test uploads directory
 echo "Creating test uploads directory..."
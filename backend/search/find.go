@@ -0,0 +1,86 @@
+package search
+
+import (
+	"errors"
+	"regexp"
+	"unicode/utf8"
+)
+
+// maxPatternLength is the longest query, in bytes, that is compiled
+const maxPatternLength = 1000
+
+// ErrPatternTooLong is returned for queries over maxPatternLength
+var ErrPatternTooLong = errors.New("search pattern is too long")
+
+// Options select how a query is matched against text
+type Options struct {
+	CaseSensitive bool // match letter case exactly
+	WholeWord     bool // match only where no letter or digit adjoins
+	Regexp        bool // the query is a regular expression
+}
+
+// Finder locates the occurrences of a query in text
+type Finder struct {
+	re        *regexp.Regexp
+	wholeWord bool
+}
+
+// NewFinder compiles a query. Regular expressions use the RE2 syntax, so
+// matching time stays linear in the length of the text.
+func NewFinder(query string, opts Options) (*Finder, error) {
+	if len(query) > maxPatternLength {
+		return nil, ErrPatternTooLong
+	}
+	pattern := query
+	if !opts.Regexp {
+		pattern = regexp.QuoteMeta(query)
+	}
+	if !opts.CaseSensitive {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &Finder{re: re, wholeWord: opts.WholeWord}, nil
+}
+
+// FindAll returns the occurrences of the query in text, in order of
+// position. Empty matches are skipped. If limit is positive, at most limit
+// matches are returned, and more reports whether any were left out.
+func (f *Finder) FindAll(text string, limit int) (matches []Match, more bool) {
+	pos, chars := 0, 0
+	for _, loc := range f.re.FindAllStringIndex(text, -1) {
+		start, end := loc[0], loc[1]
+		if start == end {
+			continue
+		}
+		if f.wholeWord && !isWordBoundary(text, start, end) {
+			continue
+		}
+		if limit > 0 && len(matches) == limit {
+			return matches, true
+		}
+		chars += utf8.RuneCountInString(text[pos:start])
+		pos = start
+		matches = append(matches, Match{Offset: chars, Length: utf8.RuneCountInString(text[start:end])})
+	}
+	return matches, false
+}
+
+// isWordBoundary reports whether text[start:end] stands as a whole word:
+// the characters either side are not part of a word. CJK characters are
+// written without spaces, so they never join a match to its neighbours.
+func isWordBoundary(text string, start, end int) bool {
+	if before, _ := utf8.DecodeLastRuneInString(text[:start]); start > 0 && isWordRune(before) {
+		if first, _ := utf8.DecodeRuneInString(text[start:]); isWordRune(first) {
+			return false
+		}
+	}
+	if after, _ := utf8.DecodeRuneInString(text[end:]); end < len(text) && isWordRune(after) {
+		if last, _ := utf8.DecodeLastRuneInString(text[:end]); isWordRune(last) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFinder(t *testing.T) {
+	text := "Go, gopher 和 GO。Let's go! 天地玄黄go"
+
+	tests := []struct {
+		name  string
+		query string
+		opts  Options
+		limit int
+		want  []Match
+		more  bool
+	}{
+		{
+			name:  "Case Insensitive",
+			query: "go",
+			want:  []Match{{0, 2}, {4, 2}, {13, 2}, {22, 2}, {30, 2}},
+		},
+		{
+			name:  "Case Sensitive",
+			query: "go",
+			opts:  Options{CaseSensitive: true},
+			want:  []Match{{4, 2}, {22, 2}, {30, 2}},
+		},
+		{
+			name:  "Whole Word",
+			query: "go",
+			opts:  Options{WholeWord: true},
+			want:  []Match{{0, 2}, {13, 2}, {22, 2}, {30, 2}},
+		},
+		{
+			name:  "Regular Expression",
+			query: `go(pher)?\b`,
+			opts:  Options{Regexp: true, CaseSensitive: true},
+			want:  []Match{{4, 6}, {22, 2}, {30, 2}},
+		},
+		{
+			name:  "Query Quoted",
+			query: "go!",
+			want:  []Match{{22, 3}},
+		},
+		{
+			name:  "CJK",
+			query: "玄黄",
+			want:  []Match{{28, 2}},
+		},
+		{
+			name:  "Empty Matches Skipped",
+			query: "x*",
+			opts:  Options{Regexp: true},
+			want:  nil,
+		},
+		{
+			name:  "Limit",
+			query: "go",
+			limit: 2,
+			want:  []Match{{0, 2}, {4, 2}},
+			more:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			finder, err := NewFinder(tt.query, tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, more := finder.FindAll(text, tt.limit)
+			if !reflect.DeepEqual(got, tt.want) || more != tt.more {
+				t.Errorf("expected %v (more %v) but got %v (more %v)", tt.want, tt.more, got, more)
+			}
+		})
+	}
+}
+
+func TestNewFinderInvalid(t *testing.T) {
+	if _, err := NewFinder("(unclosed", Options{Regexp: true}); err == nil {
+		t.Error("expected an error for an invalid expression")
+	}
+	if _, err := NewFinder("(unclosed", Options{}); err != nil {
+		t.Errorf("expected plain text to be quoted but got %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package search
+
+import (
+	"encoding/gob"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// BM25 ranking parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// titleBoost is how many times a term in the title or author counts
+const titleBoost = 10
+
+// segmentExt is the file extension of a book's stored index segment
+const segmentExt = ".seg"
+
+// Hit is a book matching a query
+type Hit struct {
+	BookID uint
+	Score  float64
+}
+
+// segment is the indexed form of one book: the frequency of each term and
+// the total number of terms
+type segment struct {
+	BookID uint
+	Length int
+	Terms  map[string]uint32
+}
+
+// Index is an inverted index from terms to the books containing them. Each
+// book's terms are also stored as a segment file in the index directory,
+// from which the index is loaded on start.
+type Index struct {
+	mu       sync.RWMutex
+	dir      string
+	postings map[string]map[uint]uint32
+	docs     map[uint]*segment
+	totalLen int
+}
+
+// Open loads the index stored in dir, creating the directory if needed
+func Open(dir string) (*Index, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create index directory: %v", err)
+	}
+
+	idx := &Index{
+		dir:      dir,
+		postings: make(map[string]map[uint]uint32),
+		docs:     make(map[uint]*segment),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index directory: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != segmentExt {
+			continue
+		}
+		seg, err := readSegment(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			// A damaged segment is dropped; the book is indexed again on sync
+			os.Remove(filepath.Join(dir, entry.Name()))
+			continue
+		}
+		idx.insert(seg)
+	}
+	return idx, nil
+}
+
+// Add indexes a book, replacing any earlier version of it. Terms in the
+// title count more than terms in the text.
+func (idx *Index) Add(bookID uint, title, text string) error {
+	seg := &segment{BookID: bookID, Terms: make(map[string]uint32)}
+	for _, term := range Tokenize(text) {
+		seg.Terms[term]++
+		seg.Length++
+	}
+	for _, term := range Tokenize(title) {
+		seg.Terms[term] += titleBoost
+		seg.Length++
+	}
+
+	if err := idx.writeSegment(seg); err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.delete(bookID)
+	idx.insert(seg)
+	return nil
+}
+
+// Remove drops a book from the index
+func (idx *Index) Remove(bookID uint) error {
+	idx.mu.Lock()
+	idx.delete(bookID)
+	idx.mu.Unlock()
+
+	if err := os.Remove(idx.segmentPath(bookID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove index segment: %v", err)
+	}
+	return nil
+}
+
+// Has reports whether a book is indexed
+func (idx *Index) Has(bookID uint) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	_, ok := idx.docs[bookID]
+	return ok
+}
+
+// BookIDs returns the indexed books
+func (idx *Index) BookIDs() []uint {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	ids := make([]uint, 0, len(idx.docs))
+	for id := range idx.docs {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Search returns the books containing every term of the query, best
+// match first, ranked by BM25
+func (idx *Index) Search(query string) []Hit {
+	terms := QueryTerms(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	// Start from the rarest term to keep the candidate set small
+	lists := make([]map[uint]uint32, 0, len(terms))
+	for _, term := range terms {
+		list, ok := idx.postings[term]
+		if !ok {
+			return nil
+		}
+		lists = append(lists, list)
+	}
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+
+	n := float64(len(idx.docs))
+	avgLen := float64(idx.totalLen) / n
+	var hits []Hit
+	for bookID := range lists[0] {
+		score := 0.0
+		for _, list := range lists {
+			tf, ok := list[bookID]
+			if !ok {
+				score = -1
+				break
+			}
+			df := float64(len(list))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			norm := 1 - bm25B + bm25B*float64(idx.docs[bookID].Length)/avgLen
+			score += idf * float64(tf) * (bm25K1 + 1) / (float64(tf) + bm25K1*norm)
+		}
+		if score >= 0 {
+			hits = append(hits, Hit{BookID: bookID, Score: score})
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].BookID < hits[j].BookID
+	})
+	return hits
+}
+
+// insert adds a segment to the in-memory index; the caller holds the lock
+func (idx *Index) insert(seg *segment) {
+	for term, tf := range seg.Terms {
+		list, ok := idx.postings[term]
+		if !ok {
+			list = make(map[uint]uint32)
+			idx.postings[term] = list
+		}
+		list[seg.BookID] = tf
+	}
+	idx.docs[seg.BookID] = seg
+	idx.totalLen += seg.Length
+}
+
+// delete removes a book from the in-memory index; the caller holds the lock
+func (idx *Index) delete(bookID uint) {
+	seg, ok := idx.docs[bookID]
+	if !ok {
+		return
+	}
+	for term := range seg.Terms {
+		list := idx.postings[term]
+		delete(list, bookID)
+		if len(list) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	delete(idx.docs, bookID)
+	idx.totalLen -= seg.Length
+}
+
+func (idx *Index) segmentPath(bookID uint) string {
+	return filepath.Join(idx.dir, strconv.FormatUint(uint64(bookID), 10)+segmentExt)
+}
+
+// writeSegment stores a segment, replacing the file atomically
+func (idx *Index) writeSegment(seg *segment) error {
+	path := idx.segmentPath(seg.BookID)
+	tmp, err := os.CreateTemp(idx.dir, strings.TrimSuffix(filepath.Base(path), segmentExt)+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create index segment: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gob.NewEncoder(tmp).Encode(seg); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write index segment: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write index segment: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save index segment: %v", err)
+	}
+	return nil
+}
+
+func readSegment(path string) (*segment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var seg segment
+	if err := gob.NewDecoder(file).Decode(&seg); err != nil {
+		return nil, err
+	}
+	if seg.Terms == nil {
+		seg.Terms = make(map[string]uint32)
+	}
+	return &seg, nil
+}
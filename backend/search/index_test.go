@@ -0,0 +1,105 @@
+package search
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIndexSearch(t *testing.T) {
+	idx, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open index: %v", err)
+	}
+
+	idx.Add(1, "千字文", "天地玄黄，宇宙洪荒。日月盈昃，辰宿列张。")
+	idx.Add(2, "The Go Programming Language", "Go is an open source programming language.")
+	idx.Add(3, "宇宙简史", "宇宙的起源与宇宙的未来。宇宙很大。")
+
+	ids := func(hits []Hit) []uint {
+		var ids []uint
+		for _, hit := range hits {
+			ids = append(ids, hit.BookID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []uint
+	}{
+		{name: "CJK Phrase", query: "宇宙", want: []uint{3, 1}},
+		{name: "CJK Single Character", query: "黄", want: []uint{1}},
+		{name: "All Terms Required", query: "宇宙 洪荒", want: []uint{1}},
+		{name: "Case Insensitive", query: "PROGRAMMING", want: []uint{2}},
+		{name: "No Match", query: "rust", want: nil},
+		{name: "Bigram Not In Text", query: "黄宇", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ids(idx.Search(tt.query))
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected books %v but got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("expected books %v but got %v", tt.want, got)
+				}
+			}
+		})
+	}
+
+	t.Run("Remove", func(t *testing.T) {
+		if err := idx.Remove(3); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := ids(idx.Search("宇宙")); len(got) != 1 || got[0] != 1 {
+			t.Errorf("expected books [1] but got %v", got)
+		}
+		if idx.Has(3) {
+			t.Error("expected book 3 to be removed")
+		}
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		idx.Add(2, "Rust in Action", "Systems programming with Rust.")
+		if got := idx.Search("go"); len(got) != 0 {
+			t.Errorf("expected no books but got %v", ids(got))
+		}
+		if got := ids(idx.Search("rust")); len(got) != 1 || got[0] != 2 {
+			t.Errorf("expected books [2] but got %v", got)
+		}
+	})
+}
+
+func TestIndexPersistence(t *testing.T) {
+	dir := t.TempDir()
+	idx, err := Open(dir)
+	if err != nil {
+		t.Fatalf("failed to open index: %v", err)
+	}
+	idx.Add(1, "千字文", "天地玄黄，宇宙洪荒。")
+	idx.Add(2, "Go", "gophers")
+	idx.Remove(2)
+
+	// A damaged segment is dropped when the index is opened
+	if err := os.WriteFile(filepath.Join(dir, "7.seg"), []byte("garbage"), 0644); err != nil {
+		t.Fatalf("failed to write segment: %v", err)
+	}
+
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen index: %v", err)
+	}
+	if ids := reopened.BookIDs(); len(ids) != 1 || ids[0] != 1 {
+		t.Errorf("expected books [1] but got %v", ids)
+	}
+	if hits := reopened.Search("洪荒"); len(hits) != 1 || hits[0].BookID != 1 {
+		t.Errorf("expected a hit on book 1 but got %v", hits)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "7.seg")); !os.IsNotExist(err) {
+		t.Error("expected the damaged segment to be removed")
+	}
+}
@@ -0,0 +1,105 @@
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Match is an occurrence of a phrase in a text, as character offsets
+type Match struct {
+	Offset int
+	Length int
+}
+
+// fold lowercases text without changing its number of characters, so
+// that character offsets in the folded text hold for the original
+func fold(s string) string {
+	return strings.Map(unicode.ToLower, s)
+}
+
+// FindPhrases returns the case-insensitive occurrences of any of the
+// phrases in text, in order of position and without overlaps. At most
+// limit matches are returned, or all of them if limit is 0.
+func FindPhrases(text string, phrases []string, limit int) []Match {
+	folded := fold(text)
+
+	type byteMatch struct{ start, end, length int }
+	var found []byteMatch
+	for _, phrase := range phrases {
+		phrase = fold(phrase)
+		if phrase == "" {
+			continue
+		}
+		length := utf8.RuneCountInString(phrase)
+		for start := 0; ; {
+			i := strings.Index(folded[start:], phrase)
+			if i < 0 {
+				break
+			}
+			i += start
+			found = append(found, byteMatch{start: i, end: i + len(phrase), length: length})
+			start = i + len(phrase)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].start < found[j].start })
+
+	// Convert byte offsets to character offsets in one pass
+	var matches []Match
+	pos, chars, lastEnd := 0, 0, 0
+	for _, m := range found {
+		if m.start < lastEnd {
+			continue
+		}
+		chars += utf8.RuneCountInString(folded[pos:m.start])
+		pos = m.start
+		matches = append(matches, Match{Offset: chars, Length: m.length})
+		lastEnd = m.end
+		if limit > 0 && len(matches) == limit {
+			break
+		}
+	}
+	return matches
+}
+
+// Snippet returns up to context characters either side of a match, with
+// the match wrapped in <mark> and the rest HTML-escaped. Line breaks are
+// shown as spaces.
+func Snippet(text []rune, m Match, context int) string {
+	start := m.Offset - context
+	if start < 0 {
+		start = 0
+	}
+	end := m.Offset + m.Length + context
+	if end > len(text) {
+		end = len(text)
+	}
+
+	flat := func(runes []rune) string {
+		return html.EscapeString(strings.Join(strings.Fields(string(runes)), " "))
+	}
+	before := flat(text[start:m.Offset])
+	if m.Offset > start && unicode.IsSpace(text[m.Offset-1]) {
+		before += " "
+	}
+	after := flat(text[m.Offset+m.Length : end])
+	if m.Offset+m.Length < end && unicode.IsSpace(text[m.Offset+m.Length]) {
+		after = " " + after
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	b.WriteString(before)
+	b.WriteString("<mark>")
+	b.WriteString(html.EscapeString(string(text[m.Offset : m.Offset+m.Length])))
+	b.WriteString("</mark>")
+	b.WriteString(after)
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
@@ -0,0 +1,90 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindPhrases(t *testing.T) {
+	text := "天地玄黄，宇宙洪荒。The Go gopher likes GO."
+
+	tests := []struct {
+		name    string
+		phrases []string
+		limit   int
+		want    []Match
+	}{
+		{
+			name:    "Case Insensitive",
+			phrases: []string{"go"},
+			want:    []Match{{Offset: 14, Length: 2}, {Offset: 17, Length: 2}, {Offset: 30, Length: 2}},
+		},
+		{
+			name:    "Several Phrases In Order",
+			phrases: []string{"gopher", "宇宙"},
+			want:    []Match{{Offset: 5, Length: 2}, {Offset: 17, Length: 6}},
+		},
+		{
+			name:    "No Overlaps",
+			phrases: []string{"go", "gopher"},
+			want:    []Match{{Offset: 14, Length: 2}, {Offset: 17, Length: 2}, {Offset: 30, Length: 2}},
+		},
+		{
+			name:    "Limit",
+			phrases: []string{"go"},
+			limit:   1,
+			want:    []Match{{Offset: 14, Length: 2}},
+		},
+		{
+			name:    "Not Found",
+			phrases: []string{"rust"},
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FindPhrases(text, tt.phrases, tt.limit); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v but got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	text := []rune("第一行\n天地玄黄，<宇宙>洪荒。\n日月盈昃")
+
+	tests := []struct {
+		name    string
+		match   Match
+		context int
+		want    string
+	}{
+		{
+			name:    "Escaped And Trimmed",
+			match:   Match{Offset: 10, Length: 2},
+			context: 3,
+			want:    "…黄，&lt;<mark>宇宙</mark>&gt;洪荒…",
+		},
+		{
+			name:    "Line Breaks",
+			match:   Match{Offset: 4, Length: 2},
+			context: 2,
+			want:    "…行 <mark>天地</mark>玄黄…",
+		},
+		{
+			name:    "Whole Text",
+			match:   Match{Offset: 0, Length: 3},
+			context: 100,
+			want:    "<mark>第一行</mark> 天地玄黄，&lt;宇宙&gt;洪荒。 日月盈昃",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Snippet(text, tt.match, tt.context); got != tt.want {
+				t.Errorf("expected %q but got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxTermLength is the longest word, in characters, that is indexed
+const maxTermLength = 64
+
+// isCJK reports whether r is written without spaces between words: Han
+// ideographs, kana and Hangul
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// isWordRune reports whether r belongs to a space-separated word
+func isWordRune(r rune) bool {
+	return (unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)) && !isCJK(r)
+}
+
+// Tokenize splits text into index terms. Words are lowercased; runs of CJK
+// characters yield every character and every pair of adjacent characters,
+// so that any CJK query can be looked up without a dictionary.
+func Tokenize(text string) []string {
+	var terms []string
+	scan(text, func(word string) {
+		terms = append(terms, word)
+	}, func(run []rune) {
+		for i := range run {
+			terms = append(terms, string(run[i]))
+			if i+1 < len(run) {
+				terms = append(terms, string(run[i:i+2]))
+			}
+		}
+	})
+	return terms
+}
+
+// QueryTerms splits a query into the terms that must all be present in a
+// matching book. A CJK run is looked up by its bigrams, or by the single
+// character if it is one character long.
+func QueryTerms(query string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	add := func(term string) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	scan(query, add, func(run []rune) {
+		if len(run) == 1 {
+			add(string(run))
+			return
+		}
+		for i := 0; i+1 < len(run); i++ {
+			add(string(run[i : i+2]))
+		}
+	})
+	return terms
+}
+
+// scan walks text, passing each lowercased word to word and each run of
+// CJK characters to cjk
+func scan(text string, word func(string), cjk func([]rune)) {
+	var current []rune
+	inCJK := false
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		if inCJK {
+			cjk(current)
+		} else if len(current) <= maxTermLength {
+			word(string(current))
+		}
+		current = current[:0]
+	}
+
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			if !inCJK {
+				flush()
+				inCJK = true
+			}
+			current = append(current, r)
+		case isWordRune(r):
+			if inCJK {
+				flush()
+				inCJK = false
+			}
+			current = append(current, unicode.ToLower(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+}
+
+// Segments splits a query at white space into the phrases that are
+// highlighted in matching text
+func Segments(query string) []string {
+	return strings.Fields(query)
+}
@@ -0,0 +1,66 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{
+			name: "Words",
+			text: "The Go Programming-Language, 2nd ed.",
+			want: []string{"the", "go", "programming", "language", "2nd", "ed"},
+		},
+		{
+			name: "CJK Run",
+			text: "天地玄",
+			want: []string{"天", "天地", "地", "地玄", "玄"},
+		},
+		{
+			name: "Mixed",
+			text: "学习Go语言",
+			want: []string{"学", "学习", "习", "go", "语", "语言", "言"},
+		},
+		{
+			name: "Kana",
+			text: "ねこ",
+			want: []string{"ね", "ねこ", "こ"},
+		},
+		{
+			name: "Empty",
+			text: " ,.!  ",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %q but got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestQueryTerms(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{query: "Go go GO", want: []string{"go"}},
+		{query: "宇宙洪荒", want: []string{"宇宙", "宙洪", "洪荒"}},
+		{query: "天 golang", want: []string{"天", "golang"}},
+		{query: "  ", want: nil},
+	}
+
+	for _, tt := range tests {
+		if got := QueryTerms(tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("QueryTerms(%q): expected %q but got %q", tt.query, tt.want, got)
+		}
+	}
+}
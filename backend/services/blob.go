@@ -0,0 +1,103 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/zven/bookpavilion/config"
+	"github.com/zven/bookpavilion/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// blobsDir is the key prefix, inside the storage, of book files stored by
+// content hash
+const blobsDir = "blobs"
+
+// blobKey returns the storage key of the book file with the given hash.
+// Keys are spread over subdirectories by the first two hex digits.
+func blobKey(hash string) string {
+	return blobsDir + "/" + hash[:2] + "/" + hash
+}
+
+// hashUpload computes the SHA-256 of an uploaded file, streaming it from
+// the start, and leaves the file positioned at the start again
+func hashUpload(src io.ReadSeeker) (string, error) {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to read uploaded file: %v", err)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, src); err != nil {
+		return "", fmt.Errorf("failed to read uploaded file: %v", err)
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to read uploaded file: %v", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checkDuplicate looks for another book with the same content. It returns
+// a DuplicateBookError naming it unless duplicates are configured to share
+// the stored file.
+func (s *bookService) checkDuplicate(hash string, self uint) error {
+	if config.GetDuplicateUploads() == config.DuplicateShare {
+		return nil
+	}
+
+	var existing models.Book
+	query := s.db.Where("sha256 = ?", hash)
+	if self != 0 {
+		query = query.Where("id <> ?", self)
+	}
+	result := query.Order("id").Limit(1).Find(&existing)
+	if result.Error != nil {
+		return fmt.Errorf("failed to look up duplicate books: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+	return &models.DuplicateBookError{BookID: existing.ID}
+}
+
+// acquireBlob adds a reference to the blob with the given hash, recording
+// the blob if it is new. The reference is taken before the file is stored,
+// so a concurrent release can't delete a file that is about to be shared.
+func (s *bookService) acquireBlob(u *unitOfWork, hash string, size int64) error {
+	// The count is qualified with the table: PostgreSQL also sees the
+	// proposed row in ON CONFLICT DO UPDATE
+	blob := models.Blob{Hash: hash, Size: size, RefCount: 1}
+	err := u.tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "hash"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": gorm.Expr("blobs.ref_count + 1")}),
+	}).Create(&blob).Error
+	if err != nil {
+		return fmt.Errorf("failed to record book file: %v", err)
+	}
+	return nil
+}
+
+// releaseBlob drops a reference to the blob with the given hash. The file
+// and its cover thumbnails are deleted with the last reference, once the
+// unit of work commits.
+func (s *bookService) releaseBlob(u *unitOfWork, hash string) error {
+	err := u.tx.Model(&models.Blob{}).Where("hash = ?", hash).
+		Update("ref_count", gorm.Expr("ref_count - 1")).Error
+	if err != nil {
+		return fmt.Errorf("failed to release book file: %v", err)
+	}
+
+	result := u.tx.Where("hash = ? AND ref_count <= 0", hash).Delete(&models.Blob{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to release book file: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil // still referenced
+	}
+
+	key := blobKey(hash)
+	u.delete(key)
+	u.delete(coverKeys(coverPathOf(key))...)
+	return nil
+}
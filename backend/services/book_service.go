@@ -4,100 +4,273 @@ import (
 	"fmt"
 	"io"
 	"mime/multipart"
-	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/zven/bookpavilion/config"
 	"github.com/zven/bookpavilion/mocks"
 	"github.com/zven/bookpavilion/models"
+	"github.com/zven/bookpavilion/search"
+	"github.com/zven/bookpavilion/storage"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // BookService defines the interface for book operations
 type BookService interface {
 	CreateBook(title, author string, file *multipart.FileHeader) (*models.Book, error)
+	CreateBookFromFile(title, author string, file *UploadedFile) (*models.Book, error)
+	ImportBooks(archive *multipart.FileHeader) (*models.ImportReport, error)
 	GetBook(id uint) (*models.Book, error)
-	ListBooks(page, pageSize int) ([]models.Book, int64, error)
+	ListBooks(query *models.BookQuery) ([]models.Book, int64, error)
+	UpdateBook(id uint, update *models.BookUpdate, file *UploadedFile) (*models.Book, error)
 	DeleteBook(id uint) error
-	GetBookContent(id uint) (string, error) // Add this line
+	ListTrash(page, pageSize int) ([]models.TrashedBook, int64, error)
+	RestoreBook(id uint) (*models.Book, error)
+	PurgeBook(id uint) error
+	PurgeTrash() (int, error)
+	GetBookContent(id uint) (*models.BookContent, error)
+	GetBookContentRange(id uint, offset int64, limit int) (*models.ContentChunk, error)
+	GetChapterPage(id uint, number, page, pageSize int) (*models.ContentChunk, error)
+	GetBookTOC(id uint) ([]models.BookChapter, error)
+	OpenBookFile(id uint) (*BookFile, error)
+	GetBookCover(id uint, size string) (*BookFile, error)
+	GetBookChapter(id uint, number int) (*models.BookChapter, error)
+	SearchBooks(q string, page, pageSize int) (*models.SearchResult, error)
+	SearchInBook(id uint, q string, opts search.Options) (*models.InBookSearchResult, error)
+	SyncSearchIndex() error
+	ReconcileStorage(opts models.ReconcileOptions) (*models.ReconcileReport, error)
 }
 
+// BookFile is a stored file of a book, or one of its cover thumbnails,
+// opened for reading. The caller must close Content.
+type BookFile struct {
+	Book    *models.Book
+	Content io.ReadSeekCloser
+	Size    int64
+	ModTime time.Time
+}
+
+// UploadedFile is a book file received from a client, as a multipart form
+// file, through a resumable upload or inside an imported archive
+type UploadedFile struct {
+	Filename string
+	Size     int64
+	Open     func() (multipart.File, error)
+
+	// DefaultTitle is used when neither the client nor the file's metadata
+	// gives a title
+	DefaultTitle string
+}
+
+// formFile wraps a file of a multipart form
+func formFile(file *multipart.FileHeader) *UploadedFile {
+	return &UploadedFile{
+		Filename: file.Filename,
+		Size:     file.Size,
+		Open: func() (multipart.File, error) {
+			// Try to get mock file first
+			if src, ok := mocks.GetMockFile(file); ok {
+				return src, nil
+			}
+			return file.Open()
+		},
+	}
+}
+
+// Content chunk sizes, in bytes for TXT books and characters otherwise
+const (
+	defaultChunkSize = 64 << 10
+	maxChunkSize     = 1 << 20
+	minChunkSize     = 16
+)
+
 // bookService implements BookService interface
 type bookService struct {
-	db *gorm.DB
+	db    *gorm.DB
+	store storage.Storage
+	index *search.Index
+	now   func() time.Time
+
+	// texts caches the text of books found by searches
+	texts textCache
+
+	// reconciling is held while stored files are reconciled
+	reconciling sync.Mutex
 }
 
-// NewBookService creates a new instance of BookService
-func NewBookService(db *gorm.DB) BookService {
+// NewBookService creates a new instance of BookService keeping book files
+// and covers in store. Full-text search is disabled if index is nil.
+func NewBookService(db *gorm.DB, store storage.Storage, index *search.Index) BookService {
 	return &bookService{
-		db: db,
+		db:    db,
+		store: store,
+		index: index,
+		now:   time.Now,
 	}
 }
 
-// CreateBook implements BookService.CreateBook
+// CreateBook implements BookService.CreateBook. A blank title or author is
+// filled in from the metadata embedded in the file.
 func (s *bookService) CreateBook(title, author string, file *multipart.FileHeader) (*models.Book, error) {
+	return s.CreateBookFromFile(title, author, formFile(file))
+}
+
+// CreateBookFromFile implements BookService.CreateBookFromFile. The file is
+// checked and stored the same way whichever way it was received.
+func (s *bookService) CreateBookFromFile(title, author string, file *UploadedFile) (*models.Book, error) {
+	src, format, err := openUpload(file)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	book := &models.Book{
+		Title:    title,
+		Author:   author,
+		Format:   format,
+		FileSize: file.Size,
+	}
+
+	// Read the embedded metadata. This is best effort: a file whose
+	// metadata can't be parsed is still accepted.
+	if meta, err := readMetadata(format, src, file.Size); err == nil {
+		meta.apply(book)
+	}
+
 	// Validate title
-	if title == "" {
+	if book.Title == "" {
+		book.Title = file.DefaultTitle
+	}
+	if book.Title == "" {
 		return nil, models.ErrTitleRequired
 	}
 
-	// Get file extension and validate format
-	ext := filepath.Ext(file.Filename)
-	if ext == "" {
-		return nil, models.ErrInvalidFormat
+	if book.SHA256, err = hashUpload(src); err != nil {
+		return nil, err
 	}
-	format := models.BookFormat(ext[1:]) // Remove the dot from extension
-	if !models.IsValidBookFormat(format) {
-		return nil, models.ErrInvalidFormat
+	if err := s.checkDuplicate(book.SHA256, 0); err != nil {
+		return nil, err
 	}
 
-	// Generate unique filename
-	filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), file.Filename)
-	filepath := filepath.Join(config.GetUploadDir(), filename)
+	// Store the file and save the book together
+	err = s.inTransaction(func(u *unitOfWork) error {
+		if err := s.acquireBlob(u, book.SHA256, file.Size); err != nil {
+			return err
+		}
+		if err := s.storeUpload(u, src, file, book); err != nil {
+			return err
+		}
+		if err := u.tx.Create(book).Error; err != nil {
+			return fmt.Errorf("failed to save book to database: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	// Save file
-	var src multipart.File
-	var err error
+	s.indexBook(book)
+	return book, nil
+}
 
-	// Try to get mock file first
-	if mockFile, ok := mocks.GetMockFile(file); ok {
-		src = mockFile
-	} else {
-		src, err = file.Open()
-		if err != nil {
-			return nil, fmt.Errorf("failed to open uploaded file: %v", err)
-		}
+// openUpload opens an uploaded file and checks it: the extension must name
+// a supported format, the size must be within the limit of that format, and
+// the content must be of that format
+func openUpload(file *UploadedFile) (multipart.File, models.BookFormat, error) {
+	format, err := checkUploadFormat(file.Filename, file.Size)
+	if err != nil {
+		return nil, "", err
 	}
-	defer src.Close()
 
-	dst, err := os.Create(filepath)
+	src, err := file.Open()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create destination file: %v", err)
+		return nil, "", fmt.Errorf("failed to open uploaded file: %v", err)
 	}
-	defer dst.Close()
 
-	if _, err = io.Copy(dst, src); err != nil {
-		os.Remove(filepath) // Clean up on error
-		return nil, fmt.Errorf("failed to save file: %v", err)
+	// The extension must agree with the content
+	if err := checkContent(src, file.Size, format); err != nil {
+		src.Close()
+		return nil, "", err
 	}
+	return src, format, nil
+}
 
-	// Create book record
-	book := &models.Book{
-		Title:    title,
-		Author:   author,
-		Format:   format,
-		FilePath: filename,
-		FileSize: file.Size,
+// checkUploadFormat returns the format named by the extension of an
+// uploaded file, checking that it is supported and that the size is within
+// its limit
+func checkUploadFormat(filename string, size int64) (models.BookFormat, error) {
+	// Get file extension and validate format
+	ext := filepath.Ext(filename)
+	if ext == "" {
+		return "", models.ErrInvalidFormat
+	}
+	format := models.BookFormat(ext[1:]) // Remove the dot from extension
+	if !models.IsValidBookFormat(format) {
+		return "", models.ErrInvalidFormat
+	}
+	if limit := config.GetMaxFileSize(string(format)); limit > 0 && size > limit {
+		return "", models.ErrFileTooLarge
 	}
+	return format, nil
+}
 
-	// Save to database
-	if err := s.db.Create(book).Error; err != nil {
-		os.Remove(filepath) // Clean up file if database save fails
-		return nil, fmt.Errorf("failed to save book to database: %v", err)
+// storeUpload saves an uploaded file in the storage under its content hash,
+// together with the thumbnails of its cover, and records their keys on the
+// book. Files already stored for a book with the same content are reused.
+// The client's file name is only kept, sanitized, as the book's original
+// filename. The files are stored when the unit of work commits.
+func (s *bookService) storeUpload(u *unitOfWork, src multipart.File, file *UploadedFile, book *models.Book) error {
+	key := blobKey(book.SHA256)
+	_, err := s.store.Stat(key)
+	if err != nil && err != storage.ErrNotFound {
+		return fmt.Errorf("failed to check stored file: %v", err)
 	}
+	stored := err == nil
 
-	return book, nil
+	// Store cover thumbnails; a book without a usable cover is still accepted
+	coverPath := coverPathOf(key)
+	if _, err := s.store.Stat(coverKey(coverPath, DefaultCoverSize)); err != nil {
+		coverPath = ""
+		if cover, ok := extractCover(book.Format, src, file.Size); ok {
+			if thumbs, err := coverThumbnails(cover); err == nil {
+				if err := putCovers(u, coverPathOf(key), thumbs); err != nil {
+					return err
+				}
+				coverPath = coverPathOf(key)
+			}
+		}
+	}
+
+	// Save file
+	if !stored {
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to read uploaded file: %v", err)
+		}
+		if err := u.put(key, src, file.Size); err != nil {
+			return fmt.Errorf("failed to save file: %v", err)
+		}
+	}
+
+	book.FilePath = key
+	book.CoverPath = coverPath
+	book.OriginalFilename = models.SanitizeFilename(file.Filename)
+	return nil
+}
+
+// releaseStoredFiles releases the stored file and cover thumbnails of a
+// book, deleting them when the unit of work commits unless another book
+// shares them. Files of books stored before files were addressed by hash
+// are deleted directly.
+func (s *bookService) releaseStoredFiles(u *unitOfWork, book *models.Book) error {
+	if book.SHA256 != "" {
+		return s.releaseBlob(u, book.SHA256)
+	}
+	u.delete(book.FilePath)
+	u.delete(coverKeys(book.CoverPath)...)
+	return nil
 }
 
 // GetBook implements BookService.GetBook
@@ -110,88 +283,342 @@ func (s *bookService) GetBook(id uint) (*models.Book, error) {
 }
 
 // ListBooks implements BookService.ListBooks
-func (s *bookService) ListBooks(page, pageSize int) ([]models.Book, int64, error) {
+func (s *bookService) ListBooks(query *models.BookQuery) ([]models.Book, int64, error) {
+	if err := query.Validate(); err != nil {
+		return nil, 0, err
+	}
+
 	var books []models.Book
 	var total int64
+	filter := bookFilter(query)
 
 	// Get total count
-	if err := s.db.Model(&models.Book{}).Count(&total).Error; err != nil {
+	if err := s.db.Model(&models.Book{}).Scopes(filter).Count(&total).Error; err != nil {
 		return nil, 0, fmt.Errorf("failed to count books: %v", err)
 	}
 
-	// Calculate offset
-	offset := (page - 1) * pageSize
-
-	// Get books with pagination
-	if err := s.db.Offset(offset).Limit(pageSize).Find(&books).Error; err != nil {
+	// Get books with pagination. The column comes from a fixed list, and
+	// the id keeps the order stable between pages.
+	desc := query.Order == "desc"
+	if err := s.db.Scopes(filter).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: query.SortColumn()}, Desc: desc}).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}, Desc: desc}).
+		Offset(query.Offset()).Limit(query.PageSize).Find(&books).Error; err != nil {
 		return nil, 0, fmt.Errorf("failed to fetch books: %v", err)
 	}
 
 	return books, total, nil
 }
 
+// bookFilter returns a scope applying the filters of a book query.
+// Values are always passed as parameters.
+func bookFilter(query *models.BookQuery) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if query.Keyword != "" {
+			pattern := "%" + escapeLike(query.Keyword) + "%"
+			like := likeOperator(db)
+			db = db.Where("title "+like+" ? ESCAPE '!' OR author "+like+" ? ESCAPE '!'", pattern, pattern)
+		}
+		if len(query.Formats) > 0 {
+			db = db.Where("format IN ?", query.Formats)
+		}
+		if query.Author != "" {
+			db = db.Where("author = ?", query.Author)
+		}
+		if query.MinSize > 0 {
+			db = db.Where("file_size >= ?", query.MinSize)
+		}
+		if query.MaxSize > 0 {
+			db = db.Where("file_size <= ?", query.MaxSize)
+		}
+		if query.CreatedFrom != nil {
+			db = db.Where("created_at >= ?", *query.CreatedFrom)
+		}
+		if query.CreatedBefore != nil {
+			db = db.Where("created_at < ?", *query.CreatedBefore)
+		}
+		return db
+	}
+}
+
+// likeOperator returns the case-insensitive LIKE operator of a database.
+// LIKE ignores case in MySQL and SQLite, but not in PostgreSQL.
+func likeOperator(db *gorm.DB) string {
+	if db.Dialector.Name() == "postgres" {
+		return "ILIKE"
+	}
+	return "LIKE"
+}
+
+// escapeLike escapes the LIKE wildcards in s, using ! as the escape character
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
 // GetBookContent retrieves the content of a book by its ID
-func (s *bookService) GetBookContent(id uint) (string, error) {
+func (s *bookService) GetBookContent(id uint) (*models.BookContent, error) {
 	var book models.Book
 	if err := s.db.First(&book, id).Error; err != nil {
-		return "", fmt.Errorf("book not found: %v", err)
-	}
-
-	// Read the content from the file based on format
-	filePath := filepath.Join(config.GetUploadDir(), book.FilePath)
-	switch book.Format {
-	case "pdf":
-		// Handle PDF content loading
-		return "PDF content loading not implemented", nil
-	case "epub":
-		// // Handle EPUB content loading
-		// epub, err := ebooklib.Open(filePath)
-		// if err != nil {
-		// 	return "", fmt.Errorf("failed to open EPUB file: %v", err)
-		// }
-		// // Extract text content from the EPUB
-		// var content string
-		// for _, item := range epub.Items {
-		// 	if item.MediaType == "application/xhtml+xml" {
-		// 		text, err := item.Content()
-		// 		if err != nil {
-		// 			return "", fmt.Errorf("failed to read EPUB content: %v", err)
-		// 		}
-		// 		content += string(text)
-		// 	}
-		// }
-		// return content, nil
-		return "EPUB content loading not implemented", nil
-	case "txt":
-		content, err := os.ReadFile(filePath)
+		return nil, fmt.Errorf("book not found: %v", err)
+	}
+
+	return s.readContent(&book)
+}
+
+// GetBookContentRange returns up to limit units of a book's content starting
+// at offset. TXT books are streamed from disk and addressed by byte
+// position; other formats are addressed by character position in their
+// extracted text.
+func (s *bookService) GetBookContentRange(id uint, offset int64, limit int) (*models.ContentChunk, error) {
+	var book models.Book
+	if err := s.db.First(&book, id).Error; err != nil {
+		return nil, fmt.Errorf("book not found: %v", err)
+	}
+
+	if offset < 0 {
+		return nil, models.ErrInvalidRange
+	}
+	limit = clampChunkSize(limit)
+
+	if book.Format == models.FormatTXT {
+		return s.readTXTRange(&book, offset, limit)
+	}
+
+	content, err := s.readContent(&book)
+	if err != nil {
+		return nil, err
+	}
+	text := []rune(content.Text())
+	total := int64(len(text))
+	if offset > total {
+		return nil, models.ErrInvalidRange
+	}
+	end := offset + int64(limit)
+	if end > total {
+		end = total
+	}
+
+	return &models.ContentChunk{
+		BookID:     book.ID,
+		Unit:       models.ChunkUnitChar,
+		Offset:     offset,
+		NextOffset: end,
+		Total:      total,
+		Text:       string(text[offset:end]),
+		EOF:        end >= total,
+	}, nil
+}
+
+// GetChapterPage returns one page of a chapter, with pages of pageSize
+// characters numbered from 1
+func (s *bookService) GetChapterPage(id uint, number, page, pageSize int) (*models.ContentChunk, error) {
+	var book models.Book
+	if err := s.db.First(&book, id).Error; err != nil {
+		return nil, fmt.Errorf("book not found: %v", err)
+	}
+
+	chapters, err := s.readChapters(&book)
+	if err != nil {
+		return nil, err
+	}
+	if number < 1 || number > len(chapters) {
+		return nil, models.ErrChapterNotFound
+	}
+	chapter := chapters[number-1]
+	last := chapters[len(chapters)-1]
+
+	pageSize = clampChunkSize(pageSize)
+	text := []rune(chapter.Text)
+	totalPages := (len(text) + pageSize - 1) / pageSize
+	if totalPages == 0 {
+		totalPages = 1
+	}
+	if page < 1 || page > totalPages {
+		return nil, models.ErrInvalidRange
+	}
+	start := (page - 1) * pageSize
+	end := start + pageSize
+	if end > len(text) {
+		end = len(text)
+	}
+
+	return &models.ContentChunk{
+		BookID:     book.ID,
+		Unit:       models.ChunkUnitChar,
+		Offset:     int64(chapter.Offset + start),
+		NextOffset: int64(chapter.Offset + end),
+		Total:      int64(last.Offset + last.Length),
+		Chapter:    number,
+		Page:       page,
+		TotalPages: totalPages,
+		Text:       string(text[start:end]),
+		EOF:        number == len(chapters) && page == totalPages,
+	}, nil
+}
+
+// clampChunkSize applies the default and bounds to a requested chunk size
+func clampChunkSize(size int) int {
+	switch {
+	case size <= 0:
+		return defaultChunkSize
+	case size < minChunkSize:
+		return minChunkSize
+	case size > maxChunkSize:
+		return maxChunkSize
+	}
+	return size
+}
+
+// GetBookTOC returns the table of contents of a book
+func (s *bookService) GetBookTOC(id uint) ([]models.BookChapter, error) {
+	var book models.Book
+	if err := s.db.First(&book, id).Error; err != nil {
+		return nil, fmt.Errorf("book not found: %v", err)
+	}
+
+	chapters, err := s.readChapters(&book)
+	if err != nil {
+		return nil, err
+	}
+
+	// The table of contents carries no chapter text
+	for i := range chapters {
+		chapters[i].Text = ""
+	}
+	return chapters, nil
+}
+
+// GetBookChapter returns a single chapter of a book, numbered from 1
+func (s *bookService) GetBookChapter(id uint, number int) (*models.BookChapter, error) {
+	var book models.Book
+	if err := s.db.First(&book, id).Error; err != nil {
+		return nil, fmt.Errorf("book not found: %v", err)
+	}
+
+	chapters, err := s.readChapters(&book)
+	if err != nil {
+		return nil, err
+	}
+	if number < 1 || number > len(chapters) {
+		return nil, models.ErrChapterNotFound
+	}
+	return &chapters[number-1], nil
+}
+
+// OpenBookFile opens the stored file of a book
+func (s *bookService) OpenBookFile(id uint) (*BookFile, error) {
+	var book models.Book
+	if err := s.db.First(&book, id).Error; err != nil {
+		return nil, fmt.Errorf("book not found: %v", err)
+	}
+
+	obj, err := s.store.Get(book.FilePath)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return nil, models.ErrFileNotFound
+		}
+		return nil, fmt.Errorf("failed to open book file: %v", err)
+	}
+
+	info := obj.Info()
+	return &BookFile{
+		Book:    &book,
+		Content: obj,
+		Size:    info.Size,
+		ModTime: info.ModTime,
+	}, nil
+}
+
+// UpdateBook changes the metadata of a book and, if file is not nil,
+// replaces its stored file with a new version. The previous file is deleted
+// once the change is saved.
+func (s *bookService) UpdateBook(id uint, update *models.BookUpdate, file *UploadedFile) (*models.Book, error) {
+	var book models.Book
+	if err := s.db.First(&book, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrBookNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch book: %v", err)
+	}
+	previous := book
+
+	if update != nil {
+		update.Apply(&book)
+	}
+
+	var src multipart.File
+	if file != nil {
+		var format models.BookFormat
+		var err error
+		src, format, err = openUpload(file)
 		if err != nil {
-			return "", fmt.Errorf("failed to read book content: %v", err)
+			return nil, err
+		}
+		defer src.Close()
+
+		book.Format = format
+		book.FileSize = file.Size
+		book.Encoding = "" // detected again on first read
+		if meta, err := readMetadata(format, src, file.Size); err == nil {
+			meta.apply(&book)
+		}
+		if book.SHA256, err = hashUpload(src); err != nil {
+			return nil, err
 		}
-		return string(content), nil
-	default:
-		return "", fmt.Errorf("unsupported book format: %s", book.Format)
 	}
+
+	if err := book.Validate(); err != nil {
+		return nil, err
+	}
+
+	if file != nil {
+		if err := s.checkDuplicate(book.SHA256, book.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	err := s.inTransaction(func(u *unitOfWork) error {
+		if file != nil {
+			if err := s.acquireBlob(u, book.SHA256, file.Size); err != nil {
+				return err
+			}
+			if err := s.storeUpload(u, src, file, &book); err != nil {
+				return err
+			}
+		}
+		if err := u.tx.Save(&book).Error; err != nil {
+			return fmt.Errorf("failed to update book: %v", err)
+		}
+		// The previous file is no longer needed once the new one is saved
+		if file != nil {
+			return s.releaseStoredFiles(u, &previous)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.indexBook(&book)
+	return &book, nil
 }
 
-// DeleteBook implements BookService.DeleteBook
+// DeleteBook implements BookService.DeleteBook. The book is moved to the
+// trash: its files are kept until it is purged, so it can be restored.
 func (s *bookService) DeleteBook(id uint) error {
 	var book models.Book
-
-	// Get book first to get the file path
 	if err := s.db.First(&book, id).Error; err != nil {
-		return fmt.Errorf("book not found: %v", err)
+		if err == gorm.ErrRecordNotFound {
+			return models.ErrBookNotFound
+		}
+		return fmt.Errorf("failed to fetch book: %v", err)
 	}
 
-	// Delete from database
+	// Soft delete
 	if err := s.db.Delete(&book).Error; err != nil {
 		return fmt.Errorf("failed to delete book from database: %v", err)
 	}
 
-	// Delete file
-	filepath := filepath.Join(config.GetUploadDir(), book.FilePath)
-	if err := os.Remove(filepath); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to delete book file: %v", err)
-	}
-
+	s.unindexBook(book.ID)
 	return nil
 }
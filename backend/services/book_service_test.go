@@ -1,14 +1,32 @@
 package services
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/zven/bookpavilion/config"
 	"github.com/zven/bookpavilion/mocks"
 	"github.com/zven/bookpavilion/models"
-	"github.com/zven/bookpavilion/tests"
+	"github.com/zven/bookpavilion/search"
+	"github.com/zven/bookpavilion/storage"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
 	"gorm.io/gorm"
 )
 
@@ -25,18 +43,53 @@ func setupTest(t *testing.T) (*bookService, sqlmock.Sqlmock, func()) {
 		t.Fatalf("Failed to create mock database: %v", err)
 	}
 
-	// Create service instance
-	service := NewBookService(db).(*bookService)
+	// Create service instance storing files in memory
+	service := NewBookService(db, storage.NewMemory(), nil).(*bookService)
 
 	// Return cleanup function
 	cleanup := func() {
-		tests.CleanupTestFiles(t)
 		mocks.ClearMockFiles()
 	}
 
 	return service, mock, cleanup
 }
 
+// putTestFile stores a test file under key
+func putTestFile(t *testing.T, store storage.Storage, key string, content []byte) {
+	if err := store.Put(key, bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatalf("Failed to store test file: %v", err)
+	}
+}
+
+// expectNewBlob sets up the expectations for storing content seen for the
+// first time: no book has the same hash, and a transaction starts that
+// records a blob for it. The book is saved in the same transaction.
+func expectNewBlob(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT.*FROM `books` WHERE sha256 = ").
+		WillReturnRows(sqlmock.NewRows(mocks.BookColumns()))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO `blobs`.*ON DUPLICATE KEY UPDATE `ref_count`=blobs.ref_count \\+ 1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+// testPDFHash is the SHA-256 of the "%PDF-1.4 test content" test file
+const testPDFHash = "73caebc6e2aa8f9a7b950993208eb7ac8c380a5d8064d055735d899e8d730ec3"
+
+// metadataPDF is a minimal PDF with a document information dictionary
+const metadataPDF = `%PDF-1.4
+1 0 obj
+<< /Type /Catalog /Pages 2 0 R /Lang (en-GB) >>
+endobj
+2 0 obj
+<< /Type /Pages /Kids [] /Count 0 >>
+endobj
+3 0 obj
+<< /Title (Metadata Title) /Author (Jane Doe) /Subject (A book about metadata) >>
+endobj
+trailer
+<< /Root 1 0 R /Info 3 0 R >>
+%%EOF`
+
 func TestCreateBook(t *testing.T) {
 	service, mock, cleanup := setupTest(t)
 	defer cleanup()
@@ -48,24 +101,36 @@ func TestCreateBook(t *testing.T) {
 		filename    string
 		content     []byte
 		mockSetup   func(sqlmock.Sqlmock)
+		wantTitle   string
+		wantAuthor  string
 		expectError bool
 		errorType   error
+		mismatch    string
 	}{
 		{
 			name:     "Valid PDF Book",
 			title:    "Test Book",
 			author:   "Test Author",
 			filename: "test.pdf",
-			content:  []byte("test content"),
+			content:  []byte("%PDF-1.4 test content"),
 			mockSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
+				expectNewBlob(mock)
 				mock.ExpectExec("INSERT INTO `books`").
 					WithArgs(
 						"Test Book",      // title
 						"Test Author",    // author
 						"pdf",            // format
 						sqlmock.AnyArg(), // file_path
-						int64(12),        // file_size
+						int64(21),        // file_size
+						"",               // encoding
+						"",               // language
+						"",               // publisher
+						"",               // isbn
+						"",               // description
+						"",               // cover_path
+						"test.pdf",       // original_filename
+						testPDFHash,      // sha256
+						"",               // file_issue
 						sqlmock.AnyArg(), // created_at
 						sqlmock.AnyArg(), // updated_at
 						nil,              // deleted_at
@@ -75,12 +140,47 @@ func TestCreateBook(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			name:     "Title From Metadata",
+			title:    "",
+			author:   "",
+			filename: "metadata.pdf",
+			content:  []byte(metadataPDF),
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectNewBlob(mock)
+				mock.ExpectExec("INSERT INTO `books`").
+					WithArgs(
+						"Metadata Title",        // title
+						"Jane Doe",              // author
+						"pdf",                   // format
+						sqlmock.AnyArg(),        // file_path
+						int64(len(metadataPDF)), // file_size
+						"",                      // encoding
+						"en-GB",                 // language
+						"",                      // publisher
+						"",                      // isbn
+						"A book about metadata", // description
+						"",                      // cover_path
+						"metadata.pdf",          // original_filename
+						sqlmock.AnyArg(),        // sha256
+						"",                      // file_issue
+						sqlmock.AnyArg(),        // created_at
+						sqlmock.AnyArg(),        // updated_at
+						nil,                     // deleted_at
+					).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			wantTitle:   "Metadata Title",
+			wantAuthor:  "Jane Doe",
+			expectError: false,
+		},
 		{
 			name:     "Empty Title",
 			title:    "",
 			author:   "Test Author",
 			filename: "test.pdf",
-			content:  []byte("test content"),
+			content:  []byte("%PDF-1.4 test content"),
 			mockSetup: func(mock sqlmock.Sqlmock) {
 				// No database expectations needed as validation fails
 			},
@@ -99,6 +199,30 @@ func TestCreateBook(t *testing.T) {
 			expectError: true,
 			errorType:   models.ErrInvalidFormat,
 		},
+		{
+			name:     "Renamed Executable",
+			title:    "Test Book",
+			author:   "Test Author",
+			filename: "x.pdf",
+			content:  append([]byte("MZ\x90\x00\x03\x00\x00\x00"), make([]byte, 120)...),
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				// No database expectations needed as the content is rejected
+			},
+			expectError: true,
+			mismatch:    "application/vnd.microsoft.portable-executable",
+		},
+		{
+			name:     "Text Named EPUB",
+			title:    "Test Book",
+			author:   "Test Author",
+			filename: "test.epub",
+			content:  []byte("just some text"),
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				// No database expectations needed as the content is rejected
+			},
+			expectError: true,
+			mismatch:    "text/plain",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -113,8 +237,13 @@ func TestCreateBook(t *testing.T) {
 			book, err := service.CreateBook(tc.title, tc.author, file)
 
 			if tc.expectError {
+				var mismatch *models.FormatMismatchError
 				if err == nil {
 					t.Error("expected error but got none")
+				} else if tc.mismatch != "" {
+					if !errors.As(err, &mismatch) || mismatch.Detected != tc.mismatch {
+						t.Errorf("expected content detected as %s but got %v", tc.mismatch, err)
+					}
 				} else if err != tc.errorType {
 					t.Errorf("expected error %v but got %v", tc.errorType, err)
 				}
@@ -127,11 +256,15 @@ func TestCreateBook(t *testing.T) {
 			}
 
 			// Verify book data
-			if book.Title != tc.title {
-				t.Errorf("expected title %s but got %s", tc.title, book.Title)
+			wantTitle, wantAuthor := tc.title, tc.author
+			if tc.wantTitle != "" {
+				wantTitle, wantAuthor = tc.wantTitle, tc.wantAuthor
 			}
-			if book.Author != tc.author {
-				t.Errorf("expected author %s but got %s", tc.author, book.Author)
+			if book.Title != wantTitle {
+				t.Errorf("expected title %s but got %s", wantTitle, book.Title)
+			}
+			if book.Author != wantAuthor {
+				t.Errorf("expected author %s but got %s", wantAuthor, book.Author)
 			}
 		})
 	}
@@ -189,7 +322,7 @@ func TestListBooks(t *testing.T) {
 		mock.ExpectQuery("SELECT.*FROM.*books.*").
 			WillReturnRows(rows)
 
-		books, total, err := service.ListBooks(1, 10)
+		books, total, err := service.ListBooks(&models.BookQuery{Page: 1, PageSize: 10})
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 			return
@@ -203,6 +336,66 @@ func TestListBooks(t *testing.T) {
 			t.Errorf("expected total 15 but got %d", total)
 		}
 	})
+
+	t.Run("Search Filter and Sort", func(t *testing.T) {
+		from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		query := &models.BookQuery{
+			Page:        2,
+			PageSize:    5,
+			Keyword:     "100%_done",
+			Formats:     []models.BookFormat{models.FormatPDF, models.FormatEPUB},
+			MinSize:     1024,
+			CreatedFrom: &from,
+			SortBy:      "title",
+			Order:       "ASC",
+		}
+
+		where := "WHERE \\(title LIKE \\? ESCAPE '!' OR author LIKE \\? ESCAPE '!'\\) AND format IN \\(\\?,\\?\\) " +
+			"AND file_size >= \\? AND created_at >= \\? AND .*deleted_at.* IS NULL"
+		mock.ExpectQuery("SELECT count.*FROM.*books.* "+where).
+			WithArgs("%100!%!_done%", "%100!%!_done%", "pdf", "epub", int64(1024), from).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(6))
+		mock.ExpectQuery("SELECT.*FROM.*books.* "+where+" ORDER BY `title`,`id` LIMIT 5 OFFSET 5").
+			WithArgs("%100!%!_done%", "%100!%!_done%", "pdf", "epub", int64(1024), from).
+			WillReturnRows(sqlmock.NewRows(mocks.BookColumns()).
+				AddRow(6, "100%_done", "Test Author", "pdf", "test.pdf", 2048,
+					time.Now(), time.Now(), nil))
+
+		books, total, err := service.ListBooks(query)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(books) != 1 || total != 6 {
+			t.Errorf("expected 1 of 6 books but got %d of %d", len(books), total)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %v", err)
+		}
+	})
+
+	t.Run("Invalid Query", func(t *testing.T) {
+		from := time.Now()
+		before := from.Add(-time.Hour)
+		testCases := []struct {
+			name  string
+			query models.BookQuery
+			err   error
+		}{
+			{name: "Sort Field", query: models.BookQuery{SortBy: "file_path; DROP TABLE books"}, err: models.ErrInvalidSortField},
+			{name: "Sort Order", query: models.BookQuery{Order: "sideways"}, err: models.ErrInvalidSortOrder},
+			{name: "Format", query: models.BookQuery{Formats: []models.BookFormat{"doc"}}, err: models.ErrInvalidFormat},
+			{name: "Size Range", query: models.BookQuery{MinSize: 2048, MaxSize: 1024}, err: models.ErrInvalidSizeRange},
+			{name: "Date Range", query: models.BookQuery{CreatedFrom: &from, CreatedBefore: &before}, err: models.ErrInvalidDateRange},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				if _, _, err := service.ListBooks(&tc.query); err != tc.err {
+					t.Errorf("expected error %v but got %v", tc.err, err)
+				}
+			})
+		}
+	})
 }
 
 func TestDeleteBook(t *testing.T) {
@@ -226,13 +419,18 @@ func TestDeleteBook(t *testing.T) {
 
 		// Create a test file
 		content := []byte("test content")
-		tests.CreateTestFile(t, "test.pdf", content)
+		putTestFile(t, service.store, "test.pdf", content)
 
 		// Delete the book
 		err := service.DeleteBook(1)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 		}
+
+		// The file is kept while the book is in the trash
+		if _, err := service.store.Stat("test.pdf"); err != nil {
+			t.Errorf("expected the file to be kept but got %v", err)
+		}
 	})
 
 	t.Run("Delete Non-existent Book", func(t *testing.T) {
@@ -241,8 +439,1200 @@ func TestDeleteBook(t *testing.T) {
 			WillReturnError(gorm.ErrRecordNotFound)
 
 		err := service.DeleteBook(9999)
-		if err == nil {
-			t.Error("expected error for non-existent book but got none")
+		if err != models.ErrBookNotFound {
+			t.Errorf("expected error %v but got %v", models.ErrBookNotFound, err)
+		}
+	})
+}
+
+func TestGetBookContent(t *testing.T) {
+	service, mock, cleanup := setupTest(t)
+	defer cleanup()
+
+	t.Run("Detect TXT Encoding", func(t *testing.T) {
+		text := "第一章 开端\n天地玄黄，宇宙洪荒。日月盈昃，辰宿列张。"
+		content, err := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(text))
+		if err != nil {
+			t.Fatalf("Failed to encode test content: %v", err)
+		}
+		putTestFile(t, service.store, "gbk.txt", content)
+
+		mock.ExpectQuery("SELECT.*FROM.*books.*WHERE.*id.*=.*").
+			WithArgs(uint(1)).
+			WillReturnRows(sqlmock.NewRows(mocks.BookColumns()).
+				AddRow(1, "Test Book", "Test Author", "txt", "gbk.txt", len(content),
+					time.Now(), time.Now(), nil))
+
+		// The detected encoding is saved on the book
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE.*books.*SET.*encoding.*").
+			WithArgs("gb18030", sqlmock.AnyArg(), uint(1)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		result, err := service.GetBookContent(1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.Content != text {
+			t.Errorf("expected UTF-8 content %q but got %q", text, result.Content)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %v", err)
+		}
+	})
+
+	t.Run("Use Saved Encoding", func(t *testing.T) {
+		content := []byte("Chapter 1\nIt was a dark and stormy night.")
+		putTestFile(t, service.store, "saved.txt", content)
+
+		mock.ExpectQuery("SELECT.*FROM.*books.*WHERE.*id.*=.*").
+			WithArgs(uint(2)).
+			WillReturnRows(sqlmock.NewRows(append(mocks.BookColumns(), "encoding")).
+				AddRow(2, "Test Book", "Test Author", "txt", "saved.txt", len(content),
+					time.Now(), time.Now(), nil, "utf-8"))
+
+		result, err := service.GetBookContent(2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.Content != string(content) {
+			t.Errorf("unexpected content %q", result.Content)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %v", err)
+		}
+	})
+}
+
+func TestGetBookChapter(t *testing.T) {
+	service, mock, cleanup := setupTest(t)
+	defer cleanup()
+
+	content := []byte("序言。\n第一章 开端\n天地玄黄。\n第二章 风起\n宇宙洪荒。")
+	putTestFile(t, service.store, "novel.txt", content)
+
+	expectBook := func() {
+		mock.ExpectQuery("SELECT.*FROM.*books.*WHERE.*id.*=.*").
+			WithArgs(uint(1)).
+			WillReturnRows(sqlmock.NewRows(append(mocks.BookColumns(), "encoding")).
+				AddRow(1, "Test Book", "Test Author", "txt", "novel.txt", len(content),
+					time.Now(), time.Now(), nil, "utf-8"))
+	}
+
+	t.Run("Table of Contents", func(t *testing.T) {
+		expectBook()
+		toc, err := service.GetBookTOC(1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		expected := []models.BookChapter{
+			{Number: 1, Title: "Test Book", Offset: 0, Length: 4},
+			{Number: 2, Title: "第一章 开端", Offset: 4, Length: 13},
+			{Number: 3, Title: "第二章 风起", Offset: 17, Length: 12},
+		}
+		if len(toc) != len(expected) {
+			t.Fatalf("expected %d chapters but got %d", len(expected), len(toc))
+		}
+		for i := range expected {
+			if toc[i] != expected[i] {
+				t.Errorf("expected chapter %+v but got %+v", expected[i], toc[i])
+			}
+		}
+	})
+
+	t.Run("Get Chapter", func(t *testing.T) {
+		expectBook()
+		chapter, err := service.GetBookChapter(1, 3)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if chapter.Text != "第二章 风起\n宇宙洪荒。" {
+			t.Errorf("unexpected chapter text %q", chapter.Text)
+		}
+	})
+
+	t.Run("Chapter Out of Range", func(t *testing.T) {
+		expectBook()
+		if _, err := service.GetBookChapter(1, 4); err != models.ErrChapterNotFound {
+			t.Errorf("expected error %v but got %v", models.ErrChapterNotFound, err)
+		}
+	})
+}
+
+func TestGetBookContentRange(t *testing.T) {
+	service, mock, cleanup := setupTest(t)
+	defer cleanup()
+
+	text := "第一章 开端\n天地玄黄，宇宙洪荒。日月盈昃，辰宿列张。\n第二章 风起\n寒来暑往，秋收冬藏。"
+	content, err := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		t.Fatalf("Failed to encode test content: %v", err)
+	}
+	putTestFile(t, service.store, "range.txt", content)
+
+	expectBook := func() {
+		mock.ExpectQuery("SELECT.*FROM.*books.*WHERE.*id.*=.*").
+			WithArgs(uint(1)).
+			WillReturnRows(sqlmock.NewRows(append(mocks.BookColumns(), "encoding")).
+				AddRow(1, "Test Book", "Test Author", "txt", "range.txt", len(content),
+					time.Now(), time.Now(), nil, "gb18030"))
+	}
+
+	t.Run("Read In Chunks", func(t *testing.T) {
+		var result string
+		var offset int64 = 1 // inside the first character
+		for {
+			expectBook()
+			chunk, err := service.GetBookContentRange(1, offset, 17)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if chunk.Unit != models.ChunkUnitByte || chunk.Total != int64(len(content)) {
+				t.Fatalf("unexpected chunk position %+v", chunk)
+			}
+			result += chunk.Text
+			offset = chunk.NextOffset
+			if chunk.EOF {
+				break
+			}
+		}
+
+		// The first character is skipped since reading started inside it
+		if expected := string([]rune(text)[1:]); result != expected {
+			t.Errorf("expected %q but got %q", expected, result)
+		}
+	})
+
+	t.Run("Offset Past End", func(t *testing.T) {
+		expectBook()
+		if _, err := service.GetBookContentRange(1, int64(len(content))+1, 0); err != models.ErrInvalidRange {
+			t.Errorf("expected error %v but got %v", models.ErrInvalidRange, err)
+		}
+	})
+
+	t.Run("Chapter Pages", func(t *testing.T) {
+		expectBook()
+		page, err := service.GetChapterPage(1, 1, 2, 16)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if page.Text != "。日月盈昃，辰宿列张。\n" || page.Offset != 16 || page.TotalPages != 2 {
+			t.Errorf("unexpected page %+v", page)
+		}
+		if page.EOF {
+			t.Error("expected more content after the first chapter")
+		}
+
+		expectBook()
+		if _, err := service.GetChapterPage(1, 1, 3, 16); err != models.ErrInvalidRange {
+			t.Errorf("expected error %v but got %v", models.ErrInvalidRange, err)
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestOpenBookFile(t *testing.T) {
+	service, mock, cleanup := setupTest(t)
+	defer cleanup()
+
+	content := []byte("%PDF-1.4 test content")
+	putTestFile(t, service.store, "stored.pdf", content)
+
+	expectBook := func(filePath string) {
+		mock.ExpectQuery("SELECT.*FROM.*books.*WHERE.*id.*=.*").
+			WithArgs(uint(1)).
+			WillReturnRows(sqlmock.NewRows(mocks.BookColumns()).
+				AddRow(1, "Test Book", "Test Author", "pdf", filePath, len(content),
+					time.Now(), time.Now(), nil))
+	}
+
+	t.Run("Open File", func(t *testing.T) {
+		expectBook("stored.pdf")
+		file, err := service.OpenBookFile(1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer file.Content.Close()
+
+		if file.Size != int64(len(content)) {
+			t.Errorf("expected size %d but got %d", len(content), file.Size)
+		}
+		data, err := io.ReadAll(file.Content)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(data) != string(content) {
+			t.Errorf("unexpected file content %q", data)
+		}
+	})
+
+	t.Run("Missing File", func(t *testing.T) {
+		expectBook("missing.pdf")
+		if _, err := service.OpenBookFile(1); err != models.ErrFileNotFound {
+			t.Errorf("expected error %v but got %v", models.ErrFileNotFound, err)
+		}
+	})
+}
+
+func TestGetBookCover(t *testing.T) {
+	service, mock, cleanup := setupTest(t)
+	defer cleanup()
+
+	// A comic book archive whose first page is a 600x900 image
+	var page bytes.Buffer
+	if err := png.Encode(&page, image.NewRGBA(image.Rect(0, 0, 600, 900))); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	w, _ := zw.Create("001.png")
+	w.Write(page.Bytes())
+	zw.Close()
+	putTestFile(t, service.store, "comic.cbz", archive.Bytes())
+
+	t.Run("Generate On First Request", func(t *testing.T) {
+		mock.ExpectQuery("SELECT.*FROM.*books.*WHERE.*id.*=.*").
+			WithArgs(uint(1)).
+			WillReturnRows(sqlmock.NewRows(mocks.BookColumns()).
+				AddRow(1, "Comic", "Artist", "cbz", "comic.cbz", archive.Len(),
+					time.Now(), time.Now(), nil))
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE.*books.*SET.*cover_path.*").
+			WithArgs("covers/comic", sqlmock.AnyArg(), uint(1)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		cover, err := service.GetBookCover(1, "small")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer cover.Content.Close()
+		thumb, format, err := image.DecodeConfig(cover.Content)
+		if err != nil {
+			t.Fatalf("Failed to decode thumbnail: %v", err)
+		}
+		if format != "jpeg" || thumb.Width != 120 || thumb.Height != 180 {
+			t.Errorf("unexpected thumbnail %s %dx%d", format, thumb.Width, thumb.Height)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %v", err)
+		}
+	})
+
+	t.Run("No Cover", func(t *testing.T) {
+		putTestFile(t, service.store, "plain.txt", []byte("no cover here"))
+		mock.ExpectQuery("SELECT.*FROM.*books.*WHERE.*id.*=.*").
+			WithArgs(uint(2)).
+			WillReturnRows(sqlmock.NewRows(mocks.BookColumns()).
+				AddRow(2, "Plain", "Author", "txt", "plain.txt", 13,
+					time.Now(), time.Now(), nil))
+
+		if _, err := service.GetBookCover(2, ""); err != models.ErrCoverNotFound {
+			t.Errorf("expected error %v but got %v", models.ErrCoverNotFound, err)
+		}
+	})
+
+	t.Run("Cover Too Large", func(t *testing.T) {
+		// A 1x1 GIF claiming a 20000x20000 screen
+		var page bytes.Buffer
+		if err := gif.Encode(&page, image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.White}), nil); err != nil {
+			t.Fatalf("Failed to encode test image: %v", err)
+		}
+		data := page.Bytes()
+		binary.LittleEndian.PutUint16(data[6:], 20000)
+		binary.LittleEndian.PutUint16(data[8:], 20000)
+
+		var archive bytes.Buffer
+		zw := zip.NewWriter(&archive)
+		w, _ := zw.Create("001.gif")
+		w.Write(data)
+		zw.Close()
+		putTestFile(t, service.store, "huge.cbz", archive.Bytes())
+
+		mock.ExpectQuery("SELECT.*FROM.*books.*WHERE.*id.*=.*").
+			WithArgs(uint(3)).
+			WillReturnRows(sqlmock.NewRows(mocks.BookColumns()).
+				AddRow(3, "Huge", "Artist", "cbz", "huge.cbz", archive.Len(),
+					time.Now(), time.Now(), nil))
+
+		if _, err := service.GetBookCover(3, ""); err != models.ErrCoverNotFound {
+			t.Errorf("expected error %v but got %v", models.ErrCoverNotFound, err)
+		}
+	})
+
+	t.Run("Invalid Size", func(t *testing.T) {
+		if _, err := service.GetBookCover(1, "huge"); err != models.ErrInvalidCoverSize {
+			t.Errorf("expected error %v but got %v", models.ErrInvalidCoverSize, err)
+		}
+	})
+}
+
+func TestUpdateBook(t *testing.T) {
+	service, mock, cleanup := setupTest(t)
+	defer cleanup()
+
+	expectBook := func() {
+		mock.ExpectQuery("SELECT.*FROM.*books.*WHERE.*id.*=.*").
+			WithArgs(uint(1)).
+			WillReturnRows(sqlmock.NewRows(mocks.BookColumns()).
+				AddRow(1, "Tset Book", "Test Author", "pdf", "old.pdf", 12,
+					time.Now(), time.Now(), nil))
+	}
+	stringPtr := func(s string) *string { return &s }
+
+	t.Run("Update Metadata", func(t *testing.T) {
+		expectBook()
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE.*books.*SET.*title.*").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		book, err := service.UpdateBook(1, &models.BookUpdate{Title: stringPtr(" Test Book ")}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if book.Title != "Test Book" || book.Author != "Test Author" {
+			t.Errorf("unexpected book %+v", book)
+		}
+	})
+
+	t.Run("Empty Title", func(t *testing.T) {
+		expectBook()
+		_, err := service.UpdateBook(1, &models.BookUpdate{Title: stringPtr("")}, nil)
+		if err != models.ErrTitleRequired {
+			t.Errorf("expected error %v but got %v", models.ErrTitleRequired, err)
+		}
+	})
+
+	t.Run("Too Long", func(t *testing.T) {
+		// Lengths are counted in characters, as the columns are sized
+		testCases := []struct {
+			name   string
+			update models.BookUpdate
+			err    error
+		}{
+			{"Title", models.BookUpdate{Title: stringPtr(strings.Repeat("书", 201))}, models.ErrTitleTooLong},
+			{"Author", models.BookUpdate{Author: stringPtr(strings.Repeat("a", 101))}, models.ErrAuthorTooLong},
+			{"Language", models.BookUpdate{Language: stringPtr(strings.Repeat("a", 21))}, models.ErrLanguageTooLong},
+			{"Publisher", models.BookUpdate{Publisher: stringPtr(strings.Repeat("社", 201))}, models.ErrPublisherTooLong},
+			{"ISBN", models.BookUpdate{ISBN: stringPtr(strings.Repeat("9", 21))}, models.ErrISBNTooLong},
+		}
+		for _, tc := range testCases {
+			expectBook()
+			if _, err := service.UpdateBook(1, &tc.update, nil); err != tc.err {
+				t.Errorf("%s: expected error %v but got %v", tc.name, tc.err, err)
+			}
+		}
+	})
+
+	t.Run("Replace File", func(t *testing.T) {
+		putTestFile(t, service.store, "old.pdf", []byte("test content"))
+		content := []byte("Chapter 1\nA new edition.")
+		file := mocks.NewMockFileHeader("new.txt", int64(len(content)), content)
+
+		expectBook()
+		expectNewBlob(mock)
+		mock.ExpectExec("UPDATE.*books.*SET.*").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		book, err := service.UpdateBook(1, nil, formFile(file))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if book.Format != models.FormatTXT || book.FileSize != int64(len(content)) || book.FilePath == "old.pdf" {
+			t.Errorf("file was not replaced: %+v", book)
+		}
+		if _, err := service.store.Stat(book.FilePath); err != nil {
+			t.Errorf("new file was not stored: %v", err)
+		}
+		if _, err := service.store.Stat("old.pdf"); err != storage.ErrNotFound {
+			t.Errorf("expected the previous file to be deleted")
+		}
+	})
+
+	t.Run("Non-existent Book", func(t *testing.T) {
+		mock.ExpectQuery("SELECT.*FROM.*books.*WHERE.*id.*=.*").
+			WithArgs(uint(9999)).
+			WillReturnError(gorm.ErrRecordNotFound)
+
+		if _, err := service.UpdateBook(9999, &models.BookUpdate{}, nil); err != models.ErrBookNotFound {
+			t.Errorf("expected error %v but got %v", models.ErrBookNotFound, err)
 		}
 	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSearchBooks(t *testing.T) {
+	service, mock, cleanup := setupTest(t)
+	defer cleanup()
+
+	index, err := search.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open index: %v", err)
+	}
+	service.index = index
+
+	novel := []byte("序言。\n第一章 开端\n天地玄黄，宇宙洪荒。\n第二章 风起\n寒来暑往，宇宙无穷。")
+	putTestFile(t, service.store, "novel.txt", novel)
+	service.indexBook(&models.Book{ID: 1, Title: "千字文", Author: "周兴嗣", Format: models.FormatTXT,
+		FilePath: "novel.txt", Encoding: "utf-8"})
+	putTestFile(t, service.store, "go.txt", []byte("Go is a programming language."))
+	service.indexBook(&models.Book{ID: 2, Title: "Learning Go", Format: models.FormatTXT,
+		FilePath: "go.txt", Encoding: "utf-8"})
+
+	t.Run("Matches With Chapters", func(t *testing.T) {
+		mock.ExpectQuery("SELECT.*FROM.*books.*WHERE.*id IN").
+			WithArgs(uint(1)).
+			WillReturnRows(sqlmock.NewRows(append(mocks.BookColumns(), "encoding")).
+				AddRow(1, "千字文", "周兴嗣", "txt", "novel.txt", len(novel),
+					time.Now(), time.Now(), nil, "utf-8"))
+
+		store := &countingStorage{Storage: service.store}
+		service.store = store
+		defer func() { service.store = store.Storage }()
+
+		result, err := service.SearchBooks("宇宙", 1, 10)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.Total != 1 || len(result.Books) != 1 {
+			t.Fatalf("expected 1 book but got total %d, %d books", result.Total, len(result.Books))
+		}
+		// The text of a hit is extracted once for its matches and chapters
+		if store.gets != 1 {
+			t.Errorf("expected the book file read once but it was read %d times", store.gets)
+		}
+
+		expected := []models.SearchMatch{
+			{Chapter: 2, ChapterTitle: "第一章 开端", Offset: 16, Length: 2,
+				Snippet: "序言。 第一章 开端 天地玄黄，<mark>宇宙</mark>洪荒。 第二章 风起 寒来暑往，宇宙无穷。"},
+			{Chapter: 3, ChapterTitle: "第二章 风起", Offset: 34, Length: 2,
+				Snippet: "序言。 第一章 开端 天地玄黄，宇宙洪荒。 第二章 风起 寒来暑往，<mark>宇宙</mark>无穷。"},
+		}
+		matches := result.Books[0].Matches
+		if len(matches) != len(expected) {
+			t.Fatalf("expected %d matches but got %+v", len(expected), matches)
+		}
+		for i := range expected {
+			if matches[i] != expected[i] {
+				t.Errorf("expected match %+v but got %+v", expected[i], matches[i])
+			}
+		}
+	})
+
+	t.Run("Text Cached", func(t *testing.T) {
+		updated := time.Now()
+		expectNovel := func(updatedAt time.Time) {
+			mock.ExpectQuery("SELECT.*FROM.*books.*WHERE.*id IN").
+				WithArgs(uint(1)).
+				WillReturnRows(sqlmock.NewRows(append(mocks.BookColumns(), "encoding")).
+					AddRow(1, "千字文", "周兴嗣", "txt", "novel.txt", len(novel),
+						time.Now(), updatedAt, nil, "utf-8"))
+		}
+
+		store := &countingStorage{Storage: service.store}
+		service.store = store
+		defer func() { service.store = store.Storage }()
+
+		for _, q := range []string{"宇宙", "天地"} {
+			expectNovel(updated)
+			result, err := service.SearchBooks(q, 1, 10)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(result.Books) != 1 || len(result.Books[0].Matches) == 0 {
+				t.Fatalf("expected matches for %q but got %+v", q, result.Books)
+			}
+		}
+		if store.gets != 1 {
+			t.Errorf("expected the book file read once but it was read %d times", store.gets)
+		}
+
+		// An updated book is read again
+		expectNovel(updated.Add(time.Second))
+		if _, err := service.SearchBooks("宇宙", 1, 10); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if store.gets != 2 {
+			t.Errorf("expected the updated book file read again but it was read %d times", store.gets)
+		}
+	})
+
+	t.Run("Title Match", func(t *testing.T) {
+		mock.ExpectQuery("SELECT.*FROM.*books.*WHERE.*id IN").
+			WithArgs(uint(2)).
+			WillReturnRows(sqlmock.NewRows(append(mocks.BookColumns(), "encoding")).
+				AddRow(2, "Learning Go", "", "txt", "go.txt", 29,
+					time.Now(), time.Now(), nil, "utf-8"))
+
+		result, err := service.SearchBooks("learning", 1, 10)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(result.Books) != 1 || result.Books[0].Book.ID != 2 {
+			t.Fatalf("expected book 2 but got %+v", result.Books)
+		}
+	})
+
+	t.Run("Empty Query", func(t *testing.T) {
+		if _, err := service.SearchBooks("  ", 1, 10); err != models.ErrEmptyQuery {
+			t.Errorf("expected error %v but got %v", models.ErrEmptyQuery, err)
+		}
+	})
+
+	t.Run("Deleted Book Unindexed", func(t *testing.T) {
+		mock.ExpectQuery("SELECT.*FROM.*books.*WHERE.*id.*=.*").
+			WithArgs(uint(2)).
+			WillReturnRows(sqlmock.NewRows(mocks.BookColumns()).
+				AddRow(2, "Learning Go", "", "txt", "go.txt", 29, time.Now(), time.Now(), nil))
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE.*books.*SET.*deleted_at").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		if err := service.DeleteBook(2); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		result, err := service.SearchBooks("go", 1, 10)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.Total != 0 {
+			t.Errorf("expected no results but got %+v", result.Books)
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSearchInBook(t *testing.T) {
+	service, mock, cleanup := setupTest(t)
+	defer cleanup()
+
+	content := []byte("Prologue: the Go gopher.\nChapter 1 Going\nGo went to 天地 and back.\nChapter 2 Gone\nNo more go here, GO!")
+	putTestFile(t, service.store, "gopher.txt", content)
+
+	expectBook := func() {
+		mock.ExpectQuery("SELECT.*FROM.*books.*WHERE.*id.*=.*").
+			WithArgs(uint(1)).
+			WillReturnRows(sqlmock.NewRows(append(mocks.BookColumns(), "encoding")).
+				AddRow(1, "Gophers", "Test Author", "txt", "gopher.txt", len(content),
+					time.Now(), time.Now(), nil, "utf-8"))
+	}
+
+	offsets := func(matches []models.SearchMatch) []int {
+		var offsets []int
+		for _, m := range matches {
+			offsets = append(offsets, m.Offset)
+		}
+		return offsets
+	}
+
+	testCases := []struct {
+		name     string
+		query    string
+		opts     search.Options
+		expected []int
+	}{
+		{name: "Case Insensitive", query: "go", expected: []int{14, 17, 35, 41, 75, 88, 97}},
+		{name: "Case Sensitive", query: "Go", opts: search.Options{CaseSensitive: true}, expected: []int{14, 35, 41, 75}},
+		{name: "Whole Word", query: "go", opts: search.Options{WholeWord: true}, expected: []int{14, 41, 88, 97}},
+		{name: "Regular Expression", query: `Go(ne|ing)`, opts: search.Options{Regexp: true, CaseSensitive: true}, expected: []int{35, 75}},
+		{name: "CJK", query: "天地", expected: []int{52}},
+		{name: "No Match", query: "rust", expected: nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expectBook()
+			result, err := service.SearchInBook(1, tc.query, tc.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := offsets(result.Matches)
+			if len(got) != len(tc.expected) || result.Count != len(tc.expected) {
+				t.Fatalf("expected offsets %v but got %v", tc.expected, got)
+			}
+			for i := range got {
+				if got[i] != tc.expected[i] {
+					t.Fatalf("expected offsets %v but got %v", tc.expected, got)
+				}
+			}
+		})
+	}
+
+	t.Run("Match Location", func(t *testing.T) {
+		expectBook()
+		store := &countingStorage{Storage: service.store}
+		service.store = store
+		defer func() { service.store = store.Storage }()
+
+		result, err := service.SearchInBook(1, "天地", search.Options{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if store.gets != 1 {
+			t.Errorf("expected the book file read once but it was read %d times", store.gets)
+		}
+		expected := models.SearchMatch{
+			Chapter:      2,
+			ChapterTitle: "Chapter 1 Going",
+			Offset:       52,
+			Length:       2,
+			Snippet:      "…e Go gopher. Chapter 1 Going Go went to <mark>天地</mark> and back. Chapter 2 Gone No more go her…",
+		}
+		if len(result.Matches) != 1 || result.Matches[0] != expected {
+			t.Errorf("expected match %+v but got %+v", expected, result.Matches)
+		}
+	})
+
+	t.Run("Invalid Pattern", func(t *testing.T) {
+		if _, err := service.SearchInBook(1, "(go", search.Options{Regexp: true}); err != models.ErrInvalidPattern {
+			t.Errorf("expected error %v but got %v", models.ErrInvalidPattern, err)
+		}
+	})
+
+	t.Run("Empty Query", func(t *testing.T) {
+		if _, err := service.SearchInBook(1, "", search.Options{}); err != models.ErrEmptyQuery {
+			t.Errorf("expected error %v but got %v", models.ErrEmptyQuery, err)
+		}
+	})
+
+	t.Run("Book Not Found", func(t *testing.T) {
+		mock.ExpectQuery("SELECT.*FROM.*books.*WHERE.*id.*=.*").
+			WithArgs(uint(2)).
+			WillReturnError(gorm.ErrRecordNotFound)
+		if _, err := service.SearchInBook(2, "go", search.Options{}); err != models.ErrBookNotFound {
+			t.Errorf("expected error %v but got %v", models.ErrBookNotFound, err)
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCheckContent(t *testing.T) {
+	buildZip := func(first string, content string) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		w, _ := zw.CreateHeader(&zip.FileHeader{Name: first, Method: zip.Store})
+		w.Write([]byte(content))
+		zw.Close()
+		return buf.Bytes()
+	}
+	mobi := make([]byte, 128)
+	copy(mobi[60:], "BOOKMOBI")
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().Bytes([]byte("第一章 天地玄黄，宇宙洪荒。"))
+	utf16, _ := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder().Bytes([]byte("Chapter 1\nIt was a dark and stormy night."))
+
+	testCases := []struct {
+		name     string
+		format   models.BookFormat
+		content  []byte
+		mismatch string
+	}{
+		{name: "PDF", format: models.FormatPDF, content: []byte("%PDF-1.7\n")},
+		{name: "EPUB", format: models.FormatEPUB, content: buildZip("mimetype", "application/epub+zip")},
+		{name: "MOBI", format: models.FormatMOBI, content: mobi},
+		{name: "AZW3", format: models.FormatAZW3, content: mobi},
+		{name: "CBZ", format: models.FormatCBZ, content: buildZip("001.png", "png")},
+		{name: "UTF-8 Text", format: models.FormatTXT, content: []byte("天地玄黄")},
+		{name: "GBK Text", format: models.FormatTXT, content: gbk},
+		{name: "UTF-16 Text Without BOM", format: models.FormatTXT, content: utf16},
+		{name: "Zip As EPUB", format: models.FormatEPUB, content: buildZip("001.png", "png"), mismatch: "application/zip"},
+		{name: "EPUB As CBZ", format: models.FormatCBZ, content: buildZip("mimetype", "application/epub+zip"), mismatch: "application/epub+zip"},
+		{name: "PDF As Text", format: models.FormatTXT, content: []byte("%PDF-1.7\n\x00\x01\x02"), mismatch: "application/pdf"},
+		{name: "Text As MOBI", format: models.FormatMOBI, content: []byte("BOOKMOBI"), mismatch: "text/plain"},
+		{name: "GBK With Control Bytes", format: models.FormatTXT, content: append(append([]byte{}, gbk...), 0x01, 0x02), mismatch: "application/octet-stream"},
+		{name: "Binary As Text", format: models.FormatTXT, content: []byte{0x7F, 'E', 'L', 'F', 2, 1, 1, 0, 0, 0}, mismatch: "application/x-elf"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkContent(bytes.NewReader(tc.content), int64(len(tc.content)), tc.format)
+			if tc.mismatch == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			var mismatch *models.FormatMismatchError
+			if !errors.As(err, &mismatch) {
+				t.Fatalf("expected a format mismatch but got %v", err)
+			}
+			if mismatch.Declared != tc.format || mismatch.Detected != tc.mismatch {
+				t.Errorf("expected %s detected as %s but got %+v", tc.format, tc.mismatch, mismatch)
+			}
+		})
+	}
+}
+
+func TestUploadSizeLimit(t *testing.T) {
+	service, mock, cleanup := setupTest(t)
+	defer cleanup()
+	defer func() {
+		config.SetMaxFileSize("", 0)
+		config.SetMaxFileSize("pdf", 0)
+	}()
+
+	pdf := []byte("%PDF-1.4 test content")
+	text := []byte("Chapter 1\nA short story.")
+	config.SetMaxFileSize("", 1<<20)
+	config.SetMaxFileSize("pdf", 16)
+
+	t.Run("Format Limit", func(t *testing.T) {
+		file := mocks.NewMockFileHeader("test.pdf", int64(len(pdf)), pdf)
+		if _, err := service.CreateBook("Test Book", "", file); err != models.ErrFileTooLarge {
+			t.Errorf("expected error %v but got %v", models.ErrFileTooLarge, err)
+		}
+	})
+
+	t.Run("Global Limit", func(t *testing.T) {
+		config.SetMaxFileSize("", 16)
+		defer config.SetMaxFileSize("", 1<<20)
+		file := mocks.NewMockFileHeader("story.txt", int64(len(text)), text)
+		if _, err := service.CreateBook("Test Book", "", file); err != models.ErrFileTooLarge {
+			t.Errorf("expected error %v but got %v", models.ErrFileTooLarge, err)
+		}
+	})
+
+	t.Run("Replacement File", func(t *testing.T) {
+		mock.ExpectQuery("SELECT.*FROM.*books.*WHERE.*id.*=.*").
+			WithArgs(uint(1)).
+			WillReturnRows(sqlmock.NewRows(mocks.BookColumns()).
+				AddRow(1, "Test Book", "", "txt", "old.txt", 10, time.Now(), time.Now(), nil))
+
+		file := mocks.NewMockFileHeader("new.pdf", int64(len(pdf)), pdf)
+		if _, err := service.UpdateBook(1, nil, formFile(file)); err != models.ErrFileTooLarge {
+			t.Errorf("expected error %v but got %v", models.ErrFileTooLarge, err)
+		}
+	})
+
+	t.Run("Within Limit", func(t *testing.T) {
+		expectNewBlob(mock)
+		mock.ExpectExec("INSERT INTO `books`").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		file := mocks.NewMockFileHeader("story.txt", int64(len(text)), text)
+		if _, err := service.CreateBook("Test Book", "", file); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStoreUploadFilenames(t *testing.T) {
+	service, _, cleanup := setupTest(t)
+	defer cleanup()
+
+	content := []byte("%PDF-1.4 test content")
+	long := strings.Repeat("长", 100) + ".pdf"
+
+	testCases := []struct {
+		name     string
+		filename string
+		original string
+	}{
+		{name: "Plain", filename: "book.pdf", original: "book.pdf"},
+		{name: "Parent Directories", filename: "../../../etc/evil.pdf", original: "evil.pdf"},
+		{name: "Absolute Path", filename: "/tmp/evil.pdf", original: "evil.pdf"},
+		{name: "Windows Path", filename: `C:\Windows\..\evil.pdf`, original: "evil.pdf"},
+		{name: "NUL Byte", filename: "evil\x00/../x.pdf", original: "x.pdf"},
+		{name: "Control Characters", filename: "ev\nil\x7f.pdf", original: "evil.pdf"},
+		{name: "Bidi Override", filename: "invoice\u202Efdp.exe\u202C.pdf", original: "invoicefdp.exe.pdf"},
+		{name: "Format Characters", filename: "\uFEFFbo\u200Bok\u2066.pdf", original: "book.pdf"},
+		{name: "Only Dots", filename: "../..pdf", original: "..pdf"},
+		{name: "Overlong", filename: long, original: strings.Repeat("长", 83) + ".pdf"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			file := mocks.NewMockFileHeader(tc.filename, int64(len(content)), content)
+			src, format, err := openUpload(formFile(file))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer src.Close()
+
+			book := &models.Book{Format: format, SHA256: testPDFHash}
+			u := &unitOfWork{store: service.store}
+			if err := service.storeUpload(u, src, formFile(file), book); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := u.promote(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if book.FilePath != blobKey(testPDFHash) {
+				t.Errorf("unexpected stored name %q", book.FilePath)
+			}
+			obj, err := service.store.Get(book.FilePath)
+			if err != nil {
+				t.Fatalf("stored file not readable: %v", err)
+			}
+			defer obj.Close()
+			if data, err := io.ReadAll(obj); err != nil || !bytes.Equal(data, content) {
+				t.Errorf("stored file different: %q (%v)", data, err)
+			}
+			if book.OriginalFilename != tc.original {
+				t.Errorf("expected original filename %q but got %q", tc.original, book.OriginalFilename)
+			}
+			if len(book.OriginalFilename) > models.MaxFilenameLength {
+				t.Errorf("original filename is %d bytes long", len(book.OriginalFilename))
+			}
+		})
+	}
+}
+
+func TestDuplicateUploads(t *testing.T) {
+	service, mock, cleanup := setupTest(t)
+	defer cleanup()
+	defer config.SetDuplicateUploads("")
+
+	content := []byte("%PDF-1.4 test content")
+	columns := append(mocks.BookColumns(), "sha256")
+	key := blobKey(testPDFHash)
+
+	t.Run("Reject", func(t *testing.T) {
+		config.SetDuplicateUploads(config.DuplicateReject)
+		mock.ExpectQuery("SELECT.*FROM `books` WHERE sha256 = ").
+			WithArgs(testPDFHash).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(7, "Test Book", "", "pdf", key, len(content), time.Now(), time.Now(), nil, testPDFHash))
+
+		file := mocks.NewMockFileHeader("again.pdf", int64(len(content)), content)
+		_, err := service.CreateBook("Test Book", "", file)
+		var duplicate *models.DuplicateBookError
+		if !errors.As(err, &duplicate) || duplicate.BookID != 7 {
+			t.Errorf("expected a duplicate of book 7 but got %v", err)
+		}
+		if _, err := service.store.Stat(key); err != storage.ErrNotFound {
+			t.Errorf("expected nothing to be stored but got %v", err)
+		}
+	})
+
+	t.Run("Share", func(t *testing.T) {
+		config.SetDuplicateUploads(config.DuplicateShare)
+		putTestFile(t, service.store, key, content)
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO `blobs`").
+			WithArgs(testPDFHash, int64(len(content)), int64(1), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec("INSERT INTO `books`").WillReturnResult(sqlmock.NewResult(8, 1))
+		mock.ExpectCommit()
+
+		file := mocks.NewMockFileHeader("copy.pdf", int64(len(content)), content)
+		book, err := service.CreateBook("Copy", "", file)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if book.FilePath != key || book.SHA256 != testPDFHash || book.OriginalFilename != "copy.pdf" {
+			t.Errorf("expected the book to share the stored file but got %+v", book)
+		}
+		if objects, err := service.store.List(blobsDir + "/"); err != nil || len(objects) != 1 {
+			t.Errorf("expected one stored file but got %+v (%v)", objects, err)
+		}
+	})
+
+	t.Run("Release Shared File", func(t *testing.T) {
+		expectPurge := func(id int, lastReference bool) {
+			mock.ExpectQuery("SELECT.*FROM `books` WHERE deleted_at IS NOT NULL AND `books`.`id` = ").
+				WithArgs(uint(id)).
+				WillReturnRows(sqlmock.NewRows(columns).
+					AddRow(id, "Test Book", "", "pdf", key, len(content), time.Now(), time.Now(), time.Now(), testPDFHash))
+			mock.ExpectBegin()
+			mock.ExpectExec("DELETE FROM `books` WHERE deleted_at IS NOT NULL").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("UPDATE `blobs` SET `ref_count`=ref_count - 1").WillReturnResult(sqlmock.NewResult(0, 1))
+			deleted := int64(0)
+			if lastReference {
+				deleted = 1
+			}
+			mock.ExpectExec("DELETE FROM `blobs` WHERE hash = \\? AND ref_count <= 0").
+				WithArgs(testPDFHash).
+				WillReturnResult(sqlmock.NewResult(0, deleted))
+			mock.ExpectCommit()
+		}
+
+		expectPurge(7, false)
+		if err := service.PurgeBook(7); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := service.store.Stat(key); err != nil {
+			t.Errorf("expected the shared file to be kept but got %v", err)
+		}
+
+		expectPurge(8, true)
+		if err := service.PurgeBook(8); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := service.store.Stat(key); err != storage.ErrNotFound {
+			t.Errorf("expected the file to be deleted with its last reference but got %v", err)
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestImportBooks(t *testing.T) {
+	service, mock, cleanup := setupTest(t)
+	defer cleanup()
+	defer config.SetMaxImportSize(0)
+
+	pdf := "%PDF-1.4 test content"
+	// Books are titled after their file when the file has no metadata
+	expectCreate := func(id int64, title string) {
+		args := []driver.Value{title}
+		for i := 0; i < 16; i++ {
+			args = append(args, sqlmock.AnyArg())
+		}
+		expectNewBlob(mock)
+		mock.ExpectExec("INSERT INTO `books`").WithArgs(args...).WillReturnResult(sqlmock.NewResult(id, 1))
+		mock.ExpectCommit()
+	}
+
+	t.Run("ZIP", func(t *testing.T) {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		zw.Create("books/")
+		for _, entry := range []struct{ name, content string }{
+			{"books/First Book.pdf", pdf},
+			{"books/copy.pdf", pdf},
+			{"books/notes.doc", "notes"},
+			{"books/evil.pdf", "MZ\x90\x00\x03\x00\x00\x00"},
+			{"__MACOSX/books/._First Book.pdf", "resource fork"},
+			{".DS_Store", "finder"},
+		} {
+			w, _ := zw.Create(entry.name)
+			w.Write([]byte(entry.content))
+		}
+		zw.Close()
+
+		expectCreate(10, "First Book")
+		mock.ExpectQuery("SELECT.*FROM `books` WHERE sha256 = ").
+			WithArgs(testPDFHash).
+			WillReturnRows(sqlmock.NewRows(mocks.BookColumns()).
+				AddRow(10, "First Book", "", "pdf", blobKey(testPDFHash), len(pdf), time.Now(), time.Now(), nil))
+
+		file := mocks.NewMockFileHeader("books.zip", int64(buf.Len()), buf.Bytes())
+		report, err := service.ImportBooks(file)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		expected := []models.ImportResult{
+			{Filename: "books/First Book.pdf", Status: models.ImportCreated, BookID: 10},
+			{Filename: "books/copy.pdf", Status: models.ImportDuplicate, BookID: 10, Error: "identical file already uploaded as book 10"},
+			{Filename: "books/notes.doc", Status: models.ImportRejected, Error: models.ErrInvalidFormat.Error()},
+			{Filename: "books/evil.pdf", Status: models.ImportRejected, Error: "file content does not match format pdf (detected application/vnd.microsoft.portable-executable)"},
+		}
+		if len(report.Results) != len(expected) {
+			t.Fatalf("expected %d results but got %+v", len(expected), report.Results)
+		}
+		for i, result := range report.Results {
+			if result != expected[i] {
+				t.Errorf("expected %+v but got %+v", expected[i], result)
+			}
+		}
+		if report.Created != 1 || report.Duplicates != 1 || report.Rejected != 2 || report.Error != "" {
+			t.Errorf("unexpected report totals %+v", report)
+		}
+	})
+
+	t.Run("Truncated tar.gz", func(t *testing.T) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		var second strings.Builder
+		for i := 0; i < 2000; i++ {
+			fmt.Fprintf(&second, "line %d of the second book\n", i*i)
+		}
+		for _, entry := range []struct{ name, content string }{
+			{"a.txt", "The first book"},
+			{"b.txt", second.String()},
+		} {
+			tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.content)), Typeflag: tar.TypeReg})
+			tw.Write([]byte(entry.content))
+		}
+		tw.Close()
+		gz.Close()
+		// Cut the archive off inside the second book
+		data := buf.Bytes()[:buf.Len()-60]
+
+		expectCreate(11, "a")
+		file := mocks.NewMockFileHeader("books.tar.gz", int64(len(data)), data)
+		report, err := service.ImportBooks(file)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if report.Created != 1 || report.Results[0].BookID != 11 {
+			t.Errorf("expected the first book to be created but got %+v", report)
+		}
+		if report.Rejected != 1 || report.Results[1].Error != models.ErrCorruptEntry.Error() {
+			t.Errorf("expected the cut off book to be rejected but got %+v", report)
+		}
+		if report.Error == "" {
+			t.Errorf("expected the truncated archive to be reported but got %+v", report)
+		}
+	})
+
+	t.Run("Invalid Archive", func(t *testing.T) {
+		file := mocks.NewMockFileHeader("books.zip", int64(len(pdf)), []byte(pdf))
+		if _, err := service.ImportBooks(file); err != models.ErrInvalidArchive {
+			t.Errorf("expected error %v but got %v", models.ErrInvalidArchive, err)
+		}
+	})
+
+	t.Run("Too Large", func(t *testing.T) {
+		config.SetMaxImportSize(16)
+		file := mocks.NewMockFileHeader("books.zip", 32, make([]byte, 32))
+		if _, err := service.ImportBooks(file); err != models.ErrArchiveTooLarge {
+			t.Errorf("expected error %v but got %v", models.ErrArchiveTooLarge, err)
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	service, mock, cleanup := setupTest(t)
+	defer cleanup()
+	defer config.SetTrashRetention(0)
+
+	columns := append(mocks.BookColumns(), "sha256")
+	deletedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	content := []byte("%PDF-1.4 test content")
+	key := blobKey(testPDFHash)
+
+	t.Run("List", func(t *testing.T) {
+		config.SetTrashRetention(7 * 24 * time.Hour)
+		mock.ExpectQuery("SELECT count\\(\\*\\) FROM `books` WHERE deleted_at IS NOT NULL").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery("SELECT \\* FROM `books` WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC,id DESC LIMIT 10").
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(3, "Trashed", "", "pdf", key, len(content), time.Now(), time.Now(), deletedAt, testPDFHash))
+
+		books, total, err := service.ListTrash(1, 10)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if total != 1 || len(books) != 1 || books[0].ID != 3 || !books[0].DeletedAt.Equal(deletedAt) {
+			t.Fatalf("unexpected trash %+v (total %d)", books, total)
+		}
+		if books[0].PurgeAt == nil || !books[0].PurgeAt.Equal(deletedAt.Add(7*24*time.Hour)) {
+			t.Errorf("expected the book to be purged a week after deletion but got %v", books[0].PurgeAt)
+		}
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		mock.ExpectQuery("SELECT.*FROM `books` WHERE deleted_at IS NOT NULL AND `books`.`id` = ").
+			WithArgs(uint(3)).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(3, "Trashed", "", "pdf", key, len(content), time.Now(), time.Now(), deletedAt, testPDFHash))
+		mock.ExpectQuery("SELECT.*FROM `books` WHERE sha256 = .* AND id <> ").
+			WithArgs(testPDFHash, uint(3)).
+			WillReturnRows(sqlmock.NewRows(columns))
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE `books` SET `deleted_at`=\\?,`updated_at`=\\? WHERE deleted_at IS NOT NULL AND `id` = \\?").
+			WithArgs(nil, sqlmock.AnyArg(), uint(3)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		book, err := service.RestoreBook(3)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if book.ID != 3 || book.DeletedAt.Valid {
+			t.Errorf("unexpected restored book %+v", book)
+		}
+	})
+
+	t.Run("Restore Duplicate", func(t *testing.T) {
+		mock.ExpectQuery("SELECT.*FROM `books` WHERE deleted_at IS NOT NULL AND `books`.`id` = ").
+			WithArgs(uint(3)).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(3, "Trashed", "", "pdf", key, len(content), time.Now(), time.Now(), deletedAt, testPDFHash))
+		mock.ExpectQuery("SELECT.*FROM `books` WHERE sha256 = .* AND id <> ").
+			WithArgs(testPDFHash, uint(3)).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(4, "Uploaded Again", "", "pdf", key, len(content), time.Now(), time.Now(), nil, testPDFHash))
+
+		var duplicate *models.DuplicateBookError
+		if _, err := service.RestoreBook(3); !errors.As(err, &duplicate) || duplicate.BookID != 4 {
+			t.Errorf("expected a duplicate of book 4 but got %v", err)
+		}
+	})
+
+	t.Run("Not In Trash", func(t *testing.T) {
+		mock.ExpectQuery("SELECT.*FROM `books` WHERE deleted_at IS NOT NULL AND `books`.`id` = ").
+			WithArgs(uint(5)).
+			WillReturnRows(sqlmock.NewRows(columns))
+		if _, err := service.RestoreBook(5); err != models.ErrBookNotInTrash {
+			t.Errorf("expected error %v but got %v", models.ErrBookNotInTrash, err)
+		}
+		mock.ExpectQuery("SELECT.*FROM `books` WHERE deleted_at IS NOT NULL AND `books`.`id` = ").
+			WithArgs(uint(5)).
+			WillReturnRows(sqlmock.NewRows(columns))
+		if err := service.PurgeBook(5); err != models.ErrBookNotInTrash {
+			t.Errorf("expected error %v but got %v", models.ErrBookNotInTrash, err)
+		}
+	})
+
+	t.Run("Purge Expired", func(t *testing.T) {
+		config.SetTrashRetention(0)
+		if purged, err := service.PurgeTrash(); err != nil || purged != 0 {
+			t.Errorf("expected nothing purged without a retention period but got %d (%v)", purged, err)
+		}
+
+		config.SetTrashRetention(24 * time.Hour)
+		putTestFile(t, service.store, "old.pdf", content)
+		putTestFile(t, service.store, "restored.pdf", content)
+		mock.ExpectQuery("SELECT.*FROM `books` WHERE deleted_at IS NOT NULL AND deleted_at <= ").
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(6, "Old", "", "pdf", "old.pdf", len(content), time.Now(), time.Now(), deletedAt, "").
+				AddRow(7, "Restored", "", "pdf", "restored.pdf", len(content), time.Now(), time.Now(), deletedAt, ""))
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM `books` WHERE deleted_at IS NOT NULL AND `books`.`id` = ").
+			WithArgs(uint(6)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		// Restored while the purge was running
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM `books` WHERE deleted_at IS NOT NULL AND `books`.`id` = ").
+			WithArgs(uint(7)).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		if purged, err := service.PurgeTrash(); err != nil || purged != 1 {
+			t.Errorf("expected 1 book purged but got %d (%v)", purged, err)
+		}
+		if _, err := service.store.Stat("old.pdf"); err != storage.ErrNotFound {
+			t.Errorf("expected the purged book's file to be deleted but got %v", err)
+		}
+		if _, err := service.store.Stat("restored.pdf"); err != nil {
+			t.Errorf("expected the restored book's file to be kept but got %v", err)
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
 }
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zven/bookpavilion/models"
+	"github.com/zven/bookpavilion/parsers"
+	"github.com/zven/bookpavilion/storage"
+)
+
+// epubSectionSeparator joins the spine documents of an EPUB into one text
+const epubSectionSeparator = "\n\n"
+
+// maxUntitledHeading is the length, in characters, of titles taken from
+// the first line of untitled chapters
+const maxUntitledHeading = 40
+
+// readContent extracts the content of a book based on its format
+func (s *bookService) readContent(book *models.Book) (*models.BookContent, error) {
+	content := &models.BookContent{
+		BookID: book.ID,
+		Format: book.Format,
+	}
+
+	obj, err := s.openBookObject(book)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	switch book.Format {
+	case models.FormatPDF:
+		pages, err := readPDFPages(obj)
+		if err != nil {
+			return nil, err
+		}
+		content.Pages = pages
+	case models.FormatEPUB:
+		text, err := readEPUBContent(obj)
+		if err != nil {
+			return nil, err
+		}
+		content.Content = text
+	case models.FormatMOBI, models.FormatAZW3:
+		text, err := readMOBIContent(obj)
+		if err != nil {
+			return nil, err
+		}
+		content.Content = text
+	case models.FormatTXT:
+		text, err := s.readTXTContent(book, obj)
+		if err != nil {
+			return nil, err
+		}
+		content.Content = text
+	default:
+		return nil, fmt.Errorf("unsupported book format: %s", book.Format)
+	}
+
+	return content, nil
+}
+
+// openBookObject opens the stored file of a book for reading
+func (s *bookService) openBookObject(book *models.Book) (storage.Object, error) {
+	obj, err := s.store.Get(book.FilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open book file: %v", err)
+	}
+	return obj, nil
+}
+
+// readChapters splits a book into chapters. EPUB chapters are its spine
+// documents; other formats are split at the headings found in their text.
+// Offsets refer to the text returned by readContent.
+func (s *bookService) readChapters(book *models.Book) ([]models.BookChapter, error) {
+	_, chapters, err := s.readContentChapters(book)
+	return chapters, err
+}
+
+// readContentChapters extracts the content of a book and splits it into
+// chapters as readChapters does, reading and parsing the file only once
+func (s *bookService) readContentChapters(book *models.Book) (*models.BookContent, []models.BookChapter, error) {
+	var content *models.BookContent
+	var chapters []models.BookChapter
+
+	if book.Format == models.FormatEPUB {
+		obj, err := s.openBookObject(book)
+		if err != nil {
+			return nil, nil, err
+		}
+		sections, err := readEPUBSections(obj)
+		obj.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		content = &models.BookContent{
+			BookID:  book.ID,
+			Format:  book.Format,
+			Content: joinEPUBSections(sections),
+		}
+		offset := 0
+		for _, section := range sections {
+			chapters = append(chapters, models.BookChapter{
+				Title:  section.Title,
+				Offset: offset,
+				Text:   section.Text,
+			})
+			offset += utf8.RuneCountInString(section.Text) + utf8.RuneCountInString(epubSectionSeparator)
+		}
+	} else {
+		var err error
+		if content, err = s.readContent(book); err != nil {
+			return nil, nil, err
+		}
+		for _, chapter := range parsers.SplitChapters(content.Text()) {
+			chapters = append(chapters, models.BookChapter{
+				Title:  chapter.Title,
+				Offset: chapter.Offset,
+				Text:   chapter.Text,
+			})
+		}
+	}
+
+	for i := range chapters {
+		chapters[i].Number = i + 1
+		chapters[i].Length = utf8.RuneCountInString(chapters[i].Text)
+		if chapters[i].Title == "" {
+			chapters[i].Title = untitledChapterTitle(book, chapters[i].Text, i)
+		}
+	}
+	return content, chapters, nil
+}
+
+// untitledChapterTitle names a chapter without a heading: the text before
+// the first heading is named after the book, others after their first line
+func untitledChapterTitle(book *models.Book, text string, index int) string {
+	if index == 0 {
+		return book.Title
+	}
+	line := strings.TrimSpace(text)
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = strings.TrimSpace(line[:i])
+	}
+	if utf8.RuneCountInString(line) > maxUntitledHeading {
+		line = string([]rune(line)[:maxUntitledHeading]) + "…"
+	}
+	return line
+}
+
+// readEPUBSections extracts the text of every spine document of an EPUB file
+func readEPUBSections(obj storage.Object) ([]parsers.EPUBSection, error) {
+	epub, err := parsers.ParseEPUB(obj, obj.Info().Size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open EPUB file: %v", err)
+	}
+
+	sections, err := epub.Sections()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read EPUB content: %v", err)
+	}
+	return sections, nil
+}
+
+// readEPUBContent extracts the text of an EPUB file, one spine document
+// after another, separated by blank lines
+func readEPUBContent(obj storage.Object) (string, error) {
+	sections, err := readEPUBSections(obj)
+	if err != nil {
+		return "", err
+	}
+	return joinEPUBSections(sections), nil
+}
+
+// joinEPUBSections joins the text of the spine documents of an EPUB
+func joinEPUBSections(sections []parsers.EPUBSection) string {
+	texts := make([]string, 0, len(sections))
+	for _, section := range sections {
+		texts = append(texts, section.Text)
+	}
+	return strings.Join(texts, epubSectionSeparator)
+}
+
+// readTXTContent reads a plain text book and converts it to UTF-8. The
+// encoding is detected on first read and saved on the book.
+func (s *bookService) readTXTContent(book *models.Book, obj storage.Object) (string, error) {
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return "", fmt.Errorf("failed to read book content: %v", err)
+	}
+
+	if book.Encoding == "" {
+		if err := s.detectEncoding(book, data); err != nil {
+			return "", err
+		}
+	}
+
+	return parsers.DecodeText(data, book.Encoding)
+}
+
+// readTXTRange decodes up to limit bytes of a plain text book starting at a
+// byte offset, reading only that part of the file. The offset is moved
+// forward to a character boundary and the chunk ends on one.
+func (s *bookService) readTXTRange(book *models.Book, offset int64, limit int) (*models.ContentChunk, error) {
+	file, err := s.openBookObject(book)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	size := file.Info().Size
+	if offset > size {
+		return nil, models.ErrInvalidRange
+	}
+
+	if book.Encoding == "" {
+		sample := make([]byte, parsers.EncodingSampleSize)
+		n, err := file.ReadAt(sample, 0)
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read book content: %v", err)
+		}
+		if err := s.detectEncoding(book, sample[:n]); err != nil {
+			return nil, err
+		}
+	}
+
+	start, err := parsers.AlignTextOffset(file, offset, book.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read book content: %v", err)
+	}
+
+	buf := make([]byte, limit)
+	n, err := file.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read book content: %v", err)
+	}
+	text, consumed, err := parsers.DecodeTextChunk(buf[:n], book.Encoding, start+int64(n) >= size)
+	if err != nil {
+		return nil, err
+	}
+
+	next := start + int64(consumed)
+	return &models.ContentChunk{
+		BookID:     book.ID,
+		Unit:       models.ChunkUnitByte,
+		Offset:     start,
+		NextOffset: next,
+		Total:      size,
+		Text:       text,
+		EOF:        next >= size,
+	}, nil
+}
+
+// detectEncoding detects the encoding of a plain text book from the start
+// of its text and saves it on the book
+func (s *bookService) detectEncoding(book *models.Book, sample []byte) error {
+	book.Encoding = parsers.DetectEncoding(sample)
+	if err := s.db.Model(book).Update("encoding", book.Encoding).Error; err != nil {
+		return fmt.Errorf("failed to save book encoding: %v", err)
+	}
+	return nil
+}
+
+// readPDFPages extracts the text of every page of a PDF file
+func readPDFPages(obj storage.Object) ([]models.BookPage, error) {
+	pdf, err := parsers.ParsePDF(obj, obj.Info().Size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PDF file: %v", err)
+	}
+
+	pdfPages, err := pdf.Pages()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PDF content: %v", err)
+	}
+
+	pages := make([]models.BookPage, 0, len(pdfPages))
+	for _, page := range pdfPages {
+		pages = append(pages, models.BookPage{
+			Number: page.Number,
+			Text:   page.Text,
+		})
+	}
+	return pages, nil
+}
+
+// readMOBIContent extracts the text of a MOBI or AZW3 file
+func readMOBIContent(obj storage.Object) (string, error) {
+	mobi, err := parsers.ParseMOBI(obj, obj.Info().Size)
+	if err != nil {
+		return "", fmt.Errorf("failed to open MOBI file: %v", err)
+	}
+
+	text, err := mobi.Text()
+	if err != nil {
+		return "", fmt.Errorf("failed to read MOBI content: %v", err)
+	}
+	return text, nil
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif" // register GIF decoder
+	"image/jpeg"
+	_ "image/png" // register PNG decoder
+	"io"
+	"path"
+	"strings"
+
+	"github.com/zven/bookpavilion/models"
+	"github.com/zven/bookpavilion/parsers"
+	"github.com/zven/bookpavilion/storage"
+	_ "golang.org/x/image/bmp" // register BMP decoder
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // register WebP decoder
+)
+
+// coversDir is the key prefix, inside the storage, of cover thumbnails
+const coversDir = "covers"
+
+// Cover thumbnail sizes by name, as widths in pixels
+var coverSizes = map[string]int{
+	"small":  120,
+	"medium": 240,
+	"large":  480,
+}
+
+// DefaultCoverSize is the thumbnail served when no size is requested
+const DefaultCoverSize = "medium"
+
+// coverQuality is the JPEG quality of stored thumbnails
+const coverQuality = 85
+
+// extractCover reads the cover image embedded in a book file: the EPUB
+// cover image, the MOBI/AZW3 EXTH cover record, or the first page of a CBZ
+func extractCover(format models.BookFormat, r io.ReaderAt, size int64) ([]byte, bool) {
+	switch format {
+	case models.FormatEPUB:
+		epub, err := parsers.ParseEPUB(r, size)
+		if err != nil {
+			return nil, false
+		}
+		return epub.Cover()
+	case models.FormatMOBI, models.FormatAZW3:
+		mobi, err := parsers.ParseMOBI(r, size)
+		if err != nil {
+			return nil, false
+		}
+		return mobi.Cover()
+	case models.FormatCBZ:
+		cbz, err := parsers.ParseCBZ(r, size)
+		if err != nil {
+			return nil, false
+		}
+		return cbz.Cover()
+	}
+	return nil, false
+}
+
+// maxCoverPixels caps the size of cover images that are decoded, as a
+// small file can claim dimensions that take gigabytes to decode
+const maxCoverPixels = 40_000_000
+
+// coverThumbnails decodes a cover image and encodes a JPEG thumbnail for
+// every size
+func coverThumbnails(data []byte) (map[string][]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode cover image: %v", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 ||
+		int64(config.Width)*int64(config.Height) > maxCoverPixels {
+		return nil, fmt.Errorf("cover image too large: %dx%d", config.Width, config.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode cover image: %v", err)
+	}
+
+	thumbs := make(map[string][]byte, len(coverSizes))
+	for size, width := range coverSizes {
+		thumb, err := encodeThumbnail(src, width)
+		if err != nil {
+			return nil, err
+		}
+		thumbs[size] = thumb
+	}
+	return thumbs, nil
+}
+
+// putCovers stores cover thumbnails in a unit of work. The thumbnail of a
+// size is stored under the key "<cover path>_<size>.jpg".
+func putCovers(u *unitOfWork, coverPath string, thumbs map[string][]byte) error {
+	for size, thumb := range thumbs {
+		if err := u.put(coverKey(coverPath, size), bytes.NewReader(thumb), int64(len(thumb))); err != nil {
+			return fmt.Errorf("failed to save cover thumbnail: %v", err)
+		}
+	}
+	return nil
+}
+
+// encodeThumbnail scales an image down to the given width, keeping its
+// aspect ratio, and encodes it as a JPEG. Smaller images are not enlarged.
+func encodeThumbnail(src image.Image, width int) ([]byte, error) {
+	bounds := src.Bounds()
+	if bounds.Dx() > width {
+		height := bounds.Dy() * width / bounds.Dx()
+		if height < 1 {
+			height = 1
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+		src = dst
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: coverQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode cover thumbnail: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// coverKey returns the storage key of a cover thumbnail
+func coverKey(coverPath, size string) string {
+	return coverPath + "_" + size + ".jpg"
+}
+
+// coverKeys returns the storage keys of the thumbnails of a cover
+func coverKeys(coverPath string) []string {
+	if coverPath == "" {
+		return nil
+	}
+	keys := make([]string, 0, len(coverSizes))
+	for size := range coverSizes {
+		keys = append(keys, coverKey(coverPath, size))
+	}
+	return keys
+}
+
+// coverPathOf derives the cover path of a book from the key of its stored file
+func coverPathOf(filePath string) string {
+	name := path.Base(filePath)
+	return path.Join(coversDir, strings.TrimSuffix(name, path.Ext(name)))
+}
+
+// GetBookCover opens a book's cover thumbnail in the given size. Covers
+// of books stored before covers were extracted are generated on first
+// request.
+func (s *bookService) GetBookCover(id uint, size string) (*BookFile, error) {
+	if size == "" {
+		size = DefaultCoverSize
+	}
+	if _, ok := coverSizes[size]; !ok {
+		return nil, models.ErrInvalidCoverSize
+	}
+
+	var book models.Book
+	if err := s.db.First(&book, id).Error; err != nil {
+		return nil, fmt.Errorf("book not found: %v", err)
+	}
+
+	if book.CoverPath == "" {
+		if err := s.generateCover(&book); err != nil {
+			return nil, err
+		}
+	}
+
+	obj, err := s.store.Get(coverKey(book.CoverPath, size))
+	if err != nil {
+		return nil, models.ErrCoverNotFound
+	}
+	info := obj.Info()
+	return &BookFile{
+		Book:    &book,
+		Content: obj,
+		Size:    info.Size,
+		ModTime: info.ModTime,
+	}, nil
+}
+
+// generateCover extracts the cover of a stored book and saves its thumbnails
+func (s *bookService) generateCover(book *models.Book) error {
+	obj, err := s.store.Get(book.FilePath)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return models.ErrCoverNotFound
+		}
+		return fmt.Errorf("failed to open book file: %v", err)
+	}
+	defer obj.Close()
+
+	data, ok := extractCover(book.Format, obj, obj.Info().Size)
+	if !ok {
+		return models.ErrCoverNotFound
+	}
+	thumbs, err := coverThumbnails(data)
+	if err != nil {
+		return models.ErrCoverNotFound
+	}
+
+	coverPath := coverPathOf(book.FilePath)
+	err = s.inTransaction(func(u *unitOfWork) error {
+		if err := putCovers(u, coverPath, thumbs); err != nil {
+			return err
+		}
+		return u.tx.Model(book).Update("cover_path", coverPath).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save book cover: %v", err)
+	}
+	book.CoverPath = coverPath
+	return nil
+}
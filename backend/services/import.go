@@ -0,0 +1,222 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/zven/bookpavilion/config"
+	"github.com/zven/bookpavilion/models"
+)
+
+// Signatures of the archive types accepted for bulk import
+var (
+	zipSignature      = []byte("PK\x03\x04")
+	emptyZipSignature = []byte("PK\x05\x06")
+	gzipSignature     = []byte{0x1f, 0x8b}
+)
+
+// ImportBooks implements BookService.ImportBooks. The archive type is told
+// from its content. Each book file in it goes through the same checks as a
+// single upload, and its title falls back to the file name when the file's
+// metadata has none. A file that fails is reported and the import goes on
+// with the next one.
+func (s *bookService) ImportBooks(archive *multipart.FileHeader) (*models.ImportReport, error) {
+	if limit := config.GetMaxImportSize(); limit > 0 && archive.Size > limit {
+		return nil, models.ErrArchiveTooLarge
+	}
+
+	src, err := formFile(archive).Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open uploaded archive: %v", err)
+	}
+	defer src.Close()
+
+	header := make([]byte, 4)
+	n, _ := src.ReadAt(header, 0)
+	header = header[:n]
+
+	report := &models.ImportReport{Results: []models.ImportResult{}}
+	switch {
+	case bytes.HasPrefix(header, zipSignature), bytes.HasPrefix(header, emptyZipSignature):
+		err = s.importZip(src, archive.Size, report)
+	case bytes.HasPrefix(header, gzipSignature):
+		err = s.importTarGz(src, report)
+	default:
+		return nil, models.ErrInvalidArchive
+	}
+	if err != nil {
+		if len(report.Results) == 0 {
+			return nil, models.ErrInvalidArchive
+		}
+		// Keep what was imported before the damaged part
+		report.Error = fmt.Sprintf("archive is truncated or corrupt: %v", err)
+	}
+	return report, nil
+}
+
+// importZip imports the book files of a ZIP archive
+func (s *bookService) importZip(src io.ReaderAt, size int64, report *models.ImportReport) error {
+	zr, err := zip.NewReader(src, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() || ignoredImportEntry(f.Name) {
+			continue
+		}
+		report.Add(s.importEntry(f.Name, int64(f.UncompressedSize64), func() (io.ReadCloser, error) {
+			return f.Open()
+		}))
+	}
+	return nil
+}
+
+// importTarGz imports the book files of a gzip-compressed tar archive,
+// reading it once from start to end
+func (s *bookService) importTarGz(src io.Reader, report *models.ImportReport) error {
+	gz, err := gzip.NewReader(src)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || ignoredImportEntry(hdr.Name) {
+			continue
+		}
+		report.Add(s.importEntry(hdr.Name, hdr.Size, func() (io.ReadCloser, error) {
+			return io.NopCloser(tr), nil
+		}))
+	}
+}
+
+// ignoredImportEntry reports whether an archive entry is left out of an
+// import without being reported: hidden files, and the resource forks and
+// metadata added by macOS
+func ignoredImportEntry(name string) bool {
+	if strings.HasPrefix(path.Base(name), ".") {
+		return true
+	}
+	for _, dir := range strings.Split(path.Dir(name), "/") {
+		if dir == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+// importEntry creates a book from one archive entry. The entry is copied
+// to a temporary file first, as the checks need to read it more than once.
+// size is the size declared by the archive, which is checked again against
+// what is actually extracted.
+func (s *bookService) importEntry(name string, size int64, open func() (io.ReadCloser, error)) models.ImportResult {
+	result := models.ImportResult{Filename: name}
+	filename := path.Base(name)
+
+	format, err := checkUploadFormat(filename, size)
+	if err != nil {
+		return rejectImport(result, err)
+	}
+
+	tmpPath, size, err := extractEntry(open, config.GetMaxFileSize(string(format)))
+	if err != nil {
+		return rejectImport(result, err)
+	}
+	defer os.Remove(tmpPath)
+
+	book, err := s.CreateBookFromFile("", "", &UploadedFile{
+		Filename:     filename,
+		Size:         size,
+		DefaultTitle: strings.TrimSuffix(filename, path.Ext(filename)),
+		Open: func() (multipart.File, error) {
+			return os.Open(tmpPath)
+		},
+	})
+	if err != nil {
+		var duplicate *models.DuplicateBookError
+		if errors.As(err, &duplicate) {
+			result.Status = models.ImportDuplicate
+			result.BookID = duplicate.BookID
+			result.Error = err.Error()
+			return result
+		}
+		return rejectImport(result, err)
+	}
+
+	result.Status = models.ImportCreated
+	result.BookID = book.ID
+	return result
+}
+
+// extractEntry copies an archive entry to a temporary file and returns its
+// path and size. Extraction stops as soon as the entry passes limit, so a
+// highly compressed entry can't fill the disk.
+func extractEntry(open func() (io.ReadCloser, error), limit int64) (string, int64, error) {
+	r, err := open()
+	if err != nil {
+		return "", 0, models.ErrCorruptEntry
+	}
+	defer r.Close()
+
+	tmp, err := os.CreateTemp("", "bookpavilion-import-*")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to extract archive entry: %v", err)
+	}
+	src := io.Reader(r)
+	if limit > 0 {
+		src = io.LimitReader(r, limit+1)
+	}
+	n, err := io.Copy(tmp, src)
+	var writeErr *os.PathError
+	switch {
+	case errors.As(err, &writeErr):
+		err = fmt.Errorf("failed to extract archive entry: %v", err)
+	case err != nil:
+		err = models.ErrCorruptEntry // checksum failure or truncated data
+	case limit > 0 && n > limit:
+		err = models.ErrFileTooLarge
+	}
+	if closeErr := tmp.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to extract archive entry: %v", closeErr)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", 0, err
+	}
+	return tmp.Name(), n, nil
+}
+
+// rejectImport records why an archive entry was rejected. Errors about the
+// file itself are reported as they are; others are logged and reported
+// without their details.
+func rejectImport(result models.ImportResult, err error) models.ImportResult {
+	result.Status = models.ImportRejected
+	var mismatch *models.FormatMismatchError
+	switch {
+	case errors.As(err, &mismatch), err == models.ErrInvalidFormat,
+		err == models.ErrFileTooLarge, err == models.ErrTitleRequired, err == models.ErrCorruptEntry:
+		result.Error = err.Error()
+	default:
+		log.Printf("Failed to import %s: %v", result.Filename, err)
+		result.Error = "failed to create book"
+	}
+	return result
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"io"
+	"strings"
+
+	"github.com/zven/bookpavilion/models"
+	"github.com/zven/bookpavilion/parsers"
+)
+
+// bookMetadata is the descriptive information embedded in a book file
+type bookMetadata struct {
+	Title       string
+	Author      string
+	Language    string
+	Publisher   string
+	ISBN        string
+	Description string
+}
+
+// readMetadata reads the embedded metadata of a book file: the OPF package
+// metadata of an EPUB, the information dictionary of a PDF, or the EXTH
+// header of a MOBI/AZW3 book. TXT files carry none.
+func readMetadata(format models.BookFormat, r io.ReaderAt, size int64) (*bookMetadata, error) {
+	switch format {
+	case models.FormatEPUB:
+		epub, err := parsers.ParseEPUB(r, size)
+		if err != nil {
+			return nil, err
+		}
+		m := epub.Metadata
+		return &bookMetadata{
+			Title:       m.Title,
+			Author:      strings.Join(m.Authors, ", "),
+			Language:    m.Language,
+			Publisher:   m.Publisher,
+			ISBN:        m.ISBN,
+			Description: m.Description,
+		}, nil
+	case models.FormatPDF:
+		pdf, err := parsers.ParsePDF(r, size)
+		if err != nil {
+			return nil, err
+		}
+		info := pdf.Info()
+		return &bookMetadata{
+			Title:       info.Title,
+			Author:      info.Author,
+			Language:    info.Language,
+			Description: info.Subject,
+		}, nil
+	case models.FormatMOBI, models.FormatAZW3:
+		mobi, err := parsers.ParseMOBI(r, size)
+		if err != nil {
+			return nil, err
+		}
+		m := mobi.Metadata
+		return &bookMetadata{
+			Title:       m.Title,
+			Author:      strings.Join(m.Authors, ", "),
+			Language:    m.Language,
+			Publisher:   m.Publisher,
+			ISBN:        m.ISBN,
+			Description: m.Description,
+		}, nil
+	}
+	return &bookMetadata{}, nil
+}
+
+// apply fills the blank fields of a book from the metadata. Values given by
+// the user are never overwritten.
+func (m *bookMetadata) apply(book *models.Book) {
+	fill := func(field *string, value string, limit int) {
+		value = strings.TrimSpace(value)
+		if *field != "" || value == "" {
+			return
+		}
+		// Keep within the column sizes
+		if runes := []rune(value); limit > 0 && len(runes) > limit {
+			value = string(runes[:limit])
+		}
+		*field = value
+	}
+
+	fill(&book.Title, m.Title, 200)
+	fill(&book.Author, m.Author, 100)
+	fill(&book.Language, m.Language, 20)
+	fill(&book.Publisher, m.Publisher, 200)
+	fill(&book.ISBN, m.ISBN, 20)
+	fill(&book.Description, m.Description, 0)
+}
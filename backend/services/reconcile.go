@@ -0,0 +1,210 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zven/bookpavilion/models"
+	"github.com/zven/bookpavilion/storage"
+)
+
+// quarantineDir is the key prefix, inside the storage, of orphan files
+// moved aside by a repair. Files there are left alone by later runs.
+const quarantineDir = "quarantine"
+
+// orphanGracePeriod is how old a file no book refers to must be before it
+// is reported. A newer file may belong to a book whose transaction has not
+// committed yet.
+const orphanGracePeriod = time.Hour
+
+// ReconcileStorage implements BookService.ReconcileStorage. It compares
+// the stored files with the books, including those in the trash, and
+// reports files no book refers to, books whose file is missing, and files
+// whose size differs from the book's, or with Hash, whose SHA-256 does.
+// With Repair, orphan files are moved under quarantine/, leftovers of
+// interrupted writes (under staging/, or temporary files of the local
+// storage) are deleted, and books are flagged with their file issue, or
+// unflagged once it is gone. Only one reconciliation runs at a time.
+func (s *bookService) ReconcileStorage(opts models.ReconcileOptions) (*models.ReconcileReport, error) {
+	if !s.reconciling.TryLock() {
+		return nil, models.ErrReconcileRunning
+	}
+	defer s.reconciling.Unlock()
+
+	report := &models.ReconcileReport{
+		Repair:    opts.Repair,
+		Hash:      opts.Hash,
+		Issues:    []models.ReconcileIssue{},
+		StartedAt: s.now(),
+	}
+
+	// Books are read before files are listed: a file stored in between is
+	// recent, and a file deleted in between belongs to a purged book
+	var books []models.Book
+	if err := s.db.Unscoped().Order("id").Find(&books).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch books: %v", err)
+	}
+	objects, err := s.store.List("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stored files: %v", err)
+	}
+
+	stored := make(map[string]storage.ObjectInfo, len(objects))
+	for _, obj := range objects {
+		stored[obj.Key] = obj
+	}
+
+	referenced := make(map[string]bool)
+	hashes := make(map[string]string) // books sharing a file read it once
+	for i := range books {
+		book := &books[i]
+		referenced[book.FilePath] = true
+		for _, key := range coverKeys(book.CoverPath) {
+			referenced[key] = true
+		}
+
+		issue := s.checkBookFile(book, stored, hashes, opts.Hash)
+		// A hash mismatch can only be seen to be gone by hashing the file
+		keepFlag := issue == nil && !opts.Hash && book.FileIssue == models.FileHashMismatch
+		if opts.Repair && !keepFlag {
+			s.flagBook(book, issue, report)
+		}
+		if issue != nil {
+			report.Add(*issue)
+		}
+	}
+	report.Books = len(books)
+
+	cutoff := report.StartedAt.Add(-orphanGracePeriod)
+	for _, obj := range objects {
+		if strings.HasPrefix(obj.Key, quarantineDir+"/") {
+			continue
+		}
+		report.Files++
+		if referenced[obj.Key] || obj.ModTime.After(cutoff) {
+			continue
+		}
+		issue := models.ReconcileIssue{
+			Issue:  models.FileOrphan,
+			Key:    obj.Key,
+			Actual: strconv.FormatInt(obj.Size, 10),
+		}
+		if opts.Repair {
+			s.removeOrphan(&issue)
+		}
+		report.Add(issue)
+	}
+
+	report.FinishedAt = s.now()
+	return report, nil
+}
+
+// checkBookFile checks the stored file of a book against its size and,
+// with hash, its SHA-256, and returns the issue found, if any. A file that
+// can't be read is logged and only checked by size.
+func (s *bookService) checkBookFile(book *models.Book, stored map[string]storage.ObjectInfo, hashes map[string]string, hash bool) *models.ReconcileIssue {
+	issue := &models.ReconcileIssue{Key: book.FilePath, BookID: book.ID}
+
+	obj, ok := stored[book.FilePath]
+	if !ok {
+		issue.Issue = models.FileMissing
+		return issue
+	}
+	if obj.Size != book.FileSize {
+		issue.Issue = models.FileSizeMismatch
+		issue.Expected = strconv.FormatInt(book.FileSize, 10)
+		issue.Actual = strconv.FormatInt(obj.Size, 10)
+		return issue
+	}
+	if !hash || book.SHA256 == "" {
+		return nil // not asked for, or stored before files were hashed
+	}
+
+	sum, ok := hashes[book.FilePath]
+	if !ok {
+		var err error
+		if sum, err = s.hashStoredFile(book.FilePath); err != nil {
+			log.Printf("Failed to check file of book %d: %v", book.ID, err)
+			return nil
+		}
+		hashes[book.FilePath] = sum
+	}
+	if sum != book.SHA256 {
+		issue.Issue = models.FileHashMismatch
+		issue.Expected = book.SHA256
+		issue.Actual = sum
+		return issue
+	}
+	return nil
+}
+
+// hashStoredFile computes the SHA-256 of a stored file
+func (s *bookService) hashStoredFile(key string) (string, error) {
+	obj, err := s.store.Get(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to open stored file: %v", err)
+	}
+	defer obj.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, obj); err != nil {
+		return "", fmt.Errorf("failed to read stored file: %v", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// flagBook records the file issue of a book on it, or clears the issue
+// recorded by an earlier run. The update is skipped if the book's file
+// has been replaced since it was checked.
+func (s *bookService) flagBook(book *models.Book, issue *models.ReconcileIssue, report *models.ReconcileReport) {
+	var flag models.FileIssue
+	if issue != nil {
+		flag = issue.Issue
+		issue.Action = models.ActionFlagged
+	}
+	if book.FileIssue == flag {
+		return
+	}
+
+	err := s.db.Unscoped().Model(&models.Book{}).
+		Where("id = ? AND file_path = ?", book.ID, book.FilePath).
+		UpdateColumn("file_issue", flag).Error
+	if err != nil {
+		log.Printf("Failed to flag book %d: %v", book.ID, err)
+		if issue != nil {
+			issue.Action = ""
+			issue.Error = "failed to flag book"
+		}
+		return
+	}
+	book.FileIssue = flag
+	if issue == nil {
+		report.Cleared++
+	}
+}
+
+// removeOrphan moves an orphan file under quarantine/, keeping its key
+// below it. Files left under staging/ or as temporary files by an
+// interrupted write are deleted instead, as they are never more than a copy
+// of an upload.
+func (s *bookService) removeOrphan(issue *models.ReconcileIssue) {
+	var err error
+	if strings.HasPrefix(issue.Key, stagingDir+"/") || storage.IsTempFile(issue.Key) {
+		issue.Action = models.ActionDeleted
+		err = s.store.Delete(issue.Key)
+	} else {
+		issue.Action = models.ActionQuarantined
+		err = s.store.Rename(issue.Key, quarantineDir+"/"+issue.Key)
+	}
+	if err != nil {
+		log.Printf("Failed to remove orphan file %s: %v", issue.Key, err)
+		issue.Action = ""
+		issue.Error = "failed to remove orphan file"
+	}
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/zven/bookpavilion/mocks"
+	"github.com/zven/bookpavilion/models"
+	"github.com/zven/bookpavilion/storage"
+)
+
+func TestReconcileStorage(t *testing.T) {
+	good := []byte("%PDF-1.4 good content")
+	goodHash, _ := hashUpload(bytes.NewReader(good))
+	changed := []byte("%PDF-1.4 evil content") // same size as good
+	changedHash := "ab" + goodHash[2:]
+	actualHash, _ := hashUpload(bytes.NewReader(changed))
+	orphanHash := "ff" + goodHash[2:]
+	cover := coverPathOf(blobKey(goodHash))
+
+	// setup stores the files and expects the books:
+	//  1. a book whose file and covers are all right
+	//  2. a book whose file is missing
+	//  3. a book whose file has another size
+	//  4. a book whose file has other content
+	//  5. a book stored before hashing, flagged missing by an earlier run
+	//     and whose file is back
+	setup := func(t *testing.T, mock sqlmock.Sqlmock, store storage.Storage) {
+		putTestFile(t, store, blobKey(goodHash), good)
+		for _, key := range coverKeys(cover) {
+			putTestFile(t, store, key, []byte("jpeg"))
+		}
+		putTestFile(t, store, blobKey(changedHash), changed)
+		putTestFile(t, store, "short.pdf", []byte("%PDF"))
+		putTestFile(t, store, "legacy.pdf", good)
+		putTestFile(t, store, blobKey(orphanHash), good)
+		putTestFile(t, store, stagingDir+"/0123456789abcdef", good)
+		putTestFile(t, store, "blobs/ab/.put-123456", good)
+		putTestFile(t, store, quarantineDir+"/old.pdf", good)
+
+		mock.ExpectQuery("SELECT \\* FROM `books` ORDER BY id").
+			WillReturnRows(sqlmock.NewRows(append(mocks.BookColumns(), "cover_path", "sha256", "file_issue")).
+				AddRow(1, "Good", "", "pdf", blobKey(goodHash), len(good), time.Now(), time.Now(), nil, cover, goodHash, "").
+				AddRow(2, "Missing", "", "pdf", blobKey(goodHash[:63]+"0"), len(good), time.Now(), time.Now(), time.Now(), "", goodHash[:63]+"0", "").
+				AddRow(3, "Short", "", "pdf", "short.pdf", len(good), time.Now(), time.Now(), nil, "", "", "").
+				AddRow(4, "Changed", "", "pdf", blobKey(changedHash), len(good), time.Now(), time.Now(), nil, "", changedHash, "").
+				AddRow(5, "Legacy", "", "pdf", "legacy.pdf", len(good), time.Now(), time.Now(), nil, "", "", "missing"))
+	}
+
+	expected := []models.ReconcileIssue{
+		{Issue: models.FileMissing, Key: blobKey(goodHash[:63] + "0"), BookID: 2},
+		{Issue: models.FileSizeMismatch, Key: "short.pdf", BookID: 3, Expected: "21", Actual: "4"},
+		{Issue: models.FileHashMismatch, Key: blobKey(changedHash), BookID: 4, Expected: changedHash, Actual: actualHash},
+		{Issue: models.FileOrphan, Key: "blobs/ab/.put-123456", Actual: "21"},
+		{Issue: models.FileOrphan, Key: blobKey(orphanHash), Actual: "21"},
+		{Issue: models.FileOrphan, Key: stagingDir + "/0123456789abcdef", Actual: "21"},
+	}
+	checkIssues := func(t *testing.T, report *models.ReconcileReport, actions []models.ReconcileAction) {
+		if len(report.Issues) != len(expected) {
+			t.Fatalf("expected %d issues but got %+v", len(expected), report.Issues)
+		}
+		for i, issue := range report.Issues {
+			want := expected[i]
+			want.Action = actions[i]
+			if issue != want {
+				t.Errorf("expected issue %+v but got %+v", want, issue)
+			}
+		}
+		if report.Books != 5 || report.Files != 10 || report.Orphans != 3 || report.Missing != 1 || report.Mismatches != 2 {
+			t.Errorf("unexpected report totals %+v", report)
+		}
+	}
+
+	t.Run("Report", func(t *testing.T) {
+		service, mock, cleanup := setupTest(t)
+		defer cleanup()
+		service.now = func() time.Time { return time.Now().Add(2 * orphanGracePeriod) }
+		setup(t, mock, service.store)
+		before := storedKeys(t, service.store)
+
+		report, err := service.ReconcileStorage(models.ReconcileOptions{Hash: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		checkIssues(t, report, make([]models.ReconcileAction, len(expected)))
+		if report.Cleared != 0 {
+			t.Errorf("expected no flag cleared but got %d", report.Cleared)
+		}
+
+		// Nothing is changed without repair
+		if after := storedKeys(t, service.store); len(after) != len(before) {
+			t.Errorf("expected stored files %v but got %v", before, after)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %v", err)
+		}
+	})
+
+	t.Run("Repair", func(t *testing.T) {
+		service, mock, cleanup := setupTest(t)
+		defer cleanup()
+		service.now = func() time.Time { return time.Now().Add(2 * orphanGracePeriod) }
+		setup(t, mock, service.store)
+
+		flags := []struct {
+			issue models.FileIssue
+			id    uint
+			path  string
+		}{
+			{models.FileMissing, 2, blobKey(goodHash[:63] + "0")},
+			{models.FileSizeMismatch, 3, "short.pdf"},
+			{models.FileHashMismatch, 4, blobKey(changedHash)},
+			{"", 5, "legacy.pdf"},
+		}
+		for _, flag := range flags {
+			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE `books` SET `file_issue`=\\? WHERE id = \\? AND file_path = \\?").
+				WithArgs(flag.issue, flag.id, flag.path).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}
+
+		report, err := service.ReconcileStorage(models.ReconcileOptions{Repair: true, Hash: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		checkIssues(t, report, []models.ReconcileAction{
+			models.ActionFlagged, models.ActionFlagged, models.ActionFlagged,
+			models.ActionDeleted, models.ActionQuarantined, models.ActionDeleted,
+		})
+		if report.Cleared != 1 {
+			t.Errorf("expected 1 flag cleared but got %d", report.Cleared)
+		}
+
+		// The orphan is kept aside and the leftovers of writes are gone
+		if _, err := service.store.Stat(quarantineDir + "/" + blobKey(orphanHash)); err != nil {
+			t.Errorf("expected the orphan file to be quarantined: %v", err)
+		}
+		for _, key := range []string{blobKey(orphanHash), stagingDir + "/0123456789abcdef", "blobs/ab/.put-123456"} {
+			if _, err := service.store.Stat(key); err != storage.ErrNotFound {
+				t.Errorf("expected %s to be removed but got %v", key, err)
+			}
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %v", err)
+		}
+	})
+
+	t.Run("Sizes Only", func(t *testing.T) {
+		service, mock, cleanup := setupTest(t)
+		defer cleanup()
+		store := &countingStorage{Storage: service.store}
+		service.store = store
+		setup(t, mock, store.Storage)
+
+		report, err := service.ReconcileStorage(models.ReconcileOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, issue := range report.Issues {
+			if issue.Issue == models.FileHashMismatch {
+				t.Errorf("expected no hash check but got %+v", issue)
+			}
+		}
+		if report.Mismatches != 1 || store.gets != 0 {
+			t.Errorf("expected only the size mismatch without reading files but got %+v and %d reads", report, store.gets)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %v", err)
+		}
+	})
+
+	t.Run("Hash Flag Kept Without Hashing", func(t *testing.T) {
+		service, mock, cleanup := setupTest(t)
+		defer cleanup()
+		putTestFile(t, service.store, blobKey(changedHash), changed)
+
+		// No update is expected: the flag can't be seen to be wrong
+		mock.ExpectQuery("SELECT \\* FROM `books` ORDER BY id").
+			WillReturnRows(sqlmock.NewRows(append(mocks.BookColumns(), "cover_path", "sha256", "file_issue")).
+				AddRow(4, "Changed", "", "pdf", blobKey(changedHash), len(good), time.Now(), time.Now(), nil, "", changedHash, "hash_mismatch"))
+
+		report, err := service.ReconcileStorage(models.ReconcileOptions{Repair: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(report.Issues) != 0 || report.Cleared != 0 {
+			t.Errorf("expected no issues and no flag cleared but got %+v", report)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %v", err)
+		}
+	})
+
+	t.Run("Recent Files", func(t *testing.T) {
+		service, mock, cleanup := setupTest(t)
+		defer cleanup()
+		setup(t, mock, service.store)
+
+		// Files stored within the grace period may belong to a book that
+		// isn't committed yet
+		report, err := service.ReconcileStorage(models.ReconcileOptions{Hash: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if report.Orphans != 0 {
+			t.Errorf("expected no orphan files but got %+v", report.Issues)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %v", err)
+		}
+	})
+
+	t.Run("Already Running", func(t *testing.T) {
+		service, _, cleanup := setupTest(t)
+		defer cleanup()
+
+		service.reconciling.Lock()
+		defer service.reconciling.Unlock()
+		if _, err := service.ReconcileStorage(models.ReconcileOptions{}); err != models.ErrReconcileRunning {
+			t.Errorf("expected error %v but got %v", models.ErrReconcileRunning, err)
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/zven/bookpavilion/models"
+	"github.com/zven/bookpavilion/search"
+	"gorm.io/gorm"
+)
+
+// Search result limits. Matches are located only in the first
+// maxSnippetHits books of a page, as each takes reading the book's text.
+const (
+	maxMatchesPerBook = 3
+	maxSnippetHits    = 10
+	maxInBookMatches  = 1000
+	snippetContext    = 40
+)
+
+// indexBook adds a book to the full-text index. A book whose text can't be
+// extracted is indexed by its title and author only.
+func (s *bookService) indexBook(book *models.Book) {
+	if s.index == nil {
+		return
+	}
+	text := ""
+	if content, err := s.readContent(book); err == nil {
+		text = content.Text()
+	} else {
+		log.Printf("Failed to read content of book %d for indexing: %v", book.ID, err)
+	}
+	if err := s.index.Add(book.ID, book.Title+" "+book.Author, text); err != nil {
+		log.Printf("Failed to index book %d: %v", book.ID, err)
+	}
+}
+
+// unindexBook removes a book from the full-text index
+func (s *bookService) unindexBook(id uint) {
+	if s.index == nil {
+		return
+	}
+	if err := s.index.Remove(id); err != nil {
+		log.Printf("Failed to remove book %d from index: %v", id, err)
+	}
+}
+
+// SyncSearchIndex indexes the books missing from the full-text index and
+// drops the entries of books that no longer exist
+func (s *bookService) SyncSearchIndex() error {
+	if s.index == nil {
+		return nil
+	}
+
+	var books []models.Book
+	if err := s.db.Find(&books).Error; err != nil {
+		return fmt.Errorf("failed to fetch books: %v", err)
+	}
+
+	existing := make(map[uint]bool, len(books))
+	for i := range books {
+		existing[books[i].ID] = true
+		if !s.index.Has(books[i].ID) {
+			s.indexBook(&books[i])
+		}
+	}
+	for _, id := range s.index.BookIDs() {
+		if !existing[id] {
+			s.unindexBook(id)
+		}
+	}
+	return nil
+}
+
+// SearchBooks searches the contents of all books. Results are ranked by
+// relevance and carry highlighted snippets of the first matches.
+func (s *bookService) SearchBooks(q string, page, pageSize int) (*models.SearchResult, error) {
+	q = strings.TrimSpace(q)
+	if q == "" {
+		return nil, models.ErrEmptyQuery
+	}
+	page, pageSize = models.ClampPage(page, pageSize)
+
+	result := &models.SearchResult{
+		Query: q,
+		Page:  page,
+		Size:  pageSize,
+		Books: []models.BookSearchHit{},
+	}
+	if s.index == nil {
+		return result, nil
+	}
+
+	hits := s.index.Search(q)
+	result.Total = len(hits)
+	start := (page - 1) * pageSize
+	if start >= len(hits) {
+		return result, nil
+	}
+	end := start + pageSize
+	if end > len(hits) {
+		end = len(hits)
+	}
+	hits = hits[start:end]
+
+	ids := make([]uint, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.BookID
+	}
+	var books []models.Book
+	if err := s.db.Where("id IN ?", ids).Find(&books).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch books: %v", err)
+	}
+	byID := make(map[uint]*models.Book, len(books))
+	for i := range books {
+		byID[books[i].ID] = &books[i]
+	}
+
+	// Keep the ranking order; books deleted since indexing are skipped
+	phrases := search.Segments(q)
+	for _, hit := range hits {
+		book, ok := byID[hit.BookID]
+		if !ok {
+			continue
+		}
+		matches := []models.SearchMatch{}
+		if len(result.Books) < maxSnippetHits {
+			matches = s.findMatches(book, phrases)
+		}
+		result.Books = append(result.Books, models.BookSearchHit{
+			Book:    *book,
+			Score:   hit.Score,
+			Matches: matches,
+		})
+	}
+	return result, nil
+}
+
+// findMatches locates the first occurrences of the query phrases in a
+// book's text, with the chapter each falls in
+func (s *bookService) findMatches(book *models.Book, phrases []string) []models.SearchMatch {
+	matches := []models.SearchMatch{}
+	text, err := s.searchText(book)
+	if err != nil {
+		return matches
+	}
+
+	runes := []rune(text.text)
+	for _, m := range search.FindPhrases(text.text, phrases, maxMatchesPerBook) {
+		matches = append(matches, newSearchMatch(runes, m, text.chapters))
+	}
+	return matches
+}
+
+// SearchInBook finds every occurrence of a query in the text of one book
+func (s *bookService) SearchInBook(id uint, q string, opts search.Options) (*models.InBookSearchResult, error) {
+	if strings.TrimSpace(q) == "" {
+		return nil, models.ErrEmptyQuery
+	}
+	finder, err := search.NewFinder(q, opts)
+	if err != nil {
+		return nil, models.ErrInvalidPattern
+	}
+
+	var book models.Book
+	if err := s.db.First(&book, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrBookNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch book: %v", err)
+	}
+
+	text, err := s.searchText(&book)
+	if err != nil {
+		return nil, err
+	}
+
+	found, more := finder.FindAll(text.text, maxInBookMatches)
+	runes := []rune(text.text)
+	matches := make([]models.SearchMatch, 0, len(found))
+	for _, m := range found {
+		matches = append(matches, newSearchMatch(runes, m, text.chapters))
+	}
+
+	return &models.InBookSearchResult{
+		BookID:    book.ID,
+		Query:     q,
+		Count:     len(matches),
+		Truncated: more,
+		Matches:   matches,
+	}, nil
+}
+
+// newSearchMatch describes a match in a book's text
+func newSearchMatch(text []rune, m search.Match, chapters []models.BookChapter) models.SearchMatch {
+	match := models.SearchMatch{
+		Offset:  m.Offset,
+		Length:  m.Length,
+		Snippet: search.Snippet(text, m, snippetContext),
+	}
+	if chapter := chapterAt(chapters, m.Offset); chapter != nil {
+		match.Chapter = chapter.Number
+		match.ChapterTitle = chapter.Title
+	}
+	return match
+}
+
+// chapterAt returns the chapter containing a character offset
+func chapterAt(chapters []models.BookChapter, offset int) *models.BookChapter {
+	var found *models.BookChapter
+	for i := range chapters {
+		if chapters[i].Offset > offset {
+			break
+		}
+		found = &chapters[i]
+	}
+	return found
+}
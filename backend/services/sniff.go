@@ -0,0 +1,82 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/zven/bookpavilion/models"
+	"github.com/zven/bookpavilion/parsers"
+)
+
+// sniffSize is how many leading bytes of an upload are inspected
+const sniffSize = 3072
+
+// checkContent detects the type of an uploaded file from its leading bytes
+// and returns a FormatMismatchError if it isn't the declared format
+func checkContent(r io.ReaderAt, size int64, declared models.BookFormat) error {
+	header := make([]byte, sniffSize)
+	n, err := r.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read uploaded file: %v", err)
+	}
+	header = header[:n]
+
+	detected := mimetype.Detect(header)
+	if contentMatches(declared, detected, header, r, size) {
+		return nil
+	}
+	return &models.FormatMismatchError{
+		Declared: declared,
+		Detected: strings.TrimSpace(strings.SplitN(detected.String(), ";", 2)[0]),
+	}
+}
+
+// contentMatches reports whether the detected type of a file is valid for
+// the declared format
+func contentMatches(declared models.BookFormat, detected *mimetype.MIME, header []byte, r io.ReaderAt, size int64) bool {
+	switch declared {
+	case models.FormatPDF:
+		return detected.Is("application/pdf")
+	case models.FormatEPUB:
+		if detected.Is("application/epub+zip") {
+			return true
+		}
+		// Some EPUBs don't store the mimetype entry first; accept any zip
+		// that holds a readable EPUB container
+		if detected.Is("application/zip") {
+			_, err := parsers.ParseEPUB(r, size)
+			return err == nil
+		}
+		return false
+	case models.FormatMOBI, models.FormatAZW3:
+		// MOBI and AZW3 share the Palm database header; PalmDOC books are
+		// marked TEXtREAd instead of BOOKMOBI
+		return detected.Is("application/x-mobipocket-ebook") ||
+			len(header) >= 68 && bytes.Equal(header[60:68], []byte("TEXtREAd"))
+	case models.FormatCBZ:
+		return detected.Is("application/zip")
+	case models.FormatTXT:
+		return isText(detected, header)
+	}
+	return false
+}
+
+// isText reports whether a file holds plain text. Any type the detector
+// derives from text/plain qualifies; the detector takes GBK and other
+// legacy encodings for text as long as they have no control bytes. UTF-16
+// without a byte order mark, which has zero bytes, qualifies as well.
+func isText(detected *mimetype.MIME, header []byte) bool {
+	for m := detected; m != nil; m = m.Parent() {
+		if m.Is("text/plain") {
+			return true
+		}
+	}
+	switch parsers.DetectEncoding(header) {
+	case parsers.EncodingUTF16LE, parsers.EncodingUTF16BE:
+		return true
+	}
+	return false
+}
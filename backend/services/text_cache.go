@@ -0,0 +1,105 @@
+package services
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/zven/bookpavilion/models"
+)
+
+// maxCachedText caps the bytes of book text kept by the text cache
+const maxCachedText = 64 << 20
+
+// textCache keeps the extracted text of recently searched books, so that
+// repeated searches don't parse the same files again. The zero value is
+// an empty cache.
+type textCache struct {
+	mu      sync.Mutex
+	entries map[uint]*list.Element
+	order   list.List // most recently used first
+	size    int
+}
+
+// cachedText is the text of a book with its chapters, without their text.
+// It is valid while the book keeps its file and update time.
+type cachedText struct {
+	bookID    uint
+	filePath  string
+	updatedAt time.Time
+	text      string
+	chapters  []models.BookChapter
+}
+
+// get returns the cached text of a book, if still valid
+func (c *textCache) get(book *models.Book) (*cachedText, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[book.ID]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cachedText)
+	if entry.filePath != book.FilePath || !entry.updatedAt.Equal(book.UpdatedAt) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry, true
+}
+
+// put caches the text of a book, evicting the least recently used entries
+// past maxCachedText. Texts larger than the whole cache are not kept.
+func (c *textCache) put(entry *cachedText) {
+	if len(entry.text) > maxCachedText {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[uint]*list.Element)
+	}
+	if elem, ok := c.entries[entry.bookID]; ok {
+		c.remove(elem)
+	}
+	c.entries[entry.bookID] = c.order.PushFront(entry)
+	c.size += len(entry.text)
+	for c.size > maxCachedText {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove drops an entry; the caller holds the lock
+func (c *textCache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*cachedText)
+	delete(c.entries, entry.bookID)
+	c.size -= len(entry.text)
+}
+
+// searchText returns the text of a book and its chapters for locating
+// search matches, from the text cache when possible. The chapters carry
+// no text.
+func (s *bookService) searchText(book *models.Book) (*cachedText, error) {
+	if entry, ok := s.texts.get(book); ok {
+		return entry, nil
+	}
+
+	content, chapters, err := s.readContentChapters(book)
+	if err != nil {
+		return nil, err
+	}
+	for i := range chapters {
+		chapters[i].Text = ""
+	}
+	entry := &cachedText{
+		bookID:    book.ID,
+		filePath:  book.FilePath,
+		updatedAt: book.UpdatedAt,
+		text:      content.Text(),
+		chapters:  chapters,
+	}
+	s.texts.put(entry)
+	return entry, nil
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/zven/bookpavilion/config"
+	"github.com/zven/bookpavilion/models"
+	"gorm.io/gorm"
+)
+
+// ListTrash implements BookService.ListTrash. The most recently deleted
+// books come first.
+func (s *bookService) ListTrash(page, pageSize int) ([]models.TrashedBook, int64, error) {
+	page, pageSize = models.ClampPage(page, pageSize)
+
+	trash := s.db.Unscoped().Model(&models.Book{}).Where("deleted_at IS NOT NULL")
+
+	var total int64
+	if err := trash.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count deleted books: %v", err)
+	}
+
+	var books []models.Book
+	if err := trash.Order("deleted_at DESC").Order("id DESC").
+		Offset((page - 1) * pageSize).Limit(pageSize).Find(&books).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch deleted books: %v", err)
+	}
+
+	retention := config.GetTrashRetention()
+	trashed := make([]models.TrashedBook, len(books))
+	for i, book := range books {
+		trashed[i] = models.TrashedBook{Book: book, DeletedAt: book.DeletedAt.Time}
+		if retention > 0 {
+			purgeAt := book.DeletedAt.Time.Add(retention)
+			trashed[i].PurgeAt = &purgeAt
+		}
+	}
+	return trashed, total, nil
+}
+
+// RestoreBook implements BookService.RestoreBook. A book whose content has
+// been uploaded again while it was in the trash is a duplicate of the new
+// book, unless duplicates share their files.
+func (s *bookService) RestoreBook(id uint) (*models.Book, error) {
+	book, err := s.trashedBook(id)
+	if err != nil {
+		return nil, err
+	}
+	if book.SHA256 != "" {
+		if err := s.checkDuplicate(book.SHA256, book.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	result := s.db.Unscoped().Model(book).Where("deleted_at IS NOT NULL").Update("deleted_at", nil)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to restore book: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, models.ErrBookNotInTrash // purged in the meantime
+	}
+	book.DeletedAt = gorm.DeletedAt{}
+
+	s.indexBook(book)
+	return book, nil
+}
+
+// PurgeBook implements BookService.PurgeBook
+func (s *bookService) PurgeBook(id uint) error {
+	book, err := s.trashedBook(id)
+	if err != nil {
+		return err
+	}
+	return s.purgeBook(book)
+}
+
+// PurgeTrash implements BookService.PurgeTrash. It returns the number of
+// books purged; nothing is purged if the retention period is 0.
+func (s *bookService) PurgeTrash() (int, error) {
+	retention := config.GetTrashRetention()
+	if retention <= 0 {
+		return 0, nil
+	}
+
+	var books []models.Book
+	if err := s.db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at <= ?", time.Now().Add(-retention)).
+		Find(&books).Error; err != nil {
+		return 0, fmt.Errorf("failed to fetch expired books: %v", err)
+	}
+
+	purged := 0
+	for i := range books {
+		if err := s.purgeBook(&books[i]); err != nil {
+			log.Printf("Failed to purge book %d: %v", books[i].ID, err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// trashedBook fetches a book in the trash
+func (s *bookService) trashedBook(id uint) (*models.Book, error) {
+	var book models.Book
+	if err := s.db.Unscoped().Where("deleted_at IS NOT NULL").First(&book, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, models.ErrBookNotInTrash
+		}
+		return nil, fmt.Errorf("failed to fetch book: %v", err)
+	}
+	return &book, nil
+}
+
+// purgeBook deletes a book in the trash for good, along with its files.
+// A book restored in the meantime is left alone.
+func (s *bookService) purgeBook(book *models.Book) error {
+	return s.inTransaction(func(u *unitOfWork) error {
+		result := u.tx.Unscoped().Where("deleted_at IS NOT NULL").Delete(book)
+		if result.Error != nil {
+			return fmt.Errorf("failed to purge book: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return models.ErrBookNotInTrash
+		}
+		return s.releaseStoredFiles(u, book)
+	})
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/zven/bookpavilion/storage"
+	"gorm.io/gorm"
+)
+
+// stagingDir is the key prefix, inside the storage, of files written by a
+// unit of work that hasn't committed yet
+const stagingDir = "staging"
+
+// unitOfWork ties the storage changes of an operation to its database
+// transaction. New files are written under a staging key and moved into
+// place only after every database change has succeeded, just before the
+// commit; files are deleted only once the commit is done. If anything fails
+// before that, the transaction is rolled back and the files written are
+// deleted again. Either way the database never refers to a file that isn't
+// stored: a crash can at worst leave files that no book refers to.
+type unitOfWork struct {
+	tx    *gorm.DB
+	store storage.Storage
+
+	staged  []stagedFile // written, not yet moved into place
+	moved   []string     // moved into place, to delete on rollback
+	deletes []string     // to delete after the commit
+}
+
+// stagedFile is a file written under a staging key, and the key it is to
+// be stored under
+type stagedFile struct {
+	staging string
+	key     string
+}
+
+// inTransaction runs fn in a unit of work and commits it if fn succeeds
+func (s *bookService) inTransaction(fn func(u *unitOfWork) error) error {
+	u := &unitOfWork{store: s.store}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		u.tx = tx
+		if err := fn(u); err != nil {
+			return err
+		}
+		return u.promote()
+	})
+	if err != nil {
+		u.discard()
+		return err
+	}
+	u.deleteFiles()
+	return nil
+}
+
+// put writes the content of r to be stored under key once the unit of
+// work commits
+func (u *unitOfWork) put(key string, r io.Reader, size int64) error {
+	name := make([]byte, 16)
+	if _, err := rand.Read(name); err != nil {
+		return fmt.Errorf("failed to generate staging name: %v", err)
+	}
+	staging := stagingDir + "/" + hex.EncodeToString(name)
+	if err := u.store.Put(staging, r, size); err != nil {
+		return err
+	}
+	u.staged = append(u.staged, stagedFile{staging: staging, key: key})
+	return nil
+}
+
+// delete marks stored files to be deleted once the unit of work commits
+func (u *unitOfWork) delete(keys ...string) {
+	u.deletes = append(u.deletes, keys...)
+}
+
+// promote moves the staged files into place
+func (u *unitOfWork) promote() error {
+	for len(u.staged) > 0 {
+		file := u.staged[0]
+		if err := u.store.Rename(file.staging, file.key); err != nil {
+			return fmt.Errorf("failed to save file: %v", err)
+		}
+		u.staged = u.staged[1:]
+		u.moved = append(u.moved, file.key)
+	}
+	return nil
+}
+
+// discard deletes the files written by a unit of work that was rolled back
+func (u *unitOfWork) discard() {
+	for _, file := range u.staged {
+		u.store.Delete(file.staging)
+	}
+	for _, key := range u.moved {
+		u.store.Delete(key)
+	}
+}
+
+// deleteFiles deletes the files marked for deletion. The change is already
+// committed, so a file that can't be deleted is only logged; it is left
+// with no book referring to it.
+func (u *unitOfWork) deleteFiles() {
+	for _, key := range u.deletes {
+		if err := u.store.Delete(key); err != nil {
+			log.Printf("Failed to delete stored file %s: %v", key, err)
+		}
+	}
+}
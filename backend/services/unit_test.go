@@ -0,0 +1,309 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/zven/bookpavilion/mocks"
+	"github.com/zven/bookpavilion/storage"
+)
+
+// errFault is the error injected into database and storage operations
+var errFault = errors.New("injected fault")
+
+// faultyStorage fails the storage operations chosen by a test
+type faultyStorage struct {
+	storage.Storage
+	failPut      bool
+	failRenameAt int // number of the rename to fail, counting from 1
+	failDelete   bool
+
+	renames int
+}
+
+func (f *faultyStorage) Put(key string, r io.Reader, size int64) error {
+	if f.failPut {
+		return errFault
+	}
+	return f.Storage.Put(key, r, size)
+}
+
+func (f *faultyStorage) Rename(from, to string) error {
+	f.renames++
+	if f.renames == f.failRenameAt {
+		return errFault
+	}
+	return f.Storage.Rename(from, to)
+}
+
+func (f *faultyStorage) Delete(key string) error {
+	if f.failDelete {
+		return errFault
+	}
+	return f.Storage.Delete(key)
+}
+
+// countingStorage counts the stored files opened for reading
+type countingStorage struct {
+	storage.Storage
+	gets int
+}
+
+func (c *countingStorage) Get(key string) (storage.Object, error) {
+	c.gets++
+	return c.Storage.Get(key)
+}
+
+// testComic builds a comic book archive with a cover, so that storing it
+// writes the book file and its cover thumbnails
+func testComic(t *testing.T) []byte {
+	var page bytes.Buffer
+	if err := png.Encode(&page, image.NewRGBA(image.Rect(0, 0, 60, 90))); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	w, _ := zw.Create("001.png")
+	w.Write(page.Bytes())
+	zw.Close()
+	return archive.Bytes()
+}
+
+// storedKeys lists every key in a storage
+func storedKeys(t *testing.T, store storage.Storage) []string {
+	objects, err := store.List("")
+	if err != nil {
+		t.Fatalf("Failed to list stored files: %v", err)
+	}
+	keys := make([]string, len(objects))
+	for i, obj := range objects {
+		keys[i] = obj.Key
+	}
+	return keys
+}
+
+func TestCreateBookFaults(t *testing.T) {
+	comic := testComic(t)
+	hash, _ := hashUpload(bytes.NewReader(comic))
+	key := blobKey(hash)
+	cover := coverPathOf(key)
+
+	testCases := []struct {
+		name   string
+		store  *faultyStorage
+		expect func(mock sqlmock.Sqlmock)
+		stored []string // files left once CreateBook returns
+	}{
+		{
+			name:  "Success",
+			store: &faultyStorage{},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectNewBlob(mock)
+				mock.ExpectExec("INSERT INTO `books`").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			stored: []string{key, coverKey(cover, "large"), coverKey(cover, "medium"), coverKey(cover, "small")},
+		},
+		{
+			name:  "Blob Record",
+			store: &faultyStorage{},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT.*FROM `books` WHERE sha256 = ").
+					WillReturnRows(sqlmock.NewRows(mocks.BookColumns()))
+				mock.ExpectBegin()
+				mock.ExpectExec("INSERT INTO `blobs`").WillReturnError(errFault)
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:  "File Write",
+			store: &faultyStorage{failPut: true},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectNewBlob(mock)
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:  "Book Record",
+			store: &faultyStorage{},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectNewBlob(mock)
+				mock.ExpectExec("INSERT INTO `books`").WillReturnError(errFault)
+				mock.ExpectRollback()
+			},
+		},
+		{
+			// The file moved into place before the failure is deleted again
+			name:  "File Move",
+			store: &faultyStorage{failRenameAt: 2},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectNewBlob(mock)
+				mock.ExpectExec("INSERT INTO `books`").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:  "Commit",
+			store: &faultyStorage{},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectNewBlob(mock)
+				mock.ExpectExec("INSERT INTO `books`").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit().WillReturnError(errFault)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			service, mock, cleanup := setupTest(t)
+			defer cleanup()
+			tc.store.Storage = storage.NewMemory()
+			service.store = tc.store
+			tc.expect(mock)
+
+			file := mocks.NewMockFileHeader("comic.cbz", int64(len(comic)), comic)
+			book, err := service.CreateBook("Comic", "", file)
+			if tc.stored != nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if book.FilePath != key || book.CoverPath != cover {
+					t.Errorf("unexpected stored book %+v", book)
+				}
+			} else if err == nil {
+				t.Errorf("expected error but got none")
+			}
+
+			// Nothing is left behind by a failed creation, not even in staging
+			stored := storedKeys(t, tc.store.Storage)
+			if len(stored) != len(tc.stored) {
+				t.Fatalf("expected stored files %v but got %v", tc.stored, stored)
+			}
+			for i := range stored {
+				if stored[i] != tc.stored[i] {
+					t.Errorf("expected stored files %v but got %v", tc.stored, stored)
+					break
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestPurgeBookFaults(t *testing.T) {
+	comic := testComic(t)
+	hash, _ := hashUpload(bytes.NewReader(comic))
+	key := blobKey(hash)
+	cover := coverPathOf(key)
+	files := []string{key, coverKey(cover, "large"), coverKey(cover, "medium"), coverKey(cover, "small")}
+
+	expectBook := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("SELECT.*FROM `books` WHERE deleted_at IS NOT NULL AND `books`.`id` = ").
+			WithArgs(uint(1)).
+			WillReturnRows(sqlmock.NewRows(append(mocks.BookColumns(), "cover_path", "sha256")).
+				AddRow(1, "Comic", "", "cbz", key, len(comic), time.Now(), time.Now(), time.Now(), cover, hash))
+		mock.ExpectBegin()
+	}
+	expectRelease := func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec("DELETE FROM `books`").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE `blobs` SET `ref_count`=ref_count - 1").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("DELETE FROM `blobs`").WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	testCases := []struct {
+		name    string
+		store   *faultyStorage
+		expect  func(mock sqlmock.Sqlmock)
+		success bool
+		stored  []string // files left once PurgeBook returns
+	}{
+		{
+			name:  "Success",
+			store: &faultyStorage{},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectRelease(mock)
+				mock.ExpectCommit()
+			},
+			success: true,
+		},
+		{
+			name:  "Book Record",
+			store: &faultyStorage{},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("DELETE FROM `books`").WillReturnError(errFault)
+				mock.ExpectRollback()
+			},
+			stored: files,
+		},
+		{
+			name:  "Blob Release",
+			store: &faultyStorage{},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("DELETE FROM `books`").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("UPDATE `blobs` SET `ref_count`=ref_count - 1").WillReturnError(errFault)
+				mock.ExpectRollback()
+			},
+			stored: files,
+		},
+		{
+			name:  "Commit",
+			store: &faultyStorage{},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectRelease(mock)
+				mock.ExpectCommit().WillReturnError(errFault)
+			},
+			stored: files,
+		},
+		{
+			// The book is gone, so the purge succeeds; the files are left
+			// with no book referring to them
+			name:  "File Delete",
+			store: &faultyStorage{failDelete: true},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectRelease(mock)
+				mock.ExpectCommit()
+			},
+			success: true,
+			stored:  files,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			service, mock, cleanup := setupTest(t)
+			defer cleanup()
+			tc.store.Storage = storage.NewMemory()
+			service.store = tc.store
+			putTestFile(t, tc.store.Storage, key, comic)
+			for _, thumb := range files[1:] {
+				putTestFile(t, tc.store.Storage, thumb, []byte("jpeg"))
+			}
+			expectBook(mock)
+			tc.expect(mock)
+
+			err := service.PurgeBook(1)
+			if tc.success && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if !tc.success && err == nil {
+				t.Errorf("expected error but got none")
+			}
+
+			stored := storedKeys(t, tc.store.Storage)
+			if len(stored) != len(tc.stored) {
+				t.Errorf("expected stored files %v but got %v", tc.stored, stored)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zven/bookpavilion/models"
+)
+
+// UploadService manages resumable uploads of book files. A file is received
+// in chunks into a local directory and, once complete, goes through the same
+// checks and book creation as a file uploaded in one request.
+type UploadService interface {
+	CreateUpload(length int64, metadata map[string]string) (*models.Upload, error)
+	GetUpload(id string) (*models.Upload, error)
+	WriteUpload(id string, offset int64, r io.Reader) (*models.Upload, error)
+	DeleteUpload(id string) error
+	PurgeExpiredUploads() (int, error)
+}
+
+// File name extensions of the state and content of an upload
+const (
+	uploadInfoExt = ".info"
+	uploadDataExt = ".bin"
+)
+
+// uploadService implements UploadService interface
+type uploadService struct {
+	dir        string
+	expiration time.Duration
+	books      BookService
+	now        func() time.Time
+
+	mu     sync.Mutex
+	locked map[string]bool // uploads being written
+}
+
+// NewUploadService creates a new instance of UploadService keeping uploads
+// in progress in dir. Uploads without progress for longer than expiration
+// are discarded.
+func NewUploadService(dir string, expiration time.Duration, books BookService) (UploadService, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %v", err)
+	}
+	return &uploadService{
+		dir:        dir,
+		expiration: expiration,
+		books:      books,
+		now:        time.Now,
+		locked:     make(map[string]bool),
+	}, nil
+}
+
+// CreateUpload implements UploadService.CreateUpload. The metadata must
+// carry the file name, whose extension is checked as for any upload; title
+// and author are optional.
+func (s *uploadService) CreateUpload(length int64, metadata map[string]string) (*models.Upload, error) {
+	filename := metadata["filename"]
+	if filename == "" {
+		return nil, models.ErrFilenameRequired
+	}
+	if _, err := checkUploadFormat(filename, length); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate upload ID: %v", err)
+	}
+	upload := &models.Upload{
+		ID:        hex.EncodeToString(id),
+		Length:    length,
+		Metadata:  metadata,
+		ExpiresAt: s.now().Add(s.expiration),
+	}
+
+	data, err := os.OpenFile(s.path(upload.ID, uploadDataExt), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload: %v", err)
+	}
+	data.Close()
+	if err := s.save(upload); err != nil {
+		s.remove(upload.ID)
+		return nil, err
+	}
+	return upload, nil
+}
+
+// GetUpload implements UploadService.GetUpload
+func (s *uploadService) GetUpload(id string) (*models.Upload, error) {
+	return s.load(id)
+}
+
+// WriteUpload implements UploadService.WriteUpload. It appends the content
+// of r at offset, which must be the offset reached so far. Bytes received
+// before r fails are kept, so the client can resume from there. Once the
+// whole file is received it is turned into a book. A file that can't
+// become a book is discarded and the error returned; if creating the book
+// fails otherwise, the file is kept and writing at the end of the upload
+// tries again.
+func (s *uploadService) WriteUpload(id string, offset int64, r io.Reader) (*models.Upload, error) {
+	if !s.lock(id) {
+		return nil, models.ErrUploadLocked
+	}
+	defer s.unlock(id)
+
+	upload, err := s.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != upload.Offset {
+		return nil, models.ErrUploadOffsetMismatch
+	}
+
+	if !upload.Complete() {
+		if err := s.write(upload, r); err != nil {
+			return nil, err
+		}
+	}
+	if upload.Complete() && upload.BookID == 0 {
+		if err := s.complete(upload); err != nil {
+			return nil, err
+		}
+	}
+	return upload, nil
+}
+
+// write appends the content of r to an upload, up to its length, and saves
+// the offset reached
+func (s *uploadService) write(upload *models.Upload, r io.Reader) error {
+	data, err := os.OpenFile(s.path(upload.ID, uploadDataExt), os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open upload: %v", err)
+	}
+	// Drop whatever was written after the last saved offset
+	if err := data.Truncate(upload.Offset); err != nil {
+		data.Close()
+		return fmt.Errorf("failed to write upload: %v", err)
+	}
+	if _, err := data.Seek(upload.Offset, io.SeekStart); err != nil {
+		data.Close()
+		return fmt.Errorf("failed to write upload: %v", err)
+	}
+
+	n, copyErr := io.Copy(data, io.LimitReader(r, upload.Length-upload.Offset))
+	if err := data.Close(); copyErr == nil {
+		copyErr = err
+	}
+	upload.Offset += n
+	upload.ExpiresAt = s.now().Add(s.expiration)
+	if err := s.save(upload); err != nil {
+		return err
+	}
+	if copyErr != nil {
+		return fmt.Errorf("failed to write upload: %v", copyErr)
+	}
+	return nil
+}
+
+// complete creates the book of a fully received upload. Once the book is
+// created the content is deleted, and the state is kept until it expires
+// so the client can look up the book. A file rejected as a book is
+// discarded with its state; on any other error both are kept for a retry.
+func (s *uploadService) complete(upload *models.Upload) error {
+	dataPath := s.path(upload.ID, uploadDataExt)
+	book, err := s.books.CreateBookFromFile(upload.Metadata["title"], upload.Metadata["author"], &UploadedFile{
+		Filename: upload.Metadata["filename"],
+		Size:     upload.Length,
+		Open: func() (multipart.File, error) {
+			return os.Open(dataPath)
+		},
+	})
+	if err != nil {
+		if rejectedUpload(err) {
+			s.remove(upload.ID)
+		}
+		return err
+	}
+
+	upload.BookID = book.ID
+	os.Remove(dataPath)
+	return s.save(upload)
+}
+
+// rejectedUpload reports whether err rejects the file of an upload, which
+// then can't become a book however often it is tried
+func rejectedUpload(err error) bool {
+	var mismatch *models.FormatMismatchError
+	var duplicate *models.DuplicateBookError
+	switch {
+	case errors.As(err, &mismatch), errors.As(err, &duplicate), err == models.ErrInvalidFormat,
+		err == models.ErrFileTooLarge, err == models.ErrTitleRequired:
+		return true
+	default:
+		return false
+	}
+}
+
+// DeleteUpload implements UploadService.DeleteUpload
+func (s *uploadService) DeleteUpload(id string) error {
+	if !s.lock(id) {
+		return models.ErrUploadLocked
+	}
+	defer s.unlock(id)
+
+	if _, err := s.load(id); err != nil {
+		return err
+	}
+	s.remove(id)
+	return nil
+}
+
+// PurgeExpiredUploads implements UploadService.PurgeExpiredUploads. It
+// returns the number of uploads discarded.
+func (s *uploadService) PurgeExpiredUploads() (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list uploads: %v", err)
+	}
+
+	purged := 0
+	for _, entry := range entries {
+		id := strings.TrimSuffix(entry.Name(), uploadInfoExt)
+		if id == entry.Name() || !s.lock(id) {
+			continue
+		}
+		if _, err := s.load(id); err == models.ErrUploadExpired {
+			purged++
+		}
+		s.unlock(id)
+	}
+	return purged, nil
+}
+
+// load reads the state of an upload. Expired uploads are removed.
+func (s *uploadService) load(id string) (*models.Upload, error) {
+	if !validUploadID(id) {
+		return nil, models.ErrUploadNotFound
+	}
+	data, err := os.ReadFile(s.path(id, uploadInfoExt))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, models.ErrUploadNotFound
+		}
+		return nil, fmt.Errorf("failed to read upload: %v", err)
+	}
+
+	var upload models.Upload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, fmt.Errorf("failed to read upload: %v", err)
+	}
+	if s.now().After(upload.ExpiresAt) {
+		s.remove(id)
+		return nil, models.ErrUploadExpired
+	}
+	return &upload, nil
+}
+
+// save writes the state of an upload, replacing the previous state at once
+func (s *uploadService) save(upload *models.Upload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return fmt.Errorf("failed to save upload: %v", err)
+	}
+	path := s.path(upload.ID, uploadInfoExt)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to save upload: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to save upload: %v", err)
+	}
+	return nil
+}
+
+// remove deletes the state and content of an upload
+func (s *uploadService) remove(id string) {
+	os.Remove(s.path(id, uploadDataExt))
+	os.Remove(s.path(id, uploadInfoExt))
+}
+
+// path returns the path of the state or content file of an upload
+func (s *uploadService) path(id, ext string) string {
+	return filepath.Join(s.dir, id+ext)
+}
+
+// lock marks an upload as being written. It returns false if it already is.
+func (s *uploadService) lock(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locked[id] {
+		return false
+	}
+	s.locked[id] = true
+	return true
+}
+
+// unlock releases an upload marked by lock
+func (s *uploadService) unlock(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.locked, id)
+}
+
+// validUploadID reports whether id has the form of a generated upload ID,
+// which also keeps it from naming a path outside the upload directory
+func validUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
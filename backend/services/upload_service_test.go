@@ -0,0 +1,257 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/zven/bookpavilion/config"
+	"github.com/zven/bookpavilion/models"
+)
+
+func setupUploadTest(t *testing.T) (*uploadService, *bookService, sqlmock.Sqlmock, func()) {
+	books, mock, cleanup := setupTest(t)
+	dir := t.TempDir()
+	service, err := NewUploadService(dir, time.Hour, books)
+	if err != nil {
+		t.Fatalf("Failed to create upload service: %v", err)
+	}
+	return service.(*uploadService), books, mock, cleanup
+}
+
+// failingReader returns its content, then an error as if the connection broke
+type failingReader struct {
+	r io.Reader
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestCreateUpload(t *testing.T) {
+	service, _, _, cleanup := setupUploadTest(t)
+	defer cleanup()
+	defer config.SetMaxFileSize("", 0)
+
+	t.Run("Valid", func(t *testing.T) {
+		upload, err := service.CreateUpload(21, map[string]string{"filename": "book.pdf", "title": "A Book"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !validUploadID(upload.ID) || upload.Offset != 0 || upload.Length != 21 {
+			t.Errorf("unexpected upload %+v", upload)
+		}
+		stored, err := service.GetUpload(upload.ID)
+		if err != nil || stored.Metadata["title"] != "A Book" {
+			t.Errorf("unexpected stored upload %+v (%v)", stored, err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		config.SetMaxFileSize("", 1024)
+		testCases := []struct {
+			name     string
+			length   int64
+			metadata map[string]string
+			err      error
+		}{
+			{"No Filename", 21, map[string]string{"title": "A Book"}, models.ErrFilenameRequired},
+			{"Unsupported Format", 21, map[string]string{"filename": "book.doc"}, models.ErrInvalidFormat},
+			{"Too Large", 2048, map[string]string{"filename": "book.pdf"}, models.ErrFileTooLarge},
+		}
+		for _, tc := range testCases {
+			if _, err := service.CreateUpload(tc.length, tc.metadata); err != tc.err {
+				t.Errorf("%s: expected error %v but got %v", tc.name, tc.err, err)
+			}
+		}
+	})
+}
+
+func TestWriteUpload(t *testing.T) {
+	service, books, mock, cleanup := setupUploadTest(t)
+	defer cleanup()
+
+	content := []byte("%PDF-1.4 test content")
+	upload, err := service.CreateUpload(int64(len(content)), map[string]string{"filename": "book.pdf", "title": "Chunked"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("First Chunk", func(t *testing.T) {
+		got, err := service.WriteUpload(upload.ID, 0, bytes.NewReader(content[:8]))
+		if err != nil || got.Offset != 8 {
+			t.Errorf("expected offset 8 but got %+v (%v)", got, err)
+		}
+	})
+
+	t.Run("Offset Mismatch", func(t *testing.T) {
+		if _, err := service.WriteUpload(upload.ID, 4, bytes.NewReader(content[4:])); err != models.ErrUploadOffsetMismatch {
+			t.Errorf("expected error %v but got %v", models.ErrUploadOffsetMismatch, err)
+		}
+	})
+
+	t.Run("Interrupted Chunk", func(t *testing.T) {
+		if _, err := service.WriteUpload(upload.ID, 8, &failingReader{bytes.NewReader(content[8:12])}); err == nil {
+			t.Error("expected error but got none")
+		}
+		got, err := service.GetUpload(upload.ID)
+		if err != nil || got.Offset != 12 {
+			t.Errorf("expected the received bytes to be kept but got %+v (%v)", got, err)
+		}
+	})
+
+	t.Run("Locked", func(t *testing.T) {
+		service.lock(upload.ID)
+		defer service.unlock(upload.ID)
+		if _, err := service.WriteUpload(upload.ID, 12, bytes.NewReader(content[12:])); err != models.ErrUploadLocked {
+			t.Errorf("expected error %v but got %v", models.ErrUploadLocked, err)
+		}
+	})
+
+	t.Run("Last Chunk", func(t *testing.T) {
+		expectNewBlob(mock)
+		mock.ExpectExec("INSERT INTO `books`").WillReturnResult(sqlmock.NewResult(5, 1))
+		mock.ExpectCommit()
+
+		// Bytes beyond the declared length are not taken
+		got, err := service.WriteUpload(upload.ID, 12, strings.NewReader(string(content[12:])+"extra"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !got.Complete() || got.BookID != 5 {
+			t.Errorf("expected a complete upload with a book but got %+v", got)
+		}
+
+		obj, err := books.store.Get(blobKey(testPDFHash))
+		if err != nil {
+			t.Fatalf("book file not stored: %v", err)
+		}
+		defer obj.Close()
+		if data, _ := io.ReadAll(obj); !bytes.Equal(data, content) {
+			t.Errorf("expected stored content %q but got %q", content, data)
+		}
+		if _, err := os.Stat(service.path(upload.ID, uploadDataExt)); !os.IsNotExist(err) {
+			t.Errorf("expected the received content to be deleted")
+		}
+
+		// The book can still be looked up
+		if got, err := service.GetUpload(upload.ID); err != nil || got.BookID != 5 {
+			t.Errorf("unexpected completed upload %+v (%v)", got, err)
+		}
+	})
+
+	t.Run("Rejected Content", func(t *testing.T) {
+		exe := append([]byte("MZ\x90\x00\x03\x00\x00\x00"), make([]byte, 120)...)
+		upload, err := service.CreateUpload(int64(len(exe)), map[string]string{"filename": "x.pdf", "title": "Evil"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var mismatch *models.FormatMismatchError
+		if _, err := service.WriteUpload(upload.ID, 0, bytes.NewReader(exe)); !errors.As(err, &mismatch) {
+			t.Errorf("expected a format mismatch but got %v", err)
+		}
+		if _, err := service.GetUpload(upload.ID); err != models.ErrUploadNotFound {
+			t.Errorf("expected the upload to be discarded but got %v", err)
+		}
+	})
+
+	t.Run("Failed Book Creation", func(t *testing.T) {
+		upload, err := service.CreateUpload(int64(len(content)), map[string]string{"filename": "retry.pdf", "title": "Retry"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		expectNewBlob(mock)
+		mock.ExpectExec("INSERT INTO `books`").WillReturnError(errors.New("connection lost"))
+		mock.ExpectRollback()
+		if _, err := service.WriteUpload(upload.ID, 0, bytes.NewReader(content)); err == nil {
+			t.Fatal("expected error but got none")
+		}
+
+		// The received file is kept
+		got, err := service.GetUpload(upload.ID)
+		if err != nil || !got.Complete() || got.BookID != 0 {
+			t.Fatalf("expected the complete upload to be kept but got %+v (%v)", got, err)
+		}
+		if info, err := os.Stat(service.path(upload.ID, uploadDataExt)); err != nil || info.Size() != int64(len(content)) {
+			t.Errorf("expected the received content to be kept (%v)", err)
+		}
+
+		// Writing at the end tries again
+		expectNewBlob(mock)
+		mock.ExpectExec("INSERT INTO `books`").WillReturnResult(sqlmock.NewResult(6, 1))
+		mock.ExpectCommit()
+		got, err = service.WriteUpload(upload.ID, int64(len(content)), bytes.NewReader(nil))
+		if err != nil || got.BookID != 6 {
+			t.Errorf("expected the book to be created but got %+v (%v)", got, err)
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestUploadExpiration(t *testing.T) {
+	service, _, _, cleanup := setupUploadTest(t)
+	defer cleanup()
+
+	now := time.Now()
+	service.now = func() time.Time { return now }
+	first, _ := service.CreateUpload(10, map[string]string{"filename": "a.txt"})
+	second, _ := service.CreateUpload(10, map[string]string{"filename": "b.txt"})
+
+	// Progress pushes the expiry back
+	now = now.Add(40 * time.Minute)
+	if _, err := service.WriteUpload(second.ID, 0, strings.NewReader("hello")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now = now.Add(30 * time.Minute)
+	if _, err := service.GetUpload(first.ID); err != models.ErrUploadExpired {
+		t.Errorf("expected error %v but got %v", models.ErrUploadExpired, err)
+	}
+	if _, err := service.GetUpload(first.ID); err != models.ErrUploadNotFound {
+		t.Errorf("expected the expired upload to be removed but got %v", err)
+	}
+	if _, err := service.GetUpload(second.ID); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if purged, err := service.PurgeExpiredUploads(); err != nil || purged != 1 {
+		t.Errorf("expected 1 upload purged but got %d (%v)", purged, err)
+	}
+	if entries, _ := os.ReadDir(service.dir); len(entries) != 0 {
+		t.Errorf("expected no files left but got %v", entries)
+	}
+}
+
+func TestDeleteUpload(t *testing.T) {
+	service, _, _, cleanup := setupUploadTest(t)
+	defer cleanup()
+
+	upload, _ := service.CreateUpload(10, map[string]string{"filename": "a.txt"})
+	if err := service.DeleteUpload(upload.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := service.GetUpload(upload.ID); err != models.ErrUploadNotFound {
+		t.Errorf("expected error %v but got %v", models.ErrUploadNotFound, err)
+	}
+
+	// IDs can't name other files
+	for _, id := range []string{"", "../x", strings.Repeat("g", 32)} {
+		if _, err := service.GetUpload(id); err != models.ErrUploadNotFound {
+			t.Errorf("GetUpload(%q): expected error %v but got %v", id, models.ErrUploadNotFound, err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for keys that aren't stored
+	ErrNotFound = errors.New("object not found")
+	// ErrInvalidKey is returned for keys that aren't clean relative paths
+	ErrInvalidKey = errors.New("invalid object key")
+)
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Object is a stored object opened for reading, either as a stream or at
+// any offset. The caller must close it.
+type Object interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+	Info() ObjectInfo
+}
+
+// Storage keeps book files and the files derived from them, such as cover
+// thumbnails, under slash-separated keys like "covers/abc_small.jpg"
+type Storage interface {
+	// Put stores the content of r under key, replacing any object stored
+	// there. size is the length of the content, or -1 if it isn't known.
+	Put(key string, r io.Reader, size int64) error
+	// Get opens an object for reading
+	Get(key string) (Object, error)
+	// Stat describes an object without opening it
+	Stat(key string) (ObjectInfo, error)
+	// Delete removes an object. Deleting a missing object is not an error.
+	Delete(key string) error
+	// Rename moves an object to another key, replacing any object stored
+	// there
+	Rename(from, to string) error
+	// List describes the objects whose keys start with prefix, in key order
+	List(prefix string) ([]ObjectInfo, error)
+}
+
+// ValidKey reports whether key is a clean relative path that stays within
+// the storage: no leading slash, backslashes, NUL bytes or ".." elements
+func ValidKey(key string) bool {
+	if key == "" || key == "." || strings.ContainsAny(key, "\\\x00") || strings.HasPrefix(key, "/") {
+		return false
+	}
+	if path.Clean(key) != key {
+		return false
+	}
+	return key != ".." && !strings.HasPrefix(key, "../")
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testStorage checks the behaviour every Storage implementation shares
+func testStorage(t *testing.T, s Storage) {
+	content := []byte("天地玄黄，宇宙洪荒。The quick brown fox.")
+
+	t.Run("Put And Get", func(t *testing.T) {
+		if err := s.Put("books/a.txt", bytes.NewReader(content), int64(len(content))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		obj, err := s.Get("books/a.txt")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer obj.Close()
+
+		if info := obj.Info(); info.Key != "books/a.txt" || info.Size != int64(len(content)) {
+			t.Errorf("unexpected object info %+v", info)
+		}
+		data, err := io.ReadAll(obj)
+		if err != nil || !bytes.Equal(data, content) {
+			t.Errorf("expected %q but got %q (%v)", content, data, err)
+		}
+	})
+
+	t.Run("Read At And Seek", func(t *testing.T) {
+		obj, err := s.Get("books/a.txt")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer obj.Close()
+
+		buf := make([]byte, 9)
+		if n, err := obj.ReadAt(buf, 15); n != 9 || err != nil || string(buf) != "宇宙洪" {
+			t.Errorf("unexpected ReadAt result %q, %d, %v", buf[:n], n, err)
+		}
+		buf = make([]byte, 10)
+		if n, err := obj.ReadAt(buf, int64(len(content)-4)); n != 4 || err != io.EOF || string(buf[:n]) != "fox." {
+			t.Errorf("unexpected ReadAt result at the end %q, %d, %v", buf[:n], n, err)
+		}
+
+		if _, err := obj.Seek(-4, io.SeekEnd); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if rest, err := io.ReadAll(obj); err != nil || string(rest) != "fox." {
+			t.Errorf("expected %q after seeking but got %q (%v)", "fox.", rest, err)
+		}
+		if _, err := obj.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		head := make([]byte, 3)
+		if _, err := io.ReadFull(obj, head); err != nil || string(head) != "天" {
+			t.Errorf("expected %q after seeking back but got %q (%v)", "天", head, err)
+		}
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		if err := s.Put("books/b.txt", strings.NewReader("old"), -1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := s.Put("books/b.txt", strings.NewReader("new content"), -1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if info, err := s.Stat("books/b.txt"); err != nil || info.Size != 11 {
+			t.Errorf("unexpected stat result %+v, %v", info, err)
+		}
+	})
+
+	t.Run("Empty Object", func(t *testing.T) {
+		if err := s.Put("empty", bytes.NewReader(nil), 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if info, err := s.Stat("empty"); err != nil || info.Size != 0 {
+			t.Errorf("unexpected stat result %+v, %v", info, err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		if err := s.Put("covers/a_small.jpg", strings.NewReader("jpg"), 3); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		objects, err := s.List("books/")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(objects) != 2 || objects[0].Key != "books/a.txt" || objects[1].Key != "books/b.txt" {
+			t.Errorf("unexpected objects %+v", objects)
+		}
+		all, err := s.List("")
+		if err != nil || len(all) != 4 {
+			t.Errorf("expected 4 objects but got %+v (%v)", all, err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := s.Delete("books/b.txt"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := s.Stat("books/b.txt"); err != ErrNotFound {
+			t.Errorf("expected error %v but got %v", ErrNotFound, err)
+		}
+		if err := s.Delete("books/b.txt"); err != nil {
+			t.Errorf("expected deleting a missing object to succeed but got %v", err)
+		}
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		if err := s.Put("staging/c.txt", bytes.NewReader(content), int64(len(content))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := s.Put("books/c.txt", strings.NewReader("old"), 3); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := s.Rename("staging/c.txt", "books/c.txt"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if info, err := s.Stat("books/c.txt"); err != nil || info.Size != int64(len(content)) {
+			t.Errorf("expected the renamed object to replace the old one but got %+v, %v", info, err)
+		}
+		if _, err := s.Stat("staging/c.txt"); err != ErrNotFound {
+			t.Errorf("expected error %v but got %v", ErrNotFound, err)
+		}
+		if err := s.Rename("staging/c.txt", "books/d.txt"); err != ErrNotFound {
+			t.Errorf("expected error %v but got %v", ErrNotFound, err)
+		}
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		if _, err := s.Get("missing.txt"); err != ErrNotFound {
+			t.Errorf("expected error %v but got %v", ErrNotFound, err)
+		}
+	})
+
+	t.Run("Invalid Keys", func(t *testing.T) {
+		for _, key := range []string{"", "../escape", "a/../../escape", "/abs", `a\b`, "a//b", "a\x00b", "."} {
+			if err := s.Put(key, strings.NewReader("x"), 1); err != ErrInvalidKey {
+				t.Errorf("Put(%q): expected error %v but got %v", key, ErrInvalidKey, err)
+			}
+		}
+	})
+}
+
+func TestLocal(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "store")
+	s, err := NewLocal(root)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	testStorage(t, s)
+
+	// The temporary file of an interrupted write is listed, to be cleaned up
+	if err := os.WriteFile(filepath.Join(root, "books", tempPrefix+"123"), []byte("part"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	objects, err := s.List("books/")
+	if err != nil || len(objects) == 0 || objects[0].Key != "books/"+tempPrefix+"123" || !IsTempFile(objects[0].Key) {
+		t.Errorf("expected the temporary file first but got %+v (%v)", objects, err)
+	}
+	if IsTempFile("books/a.txt") {
+		t.Errorf("expected books/a.txt not to be a temporary file")
+	}
+
+	// Nothing is written outside the root
+	entries, err := os.ReadDir(filepath.Dir(root))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("expected only the storage directory but got %v (%v)", entries, err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testStorage(t, NewMemory())
+}
+
+func TestValidKey(t *testing.T) {
+	valid := []string{"a.pdf", "covers/a_small.jpg", "a..b", "..a"}
+	invalid := []string{"", ".", "..", "../a", "a/..", "a/./b", "/a", "a/", `..\a`, "a\x00"}
+	for _, key := range valid {
+		if !ValidKey(key) {
+			t.Errorf("expected %q to be valid", key)
+		}
+	}
+	for _, key := range invalid {
+		if ValidKey(key) {
+			t.Errorf("expected %q to be invalid", key)
+		}
+	}
+}
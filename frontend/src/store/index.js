@@ -8,6 +8,8 @@ export default createStore({
     totalBooks: 0,
     currentPage: 1,
     pageSize: 10,
+    // 列表查询条件：q、format、sort、order 等
+    filters: {},
     loading: false,
     error: null
   },
@@ -24,6 +26,9 @@ export default createStore({
     SET_CURRENT_PAGE(state, page) {
       state.currentPage = page
     },
+    SET_FILTERS(state, filters) {
+      state.filters = filters
+    },
     SET_LOADING(state, loading) {
       state.loading = loading
     },
@@ -32,10 +37,19 @@ export default createStore({
     }
   },
   actions: {
-    async fetchBooks({ commit }, { page = 1, pageSize = 10 } = {}) {
+    async fetchBooks({ commit, state }, { page = 1, pageSize = 10, filters } = {}) {
+      if (filters) {
+        commit('SET_FILTERS', filters)
+      }
       commit('SET_LOADING', true)
       try {
-        const response = await axios.get(`/api/books?page=${page}&page_size=${pageSize}`)
+        const params = { page, page_size: pageSize }
+        Object.entries(state.filters).forEach(([key, value]) => {
+          if (value !== '' && value !== null && value !== undefined) {
+            params[key] = value
+          }
+        })
+        const response = await axios.get('/api/books', { params })
         commit('SET_BOOKS', response.data.books)
         commit('SET_TOTAL_BOOKS', response.data.total)
         commit('SET_CURRENT_PAGE', page)
@@ -69,6 +83,11 @@ export default createStore({
         throw error
       }
     },
+    async updateBook({ commit }, { id, data }) {
+      const response = await axios.patch(`/api/books/${id}`, data)
+      commit('SET_CURRENT_BOOK', response.data)
+      return response.data
+    },
     async deleteBook({ dispatch }, id) {
       try {
         await axios.delete(`/api/books/${id}`)